@@ -0,0 +1,203 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"text/template/parse"
+
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+// jsArchiveToGo returns the contents of an archive, given either its path or
+// its contents as a Buffer or Uint8Array.
+func jsArchiveToGo(env napi.Env, value napi.Value) ([]byte, error) {
+	valueType, err := env.Typeof(value)
+	if err != nil {
+		return nil, err
+	}
+	if valueType == napi.String {
+		filename, err := jsStringToGo(env, value)
+		if err != nil {
+			return nil, err
+		}
+		return os.ReadFile(filename)
+	}
+	data, err := jsBytesToGo(env, value)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(data), nil
+}
+
+// Limits on the size of the files in an archive once they're decompressed, to
+// keep a small archive from using up memory.
+const (
+	maxArchiveFileSize  = 16 << 20
+	maxArchiveTotalSize = 256 << 20
+)
+
+// archiveToFS opens a zip, tar, or gzipped tar archive as an fs.FS, detecting
+// its format from its contents.
+func archiveToFS(data []byte) (fs.FS, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")), bytes.HasPrefix(data, []byte("PK\x05\x06")):
+		return zipToFS(data)
+	case bytes.HasPrefix(data, []byte("\x1f\x8b")):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return tarToFS(gz)
+	default:
+		return tarToFS(bytes.NewReader(data))
+	}
+}
+
+// zipToFS opens a zip archive as an fs.FS. Its files are decompressed as
+// they're read, and the zip package fails reads past the sizes given in the
+// archive's directory, so the limits are checked against those.
+func zipToFS(data []byte) (fs.FS, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	var total uint64
+	for _, file := range zr.File {
+		if file.UncompressedSize64 > maxArchiveFileSize {
+			return nil, fmt.Errorf("file in archive is too large: %q", file.Name)
+		}
+		total += file.UncompressedSize64
+		if total > maxArchiveTotalSize {
+			return nil, errors.New("files in archive are too large")
+		}
+	}
+	return zr, nil
+}
+
+// tarToFS reads the regular files and directories in a tar archive into an
+// fs.FS. Other kinds of entries, like links, are skipped.
+func tarToFS(r io.Reader) (fs.FS, error) {
+	result := memFS{}
+	var total int64
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if name == "." {
+			continue
+		}
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("invalid path in archive: %q", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			// The tar reader doesn't read past the size in the header
+			if hdr.Size > maxArchiveFileSize {
+				return nil, fmt.Errorf("file in archive is too large: %q", hdr.Name)
+			}
+			total += hdr.Size
+			if total > maxArchiveTotalSize {
+				return nil, errors.New("files in archive are too large")
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			result[name] = &memFile{data: data}
+		case tar.TypeDir:
+			result[name] = &memFile{isDir: true}
+		}
+	}
+}
+
+// parseFSPaths is like ParseFS, but names templates by their full paths in
+// fsys instead of their base names. ParseFS always uses base names, so files
+// with the same name in different directories of an archive would replace each
+// other. If tmpl is nil, a new template is created with newTemplate, named after
+// the first file. Errors match those from ParseFS.
+func parseFSPaths(tmpl goTemplate, newTemplate func(name string) goTemplate, fsys fs.FS, patterns []string) (goTemplate, error) {
+	var filenames []string
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("template: pattern matches no files: %#q", pattern)
+		}
+		filenames = append(filenames, matches...)
+	}
+	if len(filenames) == 0 {
+		return nil, errors.New("template: no files named in call to ParseFiles")
+	}
+	for _, filename := range filenames {
+		text, err := fs.ReadFile(fsys, filename)
+		if err != nil {
+			return nil, err
+		}
+		if tmpl == nil {
+			tmpl = newTemplate(filename)
+		}
+		target := tmpl
+		if filename != tmpl.Name() {
+			target = tmpl.New(filename)
+		}
+		if err := target.Parse(string(text)); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
+
+// parseFSPathsSource returns a source function for newParseError for templates
+// parsed by parseFSPaths.
+func parseFSPathsSource(fsys fs.FS) func(string) (string, string, bool) {
+	return func(parseName string) (string, string, bool) {
+		text, err := fs.ReadFile(fsys, parseName)
+		return string(text), parseName, err == nil
+	}
+}
+
+// parseArchive parses the templates in the archive given by args[0] matching
+// the patterns in the remaining args, adding them to tmpl (if not nil). Their
+// sources are recorded in assn, which is tmpl's association.
+func parseArchive(env napi.Env, tmpl goTemplate, cls *templateClass, assn *templateAssn, args []napi.Value) (goTemplate, error) {
+	data, err := jsArchiveToGo(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+	patterns, err := jsValuesToGo(env, args[1:], jsStringToGo)
+	if err != nil {
+		return nil, err
+	}
+	fsys, err := archiveToFS(data)
+	if err != nil {
+		return nil, fmt.Errorf("can't read archive: %w", err)
+	}
+	var before map[string]*parse.Tree
+	if tmpl != nil {
+		before = templateTrees(tmpl)
+	}
+	result, err := parseFSPaths(tmpl, cls.new, fsys, patterns)
+	if err != nil {
+		return nil, newParseError(err, assn.delims, parseFSPathsSource(fsys))
+	}
+	assn.AddSources(result, before, assn.delims, parseFSPathsSource(fsys))
+	return result, nil
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+// jsExceptionError holds a JS exception that was caught on the JS thread so it
+// can be propagated through Go code and rethrown.
+type jsExceptionError struct {
+	ref     napi.Ref
+	value   any // Primitive exceptions, which can't be referenced
+	message string
+}
+
+func (jse *jsExceptionError) Error() string {
+	if jse.message != "" {
+		return jse.message
+	}
+	return "JS function threw an exception"
+}
+
+// ToJs rethrows the captured exception when the error reaches JS, consuming the
+// reference to it.
+func (jse *jsExceptionError) ToJs(env napi.Env) (napi.Value, error) {
+	if jse.ref == nil {
+		return goValueToJs(env, jse.value)
+	}
+	exc, err := env.GetReferenceValue(jse.ref)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.DeleteReference(jse.ref); err != nil {
+		return nil, err
+	}
+	return exc, nil
+}
+
+// threadsafeJsCaller runs functions on the JS thread from a worker thread,
+// blocking until they complete.
+type threadsafeJsCaller struct {
+	tsc *napi.ThreadsafeCaller
+}
+
+func (tjc *threadsafeJsCaller) CallOnJsThread(fn func(napi.Env) error) error {
+	done := make(chan error, 1)
+	err := tjc.tsc.Call(func(env napi.Env, err error) {
+		if err == nil {
+			err = fn(env)
+		}
+		if err != nil {
+			// There's no JS caller to receive an exception thrown here, so
+			// capture it to be rethrown when the work completes.
+			err = captureJsException(env, err)
+		}
+		done <- err
+	})
+	if err != nil {
+		return err
+	}
+	return <-done
+}
+
+func captureJsException(env napi.Env, err error) error {
+	isPending, pendErr := env.IsExceptionPending()
+	if pendErr != nil || !isPending {
+		return err
+	}
+	exc, excErr := env.GetAndClearLastException()
+	if excErr != nil {
+		return err
+	}
+	if jsErr := newJsExceptionError(env, exc); jsErr != nil {
+		return jsErr
+	}
+	return err
+}
+
+func newJsExceptionError(env napi.Env, value napi.Value) *jsExceptionError {
+	valueType, err := env.Typeof(value)
+	if err != nil {
+		return nil
+	}
+	message := exceptionMessage(env, value)
+	if valueType != napi.Object && valueType != napi.Function {
+		goValue, err := jsValueToGo(env, value)
+		if err != nil {
+			return nil
+		}
+		return &jsExceptionError{value: goValue, message: message}
+	}
+	ref, err := env.CreateReference(value, 1)
+	if err != nil {
+		return nil
+	}
+	return &jsExceptionError{ref: ref, message: message}
+}
+
+// exceptionMessage returns the message of a JS exception if it's an Error or a
+// string, for use in Go error messages.
+func exceptionMessage(env napi.Env, value napi.Value) string {
+	valueType, err := env.Typeof(value)
+	if err != nil {
+		return ""
+	}
+	if valueType == napi.String {
+		msg, _ := jsStringToGo(env, value)
+		return msg
+	}
+	if isError, err := env.IsError(value); err != nil || !isError {
+		return ""
+	}
+	msgValue, err := env.GetNamedProperty(value, "message")
+	if err != nil {
+		// Don't let a throwing getter replace the original exception
+		_, _ = env.GetAndClearLastException()
+		return ""
+	}
+	if msgType, err := env.Typeof(msgValue); err != nil || msgType != napi.String {
+		return ""
+	}
+	msg, _ := jsStringToGo(env, msgValue)
+	return msg
+}
+
+// errorToJs converts an error from an asynchronous execution to a JS value
+// suitable for rejecting a promise, consuming any captured exception.
+func errorToJs(env napi.Env, err error) (napi.Value, error) {
+	var jsErr napi.JsError
+	if errors.As(err, &jsErr) {
+		return jsErr.ToJs(env)
+	}
+	msg, msgErr := env.CreateString(err.Error())
+	if msgErr != nil {
+		return nil, msgErr
+	}
+	return env.CreateError(nil, msg)
+}
+
+type executeFunc func(tmpl goTemplate, wr io.Writer) error
+
+// executeAsync runs exec on the libuv thread pool and returns a promise for
+// its output. The lazy values of its data, tracked by ld, are released once it
+// finishes.
+func (jst *jsTemplate) executeAsync(env napi.Env, ld *lazyData, exec executeFunc) (napi.Value, error) {
+	var buf bytes.Buffer
+	files, sources := jst.assn.files, jst.assn.sources
+	run := func(tmpl goTemplate, caller jsCaller) error {
+		if err := exec(tmpl, &buf); err != nil {
+			return newExecError(tmpl, err, files, sources)
+		}
+		return nil
+	}
+	settle := func(env napi.Env, err error) (napi.Value, error) {
+		if err != nil {
+			return nil, nil
+		}
+		return env.CreateString(buf.String())
+	}
+	return jst.runAsync(env, ld, false, run, settle)
+}
+
+// executeToStream runs exec on its own thread, passing its output to dest in
+// chunks as it's produced. The dest value must either be a
+// stream.Writable or a function to call with each chunk. The returned promise
+// resolves once all output has been handled. The lazy values of its data,
+// tracked by ld, are released once it finishes.
+func (jst *jsTemplate) executeToStream(env napi.Env, ld *lazyData, dest napi.Value, exec executeFunc) (napi.Value, error) {
+	result, err := jst.startExecuteToStream(env, ld, dest, exec)
+	if err != nil {
+		// Swallow errors here since we can't do anything about them
+		_ = ld.Release(env)
+	}
+	return result, err
+}
+
+func (jst *jsTemplate) startExecuteToStream(env napi.Env, ld *lazyData, dest napi.Value, exec executeFunc) (napi.Value, error) {
+	destType, err := env.Typeof(dest)
+	if err != nil {
+		return nil, err
+	}
+	isFunc := destType == napi.Function
+	if !isFunc {
+		var writeType napi.ValueType
+		if destType == napi.Object {
+			writeFn, err := env.GetNamedProperty(dest, "write")
+			if err != nil {
+				return nil, err
+			}
+			if writeType, err = env.Typeof(writeFn); err != nil {
+				return nil, err
+			}
+		}
+		if writeType != napi.Function {
+			// TODO: Custom error mechanism
+			err := env.ThrowTypeError("ERR_INVALID_ARG_TYPE", "Expected a writable stream or a function")
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("threw exception")
+		}
+	}
+	destRef, err := env.CreateReference(dest, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	files, sources := jst.assn.files, jst.assn.sources
+	run := func(tmpl goTemplate, caller jsCaller) error {
+		wr := newJsChunkWriter(caller, destRef, isFunc)
+		if err := exec(tmpl, wr); err != nil {
+			return newExecError(tmpl, err, files, sources)
+		}
+		return wr.Close()
+	}
+	settle := func(env napi.Env, err error) (napi.Value, error) {
+		if err := env.DeleteReference(destRef); err != nil {
+			return nil, err
+		}
+		return env.GetUndefined()
+	}
+	result, err := jst.runAsync(env, ld, true, run, settle)
+	if err != nil {
+		_ = env.DeleteReference(destRef)
+		return nil, err
+	}
+	return result, nil
+}
+
+// asyncRunFunc is run on a worker thread. JS functions must be called through
+// the provided caller.
+type asyncRunFunc func(tmpl goTemplate, caller jsCaller) error
+
+// asyncSettleFunc is run on the JS thread when an asyncRunFunc finishes, and
+// returns the value to resolve the promise with. If err is non-nil, the promise
+// will be rejected and the return value is ignored.
+type asyncSettleFunc func(env napi.Env, err error) (napi.Value, error)
+
+// runAsync runs run on a worker thread and returns a promise for the result of
+// settle. The worker gets a snapshot of the template, and calls JS functions on
+// the JS thread through a thread-safe function, as it does to fill in the lazy
+// values tracked by ld. They're released once it finishes, as they are if
+// runAsync fails.
+//
+// The worker is normally one of the libuv thread pool's threads. Work that
+// waits on JS, like stream backpressure, must set ownThread to run on a thread
+// of its own instead: the pool has only a few threads, and the JS being waited
+// on may need one of them (as fs streams do), so waiting there can deadlock.
+func (jst *jsTemplate) runAsync(env napi.Env, ld *lazyData, ownThread bool, run asyncRunFunc, settle asyncSettleFunc) (napi.Value, error) {
+	modData, err := getInstanceData(env)
+	if err != nil {
+		_ = ld.Release(env)
+		return nil, err
+	}
+	tsc, err := napi.NewThreadsafeCaller(env, "go-text-template-napi:execute")
+	if err != nil {
+		_ = ld.Release(env)
+		return nil, err
+	}
+	cleanup := func(env napi.Env, assn *templateAssn) {
+		// Swallow errors here since we can't do anything about them
+		_ = ld.Release(env)
+		_ = tsc.Release()
+		if assn != nil {
+			_ = assn.MaybeFinalize(env)
+		}
+	}
+
+	// Cloning the association takes a reference to each JS function, so they
+	// stay alive even if the template is finalized or they're replaced.
+	clonedAssn, err := jst.assn.Clone(env)
+	if err != nil {
+		cleanup(env, nil)
+		return nil, err
+	}
+	snapshot, ld, err := jst.execTemplate(env, &modData.envStack, ld, true)
+	if err != nil {
+		cleanup(env, clonedAssn)
+		return nil, err
+	}
+	caller := &threadsafeJsCaller{tsc}
+	conversion := jst.assn.conversion
+
+	promise, deferred, err := env.CreatePromise()
+	if err != nil {
+		cleanup(env, clonedAssn)
+		return nil, err
+	}
+	var runErr error
+	execute := func() {
+		modData.envStack.EnterWorker(caller, conversion, ld)
+		defer modData.envStack.ExitWorker()
+		runErr = run(snapshot, caller)
+	}
+	complete := func(env napi.Env, status error) error {
+		defer cleanup(env, clonedAssn)
+		if status != nil {
+			runErr = status
+		}
+		resolution, err := settle(env, runErr)
+		if err != nil {
+			return err
+		}
+		if runErr != nil {
+			rejection, err := errorToJs(env, runErr)
+			if err != nil {
+				return err
+			}
+			return env.RejectDeferred(deferred, rejection)
+		}
+		return env.ResolveDeferred(deferred, resolution)
+	}
+	if ownThread {
+		go func() {
+			// The envStack identifies workers by their thread
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			execute()
+			_ = tsc.Call(func(env napi.Env, err error) {
+				if err != nil {
+					// The environment is shutting down
+					return
+				}
+				if err := complete(env, nil); err != nil {
+					reportUncaught(env, err)
+				}
+			})
+		}()
+		return promise, nil
+	}
+	if err := napi.QueueAsyncWork(env, "go-text-template-napi:execute", execute, complete); err != nil {
+		cleanup(env, clonedAssn)
+		return nil, err
+	}
+	return promise, nil
+}
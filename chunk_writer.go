@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+// chunkSize bounds the amount of output buffered in Go before it is handed to
+// JS. It matches the default highWaterMark of a Node Writable stream.
+const chunkSize = 16 * 1024
+
+// jsChunkWriter is an io.Writer that passes its output to JS as Buffers of at
+// most chunkSize bytes, either by writing them to a stream.Writable or by
+// calling a function with them. It must be used off the JS thread, as it
+// blocks while the destination applies backpressure.
+type jsChunkWriter struct {
+	caller  jsCaller
+	destRef napi.Ref
+	isFunc  bool
+	buf     []byte
+
+	// pending holds channels that receive the results of chunks the
+	// destination hasn't finished handling
+	pending []<-chan error
+}
+
+func newJsChunkWriter(caller jsCaller, destRef napi.Ref, isFunc bool) *jsChunkWriter {
+	return &jsChunkWriter{caller, destRef, isFunc, make([]byte, 0, chunkSize), nil}
+}
+
+func (w *jsChunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(chunkSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(w.buf) == chunkSize {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close passes any buffered output to JS, and waits for the destination to
+// finish processing it.
+func (w *jsChunkWriter) Close() error {
+	if len(w.buf) > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	return w.checkPending(true)
+}
+
+// checkPending removes the chunks the destination has finished handling from
+// pending, returning the first error among them. If wait is set, it waits for
+// all of them.
+func (w *jsChunkWriter) checkPending(wait bool) error {
+	var firstErr error
+	remaining := w.pending[:0]
+	for _, result := range w.pending {
+		if wait {
+			if err := <-result; err != nil && firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		select {
+		case err := <-result:
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		default:
+			remaining = append(remaining, result)
+		}
+	}
+	clear(w.pending[len(remaining):])
+	w.pending = remaining
+	return firstErr
+}
+
+func (w *jsChunkWriter) flush() error {
+	// Surface errors from earlier chunks that didn't need to be waited on
+	if err := w.checkPending(false); err != nil {
+		return err
+	}
+
+	var blocked bool
+	err := w.caller.CallOnJsThread(func(env napi.Env) error {
+		var err error
+		blocked, err = w.sendChunk(env)
+		return err
+	})
+	// The chunk was copied into a JS Buffer, so the Go buffer can be reused
+	w.buf = w.buf[:0]
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return w.checkPending(true)
+	}
+	return nil
+}
+
+// sendChunk runs on the JS thread and hands the buffered output to the
+// destination, adding a channel for the result to pending if it's handled
+// asynchronously. It reports whether the destination applied backpressure, in
+// which case no more output should be sent until it has handled all of the
+// pending chunks.
+func (w *jsChunkWriter) sendChunk(env napi.Env) (bool, error) {
+	dest, err := env.GetReferenceValue(w.destRef)
+	if err != nil {
+		return false, err
+	}
+	chunk, err := env.CreateBufferCopy(w.buf)
+	if err != nil {
+		return false, err
+	}
+
+	// Chunk callbacks apply backpressure by returning a thenable
+	if w.isFunc {
+		undefVal, err := env.GetUndefined()
+		if err != nil {
+			return false, err
+		}
+		result, err := env.CallFunction(undefVal, dest, []napi.Value{chunk})
+		if err != nil {
+			return false, err
+		}
+		done, err := waitForThenable(env, result)
+		if err != nil || done == nil {
+			return false, err
+		}
+		w.pending = append(w.pending, done)
+		return true, nil
+	}
+
+	// Streams apply backpressure by returning false from write. The write
+	// callback is always called once the chunk is handled (or fails), so
+	// waiting for the callbacks of every write so far is used instead of the
+	// 'drain' event to avoid managing listeners.
+	writeFn, err := env.GetNamedProperty(dest, "write")
+	if err != nil {
+		return false, err
+	}
+	signals, done, err := makeJsSignals(env, signalErrorIfPresent)
+	if err != nil {
+		return false, err
+	}
+	result, err := env.CallFunction(dest, writeFn, []napi.Value{chunk, signals[0]})
+	if err != nil {
+		return false, err
+	}
+	w.pending = append(w.pending, done)
+	resultType, err := env.Typeof(result)
+	if err != nil {
+		return false, err
+	}
+	if resultType != napi.Boolean {
+		return false, nil
+	}
+	ok, err := env.GetValueBool(result)
+	return !ok, err
+}
+
+func waitForThenable(env napi.Env, value napi.Value) (<-chan error, error) {
+	valueType, err := env.Typeof(value)
+	if err != nil {
+		return nil, err
+	}
+	if valueType != napi.Object && valueType != napi.Function {
+		return nil, nil
+	}
+	thenFn, err := env.GetNamedProperty(value, "then")
+	if err != nil {
+		return nil, err
+	}
+	thenType, err := env.Typeof(thenFn)
+	if err != nil {
+		return nil, err
+	}
+	if thenType != napi.Function {
+		return nil, nil
+	}
+	signals, done, err := makeJsSignals(env, signalIgnoreArg, signalErrorAlways)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := env.CallFunction(value, thenFn, signals); err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+type signalKind int
+
+const (
+	// signalIgnoreArg signals success regardless of the argument
+	signalIgnoreArg signalKind = iota
+	// signalErrorIfPresent signals failure if the argument isn't nullish
+	signalErrorIfPresent
+	// signalErrorAlways signals failure with the argument as the reason
+	signalErrorAlways
+)
+
+// makeJsSignals creates a JS function for each kind, which reports the outcome
+// of an asynchronous JS operation back to Go. Only the first of these functions
+// to be called has any effect, and the outcome is delivered on the returned
+// channel.
+func makeJsSignals(env napi.Env, kinds ...signalKind) ([]napi.Value, <-chan error, error) {
+	done := make(chan error, 1)
+	signals := make([]napi.Value, 0, len(kinds))
+	cleanups := make([]func(), 0, len(kinds))
+	fired := false
+	fire := func(err error) {
+		if fired {
+			return
+		}
+		fired = true
+		done <- err
+		// Once one signal fires the others will never be called, so it's
+		// safe to release all of them.
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+	}
+	for _, kind := range kinds {
+		cb, cbData, cleanup := makeStaticMethodCallback(func(env napi.Env, args []napi.Value) (napi.Value, error) {
+			argType, err := env.Typeof(args[0])
+			if err != nil {
+				fire(err)
+				return nil, nil
+			}
+			nullish := argType == napi.Undefined || argType == napi.Null
+			if kind == signalIgnoreArg || (kind == signalErrorIfPresent && nullish) {
+				fire(nil)
+			} else if nullish {
+				fire(fmt.Errorf("JS operation failed without a reason"))
+			} else if jsErr := newJsExceptionError(env, args[0]); jsErr != nil {
+				fire(jsErr)
+			} else {
+				fire(fmt.Errorf("JS operation failed"))
+			}
+			return nil, nil
+		}, 1)
+		cleanups = append(cleanups, cleanup)
+		signal, err := env.CreateFunction("", cbData, cb)
+		if err != nil {
+			for _, cleanup := range cleanups {
+				cleanup()
+			}
+			return nil, nil, err
+		}
+		signals = append(signals, signal)
+	}
+	return signals, done, nil
+}
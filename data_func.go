@@ -0,0 +1,90 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+// refReleaser queues references to be deleted on the JS thread. References
+// held by Go values are queued when the values are garbage collected, which
+// can happen on any thread.
+type refReleaser struct {
+	mu      sync.Mutex
+	pending []napi.Ref
+}
+
+func (rr *refReleaser) Queue(refs []napi.Ref) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.pending = append(rr.pending, refs...)
+}
+
+// Release deletes all queued references. It must be called on the JS thread.
+func (rr *refReleaser) Release(env napi.Env) error {
+	rr.mu.Lock()
+	pending := rr.pending
+	rr.pending = nil
+	rr.mu.Unlock()
+	for _, ref := range pending {
+		if err := env.DeleteReference(ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dataFunc is a JS function found in template data. Its references are queued
+// for release once it's no longer reachable from Go.
+type dataFunc struct {
+	call func(...interface{}) (interface{}, error)
+}
+
+func (df *dataFunc) Call(args ...interface{}) (interface{}, error) {
+	return df.call(args...)
+}
+
+// jsFunc is the type of the Go functions JS functions in template data are
+// converted to. Like JSON.stringify in arrays, encoding/json encodes them as
+// null, and they're printed as "[Function]" rather than as an address, so data
+// holding them, like the methods of class instances, can still be encoded and
+// printed.
+type jsFunc func(...interface{}) (interface{}, error)
+
+func (jsFunc) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
+
+func (jsFunc) String() string {
+	return "[Function]"
+}
+
+// makeDataFunc converts a JS function to a Go function that calls it with this
+// bound to thisValue, or undefined if thisValue is nil.
+func makeDataFunc(env napi.Env, fn napi.Value, thisValue napi.Value) (interface{}, error) {
+	modData, err := getInstanceData(env)
+	if err != nil {
+		return nil, err
+	}
+	// Take the opportunity to clean up after functions that are gone
+	if err := modData.releaser.Release(env); err != nil {
+		return nil, err
+	}
+	fnRef, err := env.CreateReference(fn, 1)
+	if err != nil {
+		return nil, err
+	}
+	refs := []napi.Ref{fnRef}
+	var thisRef napi.Ref
+	if thisValue != nil {
+		if thisRef, err = env.CreateReference(thisValue, 1); err != nil {
+			_ = env.DeleteReference(fnRef)
+			return nil, err
+		}
+		refs = append(refs, thisRef)
+	}
+	df := &dataFunc{makeJsCallback(&modData.envStack, fnRef, thisRef)}
+	runtime.AddCleanup(df, modData.releaser.Queue, refs)
+	return jsFunc(df.Call), nil
+}
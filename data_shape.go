@@ -0,0 +1,322 @@
+package main
+
+import (
+	"strings"
+	"text/template/parse"
+)
+
+// dataShape records how a template uses a value from its data.
+type dataShape struct {
+	// fields holds the shapes of the fields accessed on the value
+	fields map[string]*dataShape
+	// elem is the shape of the value's elements, if it was ranged over
+	elem *dataShape
+	// printed is set if the value was written to the template's output
+	printed bool
+	// tested is set if the value's truth was tested by an if or with action
+	tested bool
+	// whole is set if the value was used in a way that might depend on all
+	// of it, such as being passed to a function or reassigned to a variable
+	whole bool
+}
+
+func markWhole(shapes ...*dataShape) {
+	for _, shape := range shapes {
+		if shape != nil {
+			shape.whole = true
+		}
+	}
+}
+
+func (ds *dataShape) field(name string) *dataShape {
+	if ds.fields == nil {
+		ds.fields = make(map[string]*dataShape)
+	}
+	result, ok := ds.fields[name]
+	if !ok {
+		result = &dataShape{}
+		ds.fields[name] = result
+	}
+	return result
+}
+
+func (ds *dataShape) element() *dataShape {
+	if ds.elem == nil {
+		ds.elem = &dataShape{}
+	}
+	return ds.elem
+}
+
+func (ds *dataShape) kind() string {
+	switch {
+	case ds.elem != nil:
+		return "array"
+	case len(ds.fields) > 0:
+		return "object"
+	case ds.printed:
+		return "scalar"
+	default:
+		return "any"
+	}
+}
+
+// toGo converts the shape to a JSON-like representation suitable for passing
+// to goValueToJs.
+func (ds *dataShape) toGo() map[string]any {
+	result := map[string]any{"type": ds.kind()}
+	if len(ds.fields) > 0 {
+		fields := make(map[string]any, len(ds.fields))
+		for name, field := range ds.fields {
+			fields[name] = field.toGo()
+		}
+		result["fields"] = fields
+	}
+	if ds.elem != nil {
+		result["elem"] = ds.elem.toGo()
+	}
+	return result
+}
+
+// toJSONSchema converts the shape to a JSON Schema. Since templates can't
+// require fields to be present, no properties are marked as required.
+func (ds *dataShape) toJSONSchema() map[string]any {
+	result := map[string]any{}
+	switch ds.kind() {
+	case "array":
+		result["type"] = "array"
+		result["items"] = ds.elem.toJSONSchema()
+	case "object":
+		result["type"] = "object"
+	case "scalar":
+		result["type"] = []any{"boolean", "number", "string"}
+	}
+	if len(ds.fields) > 0 {
+		props := make(map[string]any, len(ds.fields))
+		for name, field := range ds.fields {
+			props[name] = field.toJSONSchema()
+		}
+		result["properties"] = props
+	}
+	return result
+}
+
+func fieldPath(shape *dataShape, idents []string) *dataShape {
+	for _, ident := range idents {
+		if shape == nil {
+			return nil
+		}
+		shape = shape.field(ident)
+	}
+	return shape
+}
+
+// shapeScope tracks the values of dot and any variables while walking a
+// template. A nil shape means the value didn't come from the template's data
+// (e.g. it was returned by a function).
+type shapeScope struct {
+	dot  *dataShape
+	vars map[string]*dataShape
+}
+
+func (ss *shapeScope) child(dot *dataShape) *shapeScope {
+	vars := make(map[string]*dataShape, len(ss.vars))
+	for name, shape := range ss.vars {
+		vars[name] = shape
+	}
+	return &shapeScope{dot, vars}
+}
+
+func (ss *shapeScope) declare(decls []*parse.VariableNode, isAssign bool, shape *dataShape) {
+	for _, decl := range decls {
+		if isAssign {
+			// The variable could hold either value later on, depending
+			// on which branches were taken
+			markWhole(ss.vars[decl.Ident[0]], shape)
+		}
+		ss.vars[decl.Ident[0]] = shape
+	}
+}
+
+// shapeInferrer walks the parse trees of an associated set of templates to
+// determine the shape of the data they use.
+type shapeInferrer struct {
+	tmpl goTemplate
+	// active holds the names of the templates being walked, to avoid
+	// infinite recursion
+	active map[string]bool
+	// jsFuncs holds the names of functions that pass their arguments to JS,
+	// which receives lazy values as the JS values they came from. Their
+	// results are recorded in jsResults.
+	jsFuncs   map[string]bool
+	jsResults []jsResult
+}
+
+// jsResult records the shape of the result of a command calling a JS
+// function, within the pipeline containing it.
+type jsResult struct {
+	pipe  *parse.PipeNode
+	cmd   *parse.CommandNode
+	shape *dataShape
+}
+
+func inferDataShape(tmpl goTemplate) *dataShape {
+	root := &dataShape{}
+	si := &shapeInferrer{tmpl: tmpl, active: make(map[string]bool)}
+	si.walkTemplate(tmpl.Name(), root)
+	return root
+}
+
+func (si *shapeInferrer) walkTemplate(name string, dot *dataShape) {
+	if si.active[name] {
+		return
+	}
+	tmpl := si.tmpl.Lookup(name)
+	if tmpl == nil || tmpl.Tree() == nil || tmpl.Tree().Root == nil {
+		return
+	}
+	si.active[name] = true
+	defer delete(si.active, name)
+	scope := &shapeScope{dot, map[string]*dataShape{"$": dot}}
+	si.walkNode(scope, tmpl.Tree().Root)
+}
+
+func (si *shapeInferrer) walkNode(scope *shapeScope, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ActionNode:
+		result := si.walkPipe(scope, n.Pipe)
+		scope.declare(n.Pipe.Decl, n.Pipe.IsAssign, result)
+		if len(n.Pipe.Decl) == 0 && result != nil {
+			result.printed = true
+		}
+	case *parse.IfNode:
+		inner := scope.child(scope.dot)
+		cond := si.walkPipe(inner, n.Pipe)
+		if cond != nil {
+			cond.tested = true
+		}
+		inner.declare(n.Pipe.Decl, n.Pipe.IsAssign, cond)
+		si.walkNode(inner.child(scope.dot), n.List)
+		if n.ElseList != nil {
+			si.walkNode(inner.child(scope.dot), n.ElseList)
+		}
+	case *parse.ListNode:
+		for _, child := range n.Nodes {
+			si.walkNode(scope, child)
+		}
+	case *parse.RangeNode:
+		inner := scope.child(scope.dot)
+		var elem *dataShape
+		if coll := si.walkPipe(inner, n.Pipe); coll != nil {
+			elem = coll.element()
+		}
+		// With two variables, the first is bound to the key or index
+		decls := n.Pipe.Decl
+		if len(decls) == 2 {
+			inner.declare(decls[:1], n.Pipe.IsAssign, nil)
+			decls = decls[1:]
+		}
+		inner.declare(decls, n.Pipe.IsAssign, elem)
+		si.walkNode(inner.child(elem), n.List)
+		if n.ElseList != nil {
+			si.walkNode(inner.child(scope.dot), n.ElseList)
+		}
+	case *parse.TemplateNode:
+		si.walkTemplate(n.Name, si.walkPipe(scope, n.Pipe))
+	case *parse.WithNode:
+		inner := scope.child(scope.dot)
+		result := si.walkPipe(inner, n.Pipe)
+		if result != nil {
+			result.tested = true
+		}
+		inner.declare(n.Pipe.Decl, n.Pipe.IsAssign, result)
+		si.walkNode(inner.child(result), n.List)
+		if n.ElseList != nil {
+			si.walkNode(inner.child(scope.dot), n.ElseList)
+		}
+	}
+}
+
+// walkPipe returns the shape of the pipeline's result.
+func (si *shapeInferrer) walkPipe(scope *shapeScope, pipe *parse.PipeNode) *dataShape {
+	if pipe == nil {
+		return nil
+	}
+	var result *dataShape
+	for i, cmd := range pipe.Cmds {
+		result = si.walkCommand(scope, cmd, result, i > 0)
+		if ident, ok := cmd.Args[0].(*parse.IdentifierNode); ok && si.jsFuncs[ident.Ident] {
+			result = &dataShape{}
+			si.jsResults = append(si.jsResults, jsResult{pipe, cmd, result})
+		}
+	}
+	return result
+}
+
+func (si *shapeInferrer) walkCommand(scope *shapeScope, cmd *parse.CommandNode, piped *dataShape, hasPiped bool) *dataShape {
+	args := make([]*dataShape, 0, len(cmd.Args))
+	for _, arg := range cmd.Args {
+		args = append(args, si.walkArg(scope, arg))
+	}
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok {
+		// Any other arguments would be passed to a method, but there's no
+		// way to know what it returns.
+		if len(args) > 1 || hasPiped {
+			markWhole(args...)
+			markWhole(piped)
+			return nil
+		}
+		return args[0]
+	}
+	switch {
+	case strings.HasPrefix(ident.Ident, "_html_template_"):
+		// Escapers inserted by html/template pass their input to the output
+		if hasPiped {
+			return piped
+		}
+		if len(args) == 2 {
+			return args[1]
+		}
+	case ident.Ident == "index" && !hasPiped && len(args) > 1:
+		result := args[1]
+		for _, key := range cmd.Args[2:] {
+			if result == nil {
+				break
+			}
+			switch k := key.(type) {
+			case *parse.StringNode:
+				result = result.field(k.Text)
+			case *parse.NumberNode:
+				result = result.element()
+			default:
+				markWhole(result)
+				result = nil
+			}
+		}
+		return result
+	}
+	if si.jsFuncs[ident.Ident] {
+		return nil
+	}
+	// The arguments are passed to a function, which could use all of them
+	markWhole(args...)
+	markWhole(piped)
+	return nil
+}
+
+func (si *shapeInferrer) walkArg(scope *shapeScope, node parse.Node) *dataShape {
+	switch n := node.(type) {
+	case *parse.ChainNode:
+		return fieldPath(si.walkArg(scope, n.Node), n.Field)
+	case *parse.DotNode:
+		return scope.dot
+	case *parse.FieldNode:
+		return fieldPath(scope.dot, n.Ident)
+	case *parse.PipeNode:
+		return si.walkPipe(scope, n)
+	case *parse.VariableNode:
+		return fieldPath(scope.vars[n.Ident[0]], n.Ident[1:])
+	default:
+		return nil
+	}
+}
@@ -2,34 +2,119 @@ package main
 
 import (
 	"container/list"
+	"fmt"
+	"sync"
 
 	"github.com/drakedevel/go-text-template-napi/internal/napi"
 )
 
+// envStackEntry records an env on the stack, along with the conversion options
+// of the template being executed with it, and the lazy values of its data.
+type envStackEntry struct {
+	env        napi.Env
+	conversion conversionOptions
+	data       *lazyData
+}
+
+// workerEntry records a worker thread registered with EnterWorker.
+type workerEntry struct {
+	caller     jsCaller
+	conversion conversionOptions
+	data       *lazyData
+}
+
 type envStack struct {
 	list *list.List
+
+	// jsThread identifies the thread that owns the envs on the stack
+	jsThread uintptr
+
+	// workers maps the IDs of worker threads to workerEntries holding the
+	// jsCallers they should use to run code on the JS thread
+	workers *sync.Map
 }
 
 func newEnvStack() envStack {
-	return envStack{list.New()}
+	return envStack{list.New(), napi.CurrentThreadID(), new(sync.Map)}
 }
 
-func (es *envStack) Enter(env napi.Env) {
-	es.list.PushBack(env)
+func (es *envStack) Enter(env napi.Env, conversion conversionOptions, data *lazyData) {
+	es.list.PushBack(envStackEntry{env, conversion, data})
 }
 
-func (es *envStack) Current() napi.Env {
+func (es *envStack) current() envStackEntry {
 	back := es.list.Back()
 	if back == nil {
 		panic("uh-oh")
 	}
-	return back.Value.(napi.Env)
+	return back.Value.(envStackEntry)
+}
+
+func (es *envStack) Current() napi.Env {
+	return es.current().env
 }
 
 func (es *envStack) Exit(env napi.Env) {
 	back := es.list.Back()
-	if back == nil || back.Value != env {
+	if back == nil || back.Value.(envStackEntry).env != env {
 		panic("uh-oh") // XXX
 	}
 	es.list.Remove(back)
 }
+
+// EnterWorker registers the calling worker thread, so JS functions it calls are
+// run using caller, and their results converted using conversion. The data
+// holds the lazy values of the template's data.
+func (es *envStack) EnterWorker(caller jsCaller, conversion conversionOptions, data *lazyData) {
+	es.workers.Store(napi.CurrentThreadID(), workerEntry{caller, conversion, data})
+}
+
+func (es *envStack) ExitWorker() {
+	es.workers.Delete(napi.CurrentThreadID())
+}
+
+// CallOnJsThread runs fn with the innermost active env. When called from a
+// worker thread registered with EnterWorker, it's run using the worker's
+// jsCaller instead.
+func (es *envStack) CallOnJsThread(fn func(napi.Env) error) error {
+	thread := napi.CurrentThreadID()
+	if thread == es.jsThread {
+		return fn(es.Current())
+	}
+	worker, ok := es.workers.Load(thread)
+	if !ok {
+		return fmt.Errorf("can't call JS functions from this thread")
+	}
+	return worker.(workerEntry).caller.CallOnJsThread(fn)
+}
+
+// Conversion returns the conversion options of the template being executed by
+// the calling thread.
+func (es *envStack) Conversion() conversionOptions {
+	thread := napi.CurrentThreadID()
+	if thread == es.jsThread {
+		return es.current().conversion
+	}
+	worker, ok := es.workers.Load(thread)
+	if !ok {
+		return defaultConversionOptions
+	}
+	return worker.(workerEntry).conversion
+}
+
+// LazyData returns the lazy values of the data of the template being executed
+// by the calling thread, or nil if there are none.
+func (es *envStack) LazyData() *lazyData {
+	thread := napi.CurrentThreadID()
+	if thread == es.jsThread {
+		if es.list.Len() == 0 {
+			return nil
+		}
+		return es.current().data
+	}
+	worker, ok := es.workers.Load(thread)
+	if !ok {
+		return nil
+	}
+	return worker.(workerEntry).data
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+// jsSourceToFS adapts the source argument of parseFS to an fs.FS. It's either
+// an object mapping paths to contents, or a loader object with readFile and
+// (optionally) readDir methods.
+func jsSourceToFS(env napi.Env, source napi.Value) (fs.FS, error) {
+	sourceType, err := env.Typeof(source)
+	if err != nil {
+		return nil, err
+	}
+	if sourceType != napi.Object {
+		// TODO: Custom error mechanism
+		if err := env.ThrowTypeError("ERR_INVALID_ARG_TYPE", "Expected an object mapping paths to contents, or a loader"); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("threw exception")
+	}
+	readFile, err := env.GetNamedProperty(source, "readFile")
+	if err != nil {
+		return nil, err
+	}
+	readFileType, err := env.Typeof(readFile)
+	if err != nil {
+		return nil, err
+	}
+	if readFileType == napi.Function {
+		return &jsLoaderFS{env, source}, nil
+	}
+	return jsMapToFS(env, source)
+}
+
+// jsMapToFS converts an object mapping paths to contents to an fs.FS.
+func jsMapToFS(env napi.Env, source napi.Value) (fs.FS, error) {
+	propNames, err := env.GetAllPropertyNames(source, napi.KeyOwnOnly, napi.KeySkipSymbols|napi.KeyEnumerable, napi.KeyNumbersToStrings)
+	if err != nil {
+		return nil, err
+	}
+	length, err := env.GetArrayLength(propNames)
+	if err != nil {
+		return nil, err
+	}
+	result := make(memFS, length)
+	for i := range length {
+		key, err := env.GetElement(propNames, i)
+		if err != nil {
+			return nil, err
+		}
+		name, err := jsStringToGo(env, key)
+		if err != nil {
+			return nil, err
+		}
+		if !fs.ValidPath(name) {
+			msg := fmt.Sprintf("Invalid template path '%s'", name)
+			if err := env.ThrowTypeError("ERR_INVALID_ARG_VALUE", msg); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("threw exception")
+		}
+		contents, err := env.GetProperty(source, key)
+		if err != nil {
+			return nil, err
+		}
+		data, err := jsBytesToGo(env, contents)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = &memFile{data: bytes.Clone(data)}
+	}
+	return result, nil
+}
+
+// jsLoaderFS adapts a JS loader object to an fs.FS. Its readFile method takes a
+// path and returns the file's contents, or undefined if it doesn't exist. Its
+// readDir method takes a path and returns an array of names, or of objects
+// with name and isDirectory properties (like fs.Dirent). Paths are
+// slash-separated and relative, as in io/fs.
+//
+// The methods are called directly, so it must only be used on the JS thread.
+// Exceptions they throw are captured and returned as errors.
+type jsLoaderFS struct {
+	env    napi.Env
+	loader napi.Value
+}
+
+// call calls a method of the loader with a path, returning nil if it returned
+// null or undefined.
+func (lfs *jsLoaderFS) call(op string, method string, name string) (napi.Value, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	env := lfs.env
+	fn, err := env.GetNamedProperty(lfs.loader, method)
+	if err != nil {
+		return nil, err
+	}
+	fnType, err := env.Typeof(fn)
+	if err != nil {
+		return nil, err
+	}
+	if fnType != napi.Function {
+		return nil, &fs.PathError{Op: op, Path: name, Err: errors.ErrUnsupported}
+	}
+	nameValue, err := env.CreateString(name)
+	if err != nil {
+		return nil, err
+	}
+	result, err := env.CallFunction(lfs.loader, fn, []napi.Value{nameValue})
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: captureLoaderException(env, err)}
+	}
+	resultType, err := env.Typeof(result)
+	if err != nil {
+		return nil, err
+	}
+	if resultType == napi.Undefined || resultType == napi.Null {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// captureLoaderException is like captureJsException, but converts errors with
+// code ENOENT, like those thrown by Node's fs functions, to fs.ErrNotExist.
+func captureLoaderException(env napi.Env, err error) error {
+	isPending, pendErr := env.IsExceptionPending()
+	if pendErr != nil || !isPending {
+		return err
+	}
+	exc, excErr := env.GetAndClearLastException()
+	if excErr != nil {
+		return err
+	}
+	if excType, err := env.Typeof(exc); err == nil && excType == napi.Object {
+		code, err := env.GetNamedProperty(exc, "code")
+		if err != nil {
+			// Don't let a throwing getter replace the original exception
+			_, _ = env.GetAndClearLastException()
+		} else if codeType, err := env.Typeof(code); err == nil && codeType == napi.String {
+			if codeStr, _ := jsStringToGo(env, code); codeStr == "ENOENT" {
+				return fs.ErrNotExist
+			}
+		}
+	}
+	if jsErr := newJsExceptionError(env, exc); jsErr != nil {
+		return jsErr
+	}
+	return err
+}
+
+func (lfs *jsLoaderFS) ReadFile(name string) ([]byte, error) {
+	result, err := lfs.call("readFile", "readFile", name)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, &fs.PathError{Op: "readFile", Path: name, Err: fs.ErrNotExist}
+	}
+	data, err := jsBytesToGo(lfs.env, result)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readFile", Path: name, Err: captureJsException(lfs.env, err)}
+	}
+	return bytes.Clone(data), nil
+}
+
+func (lfs *jsLoaderFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	result, err := lfs.call("readDir", "readDir", name)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, &fs.PathError{Op: "readDir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries, err := jsDirEntriesToGo(lfs.env, result)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readDir", Path: name, Err: captureJsException(lfs.env, err)}
+	}
+	// Let memFS take care of sorting and describing the entries
+	dir := memFS{name: &memFile{isDir: true}}
+	for entryName, isDir := range entries {
+		dir[path.Join(name, entryName)] = &memFile{isDir: isDir}
+	}
+	return dir.ReadDir(name)
+}
+
+// jsDirEntriesToGo converts the result of readDir to a map from the names of
+// its entries to whether they're directories.
+func jsDirEntriesToGo(env napi.Env, entries napi.Value) (map[string]bool, error) {
+	isArray, err := env.IsArray(entries)
+	if err != nil {
+		return nil, err
+	}
+	if !isArray {
+		if err := env.ThrowTypeError("ERR_INVALID_RETURN_VALUE", "Expected readDir to return an array"); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("threw exception")
+	}
+	length, err := env.GetArrayLength(entries)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, length)
+	for i := range length {
+		entry, err := env.GetElement(entries, i)
+		if err != nil {
+			return nil, err
+		}
+		name, isDir, err := jsDirEntryToGo(env, entry)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = isDir
+	}
+	return result, nil
+}
+
+// jsDirEntryToGo returns the name of an entry returned by readDir, and whether
+// it's a directory.
+func jsDirEntryToGo(env napi.Env, entry napi.Value) (string, bool, error) {
+	entryType, err := env.Typeof(entry)
+	if err != nil {
+		return "", false, err
+	}
+	if entryType == napi.String {
+		name, err := jsStringToGo(env, entry)
+		return name, false, err
+	}
+	nameValue, err := env.GetNamedProperty(entry, "name")
+	if err != nil {
+		return "", false, err
+	}
+	name, err := jsStringToGo(env, nameValue)
+	if err != nil {
+		return "", false, err
+	}
+	isDir, err := env.GetNamedProperty(entry, "isDirectory")
+	if err != nil {
+		return "", false, err
+	}
+	isDirType, err := env.Typeof(isDir)
+	if err != nil {
+		return "", false, err
+	}
+	if isDirType == napi.Function {
+		if isDir, err = env.CallFunction(entry, isDir, nil); err != nil {
+			return "", false, err
+		}
+	}
+	isDirBool, err := env.CoerceToBool(isDir)
+	return name, isDirBool, err
+}
+
+// Glob is like fs.Glob, but it reports errors from the loader other than
+// missing files and directories, instead of ignoring them.
+func (lfs *jsLoaderFS) Glob(pattern string) ([]string, error) {
+	return lfs.glob(pattern, false)
+}
+
+// glob implements Glob. If dirsOnly is set, it only matches directories.
+func (lfs *jsLoaderFS) glob(pattern string, dirsOnly bool) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	if !hasGlobMeta(pattern) {
+		info, err := lfs.Stat(pattern)
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && dirsOnly && !info.IsDir()) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []string{pattern}, nil
+	}
+
+	dir, file := path.Split(pattern)
+	dir = strings.TrimSuffix(dir, "/")
+	if dir == "" {
+		dir = "."
+	}
+	dirs := []string{dir}
+	if hasGlobMeta(dir) {
+		var err error
+		if dirs, err = lfs.glob(dir, true); err != nil {
+			return nil, err
+		}
+	}
+	var matches []string
+	for _, dir := range dirs {
+		entries, err := lfs.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			matched, _ := path.Match(file, entry.Name())
+			if matched && (!dirsOnly || entry.IsDir()) {
+				matches = append(matches, path.Join(dir, entry.Name()))
+			}
+		}
+	}
+	return matches, nil
+}
+
+// hasGlobMeta reports whether pattern contains any of the special characters
+// recognized by path.Match.
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// Stat describes name as a file if readFile can read it, or else as a
+// directory if readDir can list it.
+func (lfs *jsLoaderFS) Stat(name string) (fs.FileInfo, error) {
+	file, err := lfs.open(name)
+	if err != nil {
+		return nil, err
+	}
+	return file.Stat(name)
+}
+
+func (lfs *jsLoaderFS) Open(name string) (fs.File, error) {
+	file, err := lfs.open(name)
+	if err != nil {
+		return nil, err
+	}
+	return file.Open(name)
+}
+
+// open returns a memFS containing just name, and its entries if it's a
+// directory.
+func (lfs *jsLoaderFS) open(name string) (memFS, error) {
+	data, fileErr := lfs.ReadFile(name)
+	if fileErr == nil {
+		return memFS{name: &memFile{data: data}}, nil
+	}
+	entries, dirErr := lfs.ReadDir(name)
+	if dirErr != nil {
+		return nil, fileErr
+	}
+	dir := memFS{name: &memFile{isDir: true}}
+	for _, entry := range entries {
+		dir[path.Join(name, entry.Name())] = &memFile{isDir: entry.IsDir()}
+	}
+	return dir, nil
+}
+
+// parseFSSource returns a source function for newParseError for templates
+// parsed from fsys, which are named by the base names of their paths.
+func parseFSSource(fsys fs.FS, patterns []string) func(string) (string, string, bool) {
+	return func(parseName string) (string, string, bool) {
+		// Later files replace earlier ones with the same name
+		var filename string
+		for _, pattern := range patterns {
+			matches, _ := fs.Glob(fsys, pattern)
+			for _, match := range matches {
+				if path.Base(match) == parseName {
+					filename = match
+				}
+			}
+		}
+		if filename == "" {
+			return "", "", false
+		}
+		text, err := fs.ReadFile(fsys, filename)
+		return string(text), filename, err == nil
+	}
+}
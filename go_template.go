@@ -0,0 +1,331 @@
+package main
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+// templateClass describes a JS class wrapping one of the Go template packages.
+type templateClass struct {
+	name    string
+	wrapper napi.SafeWrapper[jsTemplate]
+
+	new        func(name string) goTemplate
+	parseFiles func(filenames ...string) (goTemplate, error)
+	parseFS    func(fsys fs.FS, patterns ...string) (goTemplate, error)
+	parseGlob  func(pattern string) (goTemplate, error)
+
+	sprigFuncs         func() template.FuncMap
+	sprigHermeticFuncs func() template.FuncMap
+}
+
+// funcSet returns one of the sets of native functions that can be added to
+// templates of the class, by the name it's recorded under in templateAssn.
+func (cls *templateClass) funcSet(name string) (template.FuncMap, bool) {
+	switch name {
+	case "sprig":
+		return cls.sprigFuncs(), true
+	case "sprigHermetic":
+		return cls.sprigHermeticFuncs(), true
+	}
+	return nil, false
+}
+
+// goTemplate abstracts over the text/template and html/template Template
+// types, which have the same API apart from the types in their signatures.
+type goTemplate interface {
+	AddParseTree(name string, tree *parse.Tree) (goTemplate, error)
+	Class() *templateClass
+	Clone() (goTemplate, error)
+	// Copy returns a copy of the template and its associated templates, like
+	// Clone, whose parse trees can be changed without affecting this one.
+	Copy() (goTemplate, error)
+	DefinedTemplates() string
+	Delims(left, right string)
+	Execute(wr io.Writer, data any) error
+	ExecuteTemplate(wr io.Writer, name string, data any) error
+	Funcs(funcMap template.FuncMap)
+	Lookup(name string) goTemplate
+	Name() string
+	New(name string) goTemplate
+	Option(opt ...string)
+	Parse(text string) error
+	ParseFiles(filenames ...string) error
+	ParseFS(fsys fs.FS, patterns ...string) error
+	ParseGlob(pattern string) error
+	// Snapshot returns a copy of the template that's unaffected by any later
+	// changes to this one.
+	Snapshot() (goTemplate, error)
+	Templates() []goTemplate
+	Tree() *parse.Tree
+}
+
+var textTemplateClass = &templateClass{
+	name:    "Template",
+	wrapper: napi.NewSafeWrapper[jsTemplate](0x1b339336b7154e7d, 0xa8cd781754bef7c9),
+
+	new: func(name string) goTemplate {
+		return textTemplate{template.New(name)}
+	},
+	parseFiles: func(filenames ...string) (goTemplate, error) {
+		return wrapTextTemplate(template.ParseFiles(filenames...))
+	},
+	parseFS: func(fsys fs.FS, patterns ...string) (goTemplate, error) {
+		return wrapTextTemplate(template.ParseFS(fsys, patterns...))
+	},
+	parseGlob: func(pattern string) (goTemplate, error) {
+		return wrapTextTemplate(template.ParseGlob(pattern))
+	},
+
+	sprigFuncs:         sprig.TxtFuncMap,
+	sprigHermeticFuncs: sprig.HermeticTxtFuncMap,
+}
+
+type textTemplate struct {
+	tmpl *template.Template
+}
+
+func wrapTextTemplate(tmpl *template.Template, err error) (goTemplate, error) {
+	if err != nil {
+		return nil, err
+	}
+	return textTemplate{tmpl}, nil
+}
+
+func (tt textTemplate) AddParseTree(name string, tree *parse.Tree) (goTemplate, error) {
+	return wrapTextTemplate(tt.tmpl.AddParseTree(name, tree))
+}
+
+func (tt textTemplate) Class() *templateClass {
+	return textTemplateClass
+}
+
+func (tt textTemplate) Clone() (goTemplate, error) {
+	return wrapTextTemplate(tt.tmpl.Clone())
+}
+
+func (tt textTemplate) Copy() (goTemplate, error) {
+	clone, err := tt.tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	for _, tmpl := range clone.Templates() {
+		tmpl.Tree = tmpl.Tree.Copy()
+	}
+	return textTemplate{clone}, nil
+}
+
+func (tt textTemplate) DefinedTemplates() string {
+	return tt.tmpl.DefinedTemplates()
+}
+
+func (tt textTemplate) Delims(left, right string) {
+	tt.tmpl.Delims(left, right)
+}
+
+func (tt textTemplate) Execute(wr io.Writer, data any) error {
+	return tt.tmpl.Execute(wr, data)
+}
+
+func (tt textTemplate) ExecuteTemplate(wr io.Writer, name string, data any) error {
+	return tt.tmpl.ExecuteTemplate(wr, name, data)
+}
+
+func (tt textTemplate) Funcs(funcMap template.FuncMap) {
+	tt.tmpl.Funcs(funcMap)
+}
+
+func (tt textTemplate) Lookup(name string) goTemplate {
+	result := tt.tmpl.Lookup(name)
+	if result == nil {
+		return nil
+	}
+	return textTemplate{result}
+}
+
+func (tt textTemplate) Name() string {
+	return tt.tmpl.Name()
+}
+
+func (tt textTemplate) New(name string) goTemplate {
+	return textTemplate{tt.tmpl.New(name)}
+}
+
+func (tt textTemplate) Option(opt ...string) {
+	tt.tmpl.Option(opt...)
+}
+
+func (tt textTemplate) Parse(text string) error {
+	_, err := tt.tmpl.Parse(text)
+	return err
+}
+
+func (tt textTemplate) ParseFiles(filenames ...string) error {
+	_, err := tt.tmpl.ParseFiles(filenames...)
+	return err
+}
+
+func (tt textTemplate) ParseFS(fsys fs.FS, patterns ...string) error {
+	_, err := tt.tmpl.ParseFS(fsys, patterns...)
+	return err
+}
+
+func (tt textTemplate) ParseGlob(pattern string) error {
+	_, err := tt.tmpl.ParseGlob(pattern)
+	return err
+}
+
+func (tt textTemplate) Snapshot() (goTemplate, error) {
+	return tt.Clone()
+}
+
+func (tt textTemplate) Tree() *parse.Tree {
+	return tt.tmpl.Tree
+}
+
+func (tt textTemplate) Templates() []goTemplate {
+	templates := tt.tmpl.Templates()
+	result := make([]goTemplate, len(templates))
+	for i, tmpl := range templates {
+		result[i] = textTemplate{tmpl}
+	}
+	return result
+}
+
+var htmlTemplateClass = &templateClass{
+	name:    "HtmlTemplate",
+	wrapper: napi.NewSafeWrapper[jsTemplate](0x6f0e2c5a93d14b08, 0xb57a1e6d2c9f4e31),
+
+	new: func(name string) goTemplate {
+		return htmlTemplate{htmltemplate.New(name)}
+	},
+	parseFiles: func(filenames ...string) (goTemplate, error) {
+		return wrapHtmlTemplate(htmltemplate.ParseFiles(filenames...))
+	},
+	parseFS: func(fsys fs.FS, patterns ...string) (goTemplate, error) {
+		return wrapHtmlTemplate(htmltemplate.ParseFS(fsys, patterns...))
+	},
+	parseGlob: func(pattern string) (goTemplate, error) {
+		return wrapHtmlTemplate(htmltemplate.ParseGlob(pattern))
+	},
+
+	sprigFuncs:         sprig.HtmlFuncMap,
+	sprigHermeticFuncs: sprig.HermeticHtmlFuncMap,
+}
+
+type htmlTemplate struct {
+	tmpl *htmltemplate.Template
+}
+
+func wrapHtmlTemplate(tmpl *htmltemplate.Template, err error) (goTemplate, error) {
+	if err != nil {
+		return nil, err
+	}
+	return htmlTemplate{tmpl}, nil
+}
+
+func (ht htmlTemplate) AddParseTree(name string, tree *parse.Tree) (goTemplate, error) {
+	return wrapHtmlTemplate(ht.tmpl.AddParseTree(name, tree))
+}
+
+func (ht htmlTemplate) Class() *templateClass {
+	return htmlTemplateClass
+}
+
+func (ht htmlTemplate) Clone() (goTemplate, error) {
+	return wrapHtmlTemplate(ht.tmpl.Clone())
+}
+
+func (ht htmlTemplate) Copy() (goTemplate, error) {
+	// Cloning copies the parse trees, since escaping changes them
+	return ht.Clone()
+}
+
+func (ht htmlTemplate) DefinedTemplates() string {
+	return ht.tmpl.DefinedTemplates()
+}
+
+func (ht htmlTemplate) Delims(left, right string) {
+	ht.tmpl.Delims(left, right)
+}
+
+func (ht htmlTemplate) Execute(wr io.Writer, data any) error {
+	return ht.tmpl.Execute(wr, data)
+}
+
+func (ht htmlTemplate) ExecuteTemplate(wr io.Writer, name string, data any) error {
+	return ht.tmpl.ExecuteTemplate(wr, name, data)
+}
+
+func (ht htmlTemplate) Funcs(funcMap template.FuncMap) {
+	ht.tmpl.Funcs(funcMap)
+}
+
+func (ht htmlTemplate) Lookup(name string) goTemplate {
+	result := ht.tmpl.Lookup(name)
+	if result == nil {
+		return nil
+	}
+	return htmlTemplate{result}
+}
+
+func (ht htmlTemplate) Name() string {
+	return ht.tmpl.Name()
+}
+
+func (ht htmlTemplate) New(name string) goTemplate {
+	return htmlTemplate{ht.tmpl.New(name)}
+}
+
+func (ht htmlTemplate) Option(opt ...string) {
+	ht.tmpl.Option(opt...)
+}
+
+func (ht htmlTemplate) Parse(text string) error {
+	_, err := ht.tmpl.Parse(text)
+	return err
+}
+
+func (ht htmlTemplate) ParseFiles(filenames ...string) error {
+	_, err := ht.tmpl.ParseFiles(filenames...)
+	return err
+}
+
+func (ht htmlTemplate) ParseFS(fsys fs.FS, patterns ...string) error {
+	_, err := ht.tmpl.ParseFS(fsys, patterns...)
+	return err
+}
+
+func (ht htmlTemplate) ParseGlob(pattern string) error {
+	_, err := ht.tmpl.ParseGlob(pattern)
+	return err
+}
+
+func (ht htmlTemplate) Snapshot() (goTemplate, error) {
+	clone, err := ht.tmpl.Clone()
+	if err != nil {
+		// html/template refuses to clone templates that have been executed,
+		// but it also refuses to parse into them, so they're safe to share.
+		return ht, nil
+	}
+	return htmlTemplate{clone}, nil
+}
+
+func (ht htmlTemplate) Tree() *parse.Tree {
+	return ht.tmpl.Tree
+}
+
+func (ht htmlTemplate) Templates() []goTemplate {
+	templates := ht.tmpl.Templates()
+	result := make([]goTemplate, len(templates))
+	for i, tmpl := range templates {
+		result[i] = htmlTemplate{tmpl}
+	}
+	return result
+}
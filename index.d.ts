@@ -1,33 +1,498 @@
 type FuncMap = { [name: string]: (...args: any[]) => any };
+type ChunkCallback = (chunk: Buffer) => unknown;
+type FileContents = string | Uint8Array;
 
-export class Template {
-  constructor(name: string);
-
-  clone(): Template;
+/** Methods shared by `Template` and `HtmlTemplate`. */
+interface TemplateMethods {
+  /**
+   * Like `AddParseTree`, but takes a tree in the format returned by `tree`.
+   * Node positions are ignored, and the tree is validated by rendering it as
+   * template source and parsing it again.
+   */
+  addParseTree(name: string, tree: TemplateTree): this;
+  clone(): this;
   definedTemplates(): string;
-  delims(left: string, right: string): Template;
+  delims(left: string, right: string): this;
   executeString(data?: unknown): string;
   executeTemplateString(name: string, data?: unknown): string;
-  funcs(funcMap: FuncMap): Template;
-  lookup(name: string): Template | undefined;
+  funcs(funcMap: FuncMap): this;
+  lookup(name: string): this | undefined;
   name(): string;
-  new(name: string): Template;
-  option(...opts: string[]): Template;
-  parse(text: string): Template;
-  parseFiles(...files: string[]): Template;
-  parseGlob(glob: string): Template;
-  templates(): Template[];
+  new(name: string): this;
+  option(...opts: string[]): this;
+  parse(text: string): this;
+  parseFiles(...files: string[]): this;
+  /**
+   * Like Go's `ParseFS`, with the virtual filesystem given as an object
+   * mapping paths to contents, or as a `TemplateLoader`.
+   */
+  parseFS(source: TemplateSource, ...patterns: string[]): this;
+  parseGlob(glob: string): this;
+  templates(): this[];
+
+  // Methods below this line are not part of the text/template API.
+
+  /** Add `sprig.TxtFuncMap()` (or `sprig.HtmlFuncMap()`) template functions. */
+  addSprigFuncs(): this;
+
+  /**
+   * Add `sprig.HermeticTxtFuncMap()` (or `sprig.HermeticHtmlFuncMap()`)
+   * template functions.
+   */
+  addSprigHermeticFuncs(): this;
+
+  /**
+   * Stops watching a template created by `watch` for changes. Does nothing for
+   * other templates.
+   */
+  close(): void;
+
+  /**
+   * Parses the templates matching `patterns` in a zip, tar, or gzipped tar
+   * archive, given as a path or as its contents. Unlike `parseFS`, templates
+   * are named by their full paths in the archive.
+   */
+  parseArchive(archive: string | Uint8Array, ...patterns: string[]): this;
+
+  /**
+   * Sets how data and the results of JS template functions are converted to
+   * Go values for this template and the templates associated with it. Options
+   * that aren't given are reset to their defaults.
+   */
+  conversionOptions(options: ConversionOptions): this;
+
+  /**
+   * Like `executeString`, but takes the data as JSON text, which is decoded
+   * natively. This is faster than parsing the JSON in JS first.
+   */
+  executeJSON(json: string | Uint8Array): string;
+
+  /** Like `executeTemplateString`, but takes the data as JSON text. */
+  executeTemplateJSON(name: string, json: string | Uint8Array): string;
+
+  /**
+   * Like `executeString`, but executes the template on a worker thread.
+   * Template functions written in JS are still called on the main thread.
+   */
+  executeAsync(data?: unknown): Promise<string>;
+
+  /**
+   * Like `executeTemplateString`, but executes the template on a worker
+   * thread. Template functions written in JS are still called on the main
+   * thread.
+   */
+  executeTemplateAsync(name: string, data?: unknown): Promise<string>;
+
+  /**
+   * Executes the template on a worker thread, passing its output to `dest` in
+   * bounded chunks as it is produced. If `dest` is a function, returning a
+   * promise from it will pause execution until the promise resolves. The
+   * stream is not ended when execution finishes.
+   */
+  executeToStream(
+    dest: NodeJS.WritableStream | ChunkCallback,
+    data?: unknown,
+  ): Promise<void>;
+
+  /**
+   * Like `executeToStream`, but executes the named template like
+   * `executeTemplateString`.
+   */
+  executeTemplateToStream(
+    dest: NodeJS.WritableStream | ChunkCallback,
+    name: string,
+    data?: unknown,
+  ): Promise<void>;
+
+  /**
+   * Describes the fields of the data read by this template, following `with`,
+   * `range`, and `template` actions. Returns `undefined` if the template hasn't
+   * been parsed.
+   */
+  inferDataShape(options?: { jsonSchema?: false }): DataShape | undefined;
+  inferDataShape(options: { jsonSchema: true }): object | undefined;
+
+  /**
+   * Adds a listener for events from a template created by `watch`. The
+   * `reload` event is emitted after the template is reparsed, and the `error`
+   * event when reparsing fails. If there are no `error` listeners, errors are
+   * emitted as process warnings.
+   */
+  on(event: 'reload', listener: (this: this, template: this) => void): this;
+  on(event: 'error', listener: (this: this, err: unknown) => void): this;
+
+  /**
+   * Serializes this template and the templates associated with it, along with
+   * its delimiters and options, for `deserialize`. The result also lists the
+   * JavaScript functions they call, which must be provided when deserializing,
+   * and any Sprig functions added, which are added back automatically.
+   */
+  serialize(): Buffer;
+
+  /**
+   * Returns the parse tree of the named template, or `undefined` if there's no
+   * such template or it hasn't been parsed.
+   */
+  templateTree(name: string): TemplateTree | undefined;
+
+  /**
+   * Returns the parse tree of this template, or `undefined` if it hasn't been
+   * parsed.
+   */
+  tree(): TemplateTree | undefined;
+}
+
+/**
+ * Loads templates for `parseFS`. Paths are slash-separated and relative, like
+ * in Go's `io/fs`, with `.` for the root directory.
+ */
+export interface TemplateLoader {
+  /**
+   * Returns the contents of a file, or `undefined` if it doesn't exist.
+   * Errors with code `ENOENT` are also treated as missing files.
+   */
+  readFile(path: string): FileContents | undefined;
+  /**
+   * Lists a directory, for patterns with wildcards. Entries are names of
+   * files, or objects like `fs.Dirent`s.
+   */
+  readDir?(
+    path: string,
+  ): (string | { name: string; isDirectory: boolean | (() => boolean) })[];
+}
+
+/** Options for `watch`. */
+export interface WatchOptions {
+  /**
+   * Template functions to add before the files are first parsed, so they can
+   * be used by the templates.
+   */
+  funcs?: FuncMap;
+  /**
+   * How often to check the files for changes, in milliseconds, on platforms
+   * where they're polled (all but Linux). Defaults to 1000.
+   */
+  interval?: number;
+}
+
+export type TemplateSource =
+  | { [path: string]: FileContents }
+  | TemplateLoader;
+
+/** Options for `conversionOptions`. */
+export interface ConversionOptions {
+  /**
+   * Convert all numbers to `float64`. By default, integers in the safe integer
+   * range are converted to `int64`, so they work with `printf "%d"` and can be
+   * compared with integer literals.
+   */
+  floatNumbers?: boolean;
+  /**
+   * The maximum depth of nested objects and arrays. Defaults to 1000.
+   * Exceeding it throws a `RangeError`.
+   */
+  maxDepth?: number;
+  /**
+   * The maximum total number of object properties and array elements.
+   * Defaults to 10,000,000. Exceeding it throws a `RangeError`.
+   */
+  maxProperties?: number;
+  /**
+   * Convert the objects and arrays in data passed to `execute*` methods as the
+   * template reads them, rather than all at once. Values that are printed are
+   * still converted in full, and values passed to JS functions are passed
+   * unchanged.
+   */
+  lazy?: boolean;
+  /**
+   * Convert objects with a `toJSON` method using its result, like
+   * `JSON.stringify`. Objects implementing `Template.toGoValue` are always
+   * converted using it instead.
+   */
+  toJSON?: boolean;
+  /**
+   * Convert object properties and `Map` entries whose values are `undefined`
+   * to `nil` map entries, as older versions did. By default they're omitted,
+   * so they're treated as missing keys, while `null` values become `nil`.
+   */
+  keepUndefined?: boolean;
+}
+
+/**
+ * How a template uses a value from its data. Values that are ranged over are
+ * `array`s, values with fields accessed are `object`s, values written to the
+ * output are `scalar`s, and all others are `any`.
+ */
+export interface DataShape {
+  type: 'any' | 'array' | 'object' | 'scalar';
+  fields?: { [name: string]: DataShape };
+  elem?: DataShape;
+}
+
+/** A parse tree from Go's `text/template/parse` package. */
+export interface TemplateTree {
+  name: string;
+  parseName: string;
+  root: ListNode;
+}
+
+/** Properties common to all parse tree nodes. */
+interface NodeBase {
+  /** Byte offset of the node in the template source. */
+  pos: number;
+  /** One-based line number of the node. */
+  line: number;
+  /** Zero-based byte offset of the node within its line. */
+  column: number;
+}
+
+interface BranchNodeBase extends NodeBase {
+  pipe: PipeNode;
+  list: ListNode;
+  elseList: ListNode | null;
+}
+
+export interface ActionNode extends NodeBase {
+  type: 'Action';
+  pipe: PipeNode;
+}
+export interface BoolNode extends NodeBase {
+  type: 'Bool';
+  value: boolean;
+}
+export interface BreakNode extends NodeBase {
+  type: 'Break';
+}
+export interface ChainNode extends NodeBase {
+  type: 'Chain';
+  node: Node;
+  field: string[];
+}
+export interface CommandNode extends NodeBase {
+  type: 'Command';
+  args: Node[];
+}
+export interface CommentNode extends NodeBase {
+  type: 'Comment';
+  text: string;
+}
+export interface ContinueNode extends NodeBase {
+  type: 'Continue';
+}
+export interface DotNode extends NodeBase {
+  type: 'Dot';
+}
+export interface FieldNode extends NodeBase {
+  type: 'Field';
+  ident: string[];
+}
+export interface IdentifierNode extends NodeBase {
+  type: 'Identifier';
+  ident: string;
+}
+export interface IfNode extends BranchNodeBase {
+  type: 'If';
+}
+export interface ListNode extends NodeBase {
+  type: 'List';
+  nodes: Node[];
+}
+export interface NilNode extends NodeBase {
+  type: 'Nil';
+}
+export interface NumberNode extends NodeBase {
+  type: 'Number';
+  text: string;
+}
+export interface PipeNode extends NodeBase {
+  type: 'Pipe';
+  isAssign: boolean;
+  decl: VariableNode[];
+  cmds: CommandNode[];
+}
+export interface RangeNode extends BranchNodeBase {
+  type: 'Range';
+}
+export interface StringNode extends NodeBase {
+  type: 'String';
+  quoted: string;
+  text: string;
+}
+export interface TemplateNode extends NodeBase {
+  type: 'Template';
+  name: string;
+  pipe: PipeNode | null;
+}
+export interface TextNode extends NodeBase {
+  type: 'Text';
+  text: string;
+}
+export interface VariableNode extends NodeBase {
+  type: 'Variable';
+  ident: string[];
+}
+export interface WithNode extends BranchNodeBase {
+  type: 'With';
+}
+
+export type Node =
+  | ActionNode
+  | BoolNode
+  | BreakNode
+  | ChainNode
+  | CommandNode
+  | CommentNode
+  | ContinueNode
+  | DotNode
+  | FieldNode
+  | IdentifierNode
+  | IfNode
+  | ListNode
+  | NilNode
+  | NumberNode
+  | PipeNode
+  | RangeNode
+  | StringNode
+  | TemplateNode
+  | TextNode
+  | VariableNode
+  | WithNode;
+
+/** Bindings for Go's `text/template` package. */
+export interface Template extends TemplateMethods {}
+export class Template {
+  constructor(name: string);
 
   static parseFiles(...files: string[]): Template;
+  static parseFS(source: TemplateSource, ...patterns: string[]): Template;
   static parseGlob(glob: string): Template;
 
   // Methods below this line are not part of the text/template API.
 
-  /** Add `sprig.TxtFuncMap()` template functions. */
-  addSprigFuncs(): Template;
+  /**
+   * Rebuilds a set of templates from the output of `serialize`, adding `funcs`
+   * to it after any Sprig functions that were recorded. Throws if any other
+   * function the templates call isn't in `funcs`.
+   */
+  static deserialize(data: Uint8Array, funcs?: FuncMap): Template;
 
-  /** Add `sprig.HermeticTxtFuncMap()` template functions. */
-  addSprigHermeticFuncs(): Template;
+  /** Like the `parseArchive` method, but creates a new template. */
+  static parseArchive(
+    archive: string | Uint8Array,
+    ...patterns: string[]
+  ): Template;
+
+  /**
+   * Converts data to Go values once, so it can be passed to the `execute*`
+   * methods of any template without being converted again.
+   */
+  static prepareData(data: unknown, options?: ConversionOptions): PreparedData;
+
+  /**
+   * Like `parseGlob`, but returns a template that's reparsed whenever the
+   * files it was parsed from change, including those passed to its
+   * `parseFiles` and `parseGlob` methods later. Its functions, options and
+   * delimiters are kept when it's reparsed, but templates added in other ways
+   * are dropped. Templates obtained from it with `lookup`, `new` or
+   * `templates` are switched to the reparsed set as well. The process is kept
+   * running until `close` is called.
+   */
+  static watch(glob: string, options?: WatchOptions): Template;
+
+  /**
+   * A symbol that classes can implement as a method to control how their
+   * instances are converted to Go values. The method is called with the key
+   * of the instance in its parent, like `toJSON`, and its result is converted
+   * in place of the instance.
+   */
+  static readonly toGoValue: unique symbol;
+
+  /**
+   * A symbol that marks objects returned by template functions to be passed
+   * to other JS functions as the same object, and in lazy mode converted only
+   * as the template reads them. Class instances, `Map`s and `Set`s are passed
+   * this way without it.
+   */
+  static readonly opaque: unique symbol;
+}
+
+/**
+ * Bindings for Go's `html/template` package, which escapes template output
+ * based on its context within an HTML document. As in Go, it can't be parsed
+ * into or cloned once it's been executed, except by asynchronous executions and
+ * executions in lazy conversion mode, which run a copy of the templates.
+ */
+export interface HtmlTemplate extends TemplateMethods {}
+export class HtmlTemplate {
+  constructor(name: string);
+
+  static parseFiles(...files: string[]): HtmlTemplate;
+  static parseFS(source: TemplateSource, ...patterns: string[]): HtmlTemplate;
+  static parseGlob(glob: string): HtmlTemplate;
+
+  // Methods below this line are not part of the html/template API.
+
+  /** Like `Template.deserialize`. */
+  static deserialize(data: Uint8Array, funcs?: FuncMap): HtmlTemplate;
+
+  /** Like `Template.parseArchive`. */
+  static parseArchive(
+    archive: string | Uint8Array,
+    ...patterns: string[]
+  ): HtmlTemplate;
+
+  /** Like `Template.prepareData`. */
+  static prepareData(data: unknown, options?: ConversionOptions): PreparedData;
+
+  /** Like `Template.watch`. */
+  static watch(glob: string, options?: WatchOptions): HtmlTemplate;
+
+  /** The same symbol as `Template.toGoValue`. */
+  static readonly toGoValue: typeof Template.toGoValue;
+
+  /** The same symbol as `Template.opaque`. */
+  static readonly opaque: typeof Template.opaque;
+}
+
+declare const preparedData: unique symbol;
+
+/**
+ * Template data that has already been converted to Go values, returned by
+ * `prepareData`. It can be used anywhere template data is accepted, including
+ * nested inside other data.
+ */
+export interface PreparedData {
+  readonly [preparedData]: never;
+}
+
+/** Properties describing where a template error occurred, when known. */
+interface TemplateErrorDetails {
+  /** The name of the template, as used in Go's error message. */
+  templateName?: string;
+  /** The path of the file the template was parsed from. */
+  file?: string;
+  /** One-based line number of the error. */
+  line?: number;
+  /** Zero-based byte offset of the error within its line. */
+  column?: number;
+  /** The source of the offending action. */
+  context?: string;
+}
+
+/** Thrown when template source fails to parse. */
+export interface TemplateParseError extends TemplateErrorDetails {}
+export class TemplateParseError extends Error {
+  constructor(message?: string);
+  code: 'ERR_TEMPLATE_PARSE';
+}
+
+/** Thrown (or rejected with) when template execution fails. */
+export interface TemplateExecError extends TemplateErrorDetails {}
+export class TemplateExecError extends Error {
+  constructor(message?: string);
+  code: 'ERR_TEMPLATE_EXEC';
+  /** The text of the node being evaluated when the error occurred. */
+  nodeText?: string;
 }
 
 export function htmlEscapeString(str: string): string;
@@ -35,3 +500,37 @@ export function htmlEscaper(...args: unknown[]): string;
 export function jsEscapeString(str: string): string;
 export function jsEscaper(...args: unknown[]): string;
 export function urlQueryEscaper(...args: unknown[]): string;
+
+/**
+ * Marks a string as trusted content of a particular kind, like the
+ * corresponding `html/template` types. `HtmlTemplate` outputs trusted content
+ * without escaping it in matching contexts.
+ */
+export interface TrustedContent {
+  readonly type:
+    | 'css'
+    | 'html'
+    | 'htmlAttr'
+    | 'js'
+    | 'jsStr'
+    | 'srcset'
+    | 'url';
+  readonly value: string;
+}
+
+export const trusted: {
+  /** Like `template.CSS` */
+  css(str: string): TrustedContent;
+  /** Like `template.HTML` */
+  html(str: string): TrustedContent;
+  /** Like `template.HTMLAttr` */
+  htmlAttr(str: string): TrustedContent;
+  /** Like `template.JS` */
+  js(str: string): TrustedContent;
+  /** Like `template.JSStr` */
+  jsStr(str: string): TrustedContent;
+  /** Like `template.Srcset` */
+  srcset(str: string): TrustedContent;
+  /** Like `template.URL` */
+  url(str: string): TrustedContent;
+};
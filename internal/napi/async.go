@@ -0,0 +1,133 @@
+package napi
+
+// #include <node_api.h>
+// void genericAsyncExecute(napi_env env, void* data);
+// void genericAsyncComplete(napi_env env, napi_status status, void* data);
+// void genericThreadsafeCallJs(napi_env env, napi_value js_callback, void* context, void* data);
+import "C"
+import (
+	"fmt"
+	"runtime/cgo"
+	"unsafe"
+)
+
+type asyncExecuteFunc func()
+type asyncCompleteFunc func(env Env, status error) error
+
+type asyncWorkData struct {
+	work     AsyncWork
+	execute  asyncExecuteFunc
+	complete asyncCompleteFunc
+}
+
+//export genericAsyncExecute
+func genericAsyncExecute(rawEnv C.napi_env, data unsafe.Pointer) {
+	// This runs on a worker thread, so the env must not be used here
+	unlaunderHandle(data).Value().(*asyncWorkData).execute()
+}
+
+//export genericAsyncComplete
+func genericAsyncComplete(rawEnv C.napi_env, status C.napi_status, data unsafe.Pointer) {
+	env := Env{rawEnv}
+	workData := unlaunderHandle(data).Value().(*asyncWorkData)
+	var statusErr error
+	if status == C.napi_cancelled {
+		statusErr = fmt.Errorf("async work was cancelled")
+	} else if status != C.napi_ok {
+		statusErr = mapBareStatus(status)
+	}
+	if err := workData.complete(env, statusErr); err != nil {
+		env.maybeThrowError(err)
+	}
+	if err := env.DeleteAsyncWork(workData.work); err != nil {
+		fmt.Println("Error deleting async work:", err)
+	}
+	deleteLaunderedHandle(data)
+}
+
+// QueueAsyncWork runs execute on the libuv thread pool, then runs complete on
+// the JS thread once it has finished. The execute function must not make any
+// Node-API calls.
+func QueueAsyncWork(env Env, name string, execute asyncExecuteFunc, complete asyncCompleteFunc) error {
+	nameValue, err := env.CreateString(name)
+	if err != nil {
+		return err
+	}
+	workData := &asyncWorkData{nil, execute, complete}
+	dataPtr, cleanup := launderHandle(cgo.NewHandle(workData))
+	work, err := env.CreateAsyncWork(
+		nil,
+		nameValue,
+		AsyncExecuteCallback(C.genericAsyncExecute),
+		AsyncCompleteCallback(C.genericAsyncComplete),
+		dataPtr,
+	)
+	if err != nil {
+		cleanup()
+		return err
+	}
+	workData.work = work
+	if err := env.QueueAsyncWork(work); err != nil {
+		_ = env.DeleteAsyncWork(work)
+		cleanup()
+		return err
+	}
+	return nil
+}
+
+type threadsafeCallFunc func(env Env, err error)
+
+//export genericThreadsafeCallJs
+func genericThreadsafeCallJs(rawEnv C.napi_env, jsCallback C.napi_value, context unsafe.Pointer, data unsafe.Pointer) {
+	fn := unlaunderHandle(data).Value().(threadsafeCallFunc)
+	deleteLaunderedHandle(data)
+	if rawEnv == nil {
+		// The environment is being torn down, so the call can't be made
+		fn(Env{}, fmt.Errorf("Node-API environment is shutting down"))
+		return
+	}
+	fn(Env{rawEnv}, nil)
+}
+
+// ThreadsafeCaller allows any thread to run functions on the JS thread.
+type ThreadsafeCaller struct {
+	tsfn ThreadsafeFunction
+}
+
+func NewThreadsafeCaller(env Env, name string) (*ThreadsafeCaller, error) {
+	nameValue, err := env.CreateString(name)
+	if err != nil {
+		return nil, err
+	}
+	tsfn, err := env.CreateThreadsafeFunction(
+		nil,
+		nil,
+		nameValue,
+		0,
+		1,
+		nil,
+		ThreadsafeFunctionCallJs(C.genericThreadsafeCallJs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &ThreadsafeCaller{tsfn}, nil
+}
+
+// Call queues fn to be run on the JS thread. It does not wait for fn to run.
+// If the environment is torn down before fn can run, it is called with an
+// invalid Env and a non-nil error.
+func (tc *ThreadsafeCaller) Call(fn threadsafeCallFunc) error {
+	dataPtr, cleanup := launderHandle(cgo.NewHandle(fn))
+	if err := CallThreadsafeFunction(tc.tsfn, dataPtr, true); err != nil {
+		cleanup()
+		return err
+	}
+	return nil
+}
+
+// Release gives up this thread's use of the caller. No further calls may be
+// made after it returns.
+func (tc *ThreadsafeCaller) Release() error {
+	return ReleaseThreadsafeFunction(tc.tsfn, false)
+}
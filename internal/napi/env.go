@@ -6,6 +6,7 @@ package napi
 // #include <node_api.h>
 import "C"
 import (
+	"errors"
 	"fmt"
 	"unsafe"
 )
@@ -53,10 +54,14 @@ func (env Env) GetLastErrorInfo() (*ExtendedErrorInfo, error) {
 	}, nil
 }
 
+func (env Env) Throw(errValue Value) error {
+	return env.mapStatus(C.napi_throw(env.inner, errValue))
+}
+
 func (env Env) ThrowError(code string, msg string) error {
 	var cCode *C.char
 	if code != "" {
-		cCode := C.CString(code)
+		cCode = C.CString(code)
 		defer C.free(unsafe.Pointer(cCode))
 	}
 	cMsg := C.CString(msg)
@@ -75,6 +80,35 @@ func (env Env) ThrowTypeError(code string, msg string) error {
 	return env.mapStatus(C.napi_throw_type_error(env.inner, cCode, cMsg))
 }
 
+func (env Env) ThrowRangeError(code string, msg string) error {
+	var cCode *C.char
+	if code != "" {
+		cCode = C.CString(code)
+		defer C.free(unsafe.Pointer(cCode))
+	}
+	cMsg := C.CString(msg)
+	defer C.free(unsafe.Pointer(cMsg))
+	return env.mapStatus(C.napi_throw_range_error(env.inner, cCode, cMsg))
+}
+
+func (env Env) CreateError(code Value, msg Value) (Value, error) {
+	var result C.napi_value
+	status := C.napi_create_error(env.inner, code, msg, &result)
+	if err := env.mapStatus(status); err != nil {
+		return nil, err
+	}
+	return Value(result), nil
+}
+
+func (env Env) GetAndClearLastException() (Value, error) {
+	var result C.napi_value
+	status := C.napi_get_and_clear_last_exception(env.inner, &result)
+	if err := env.mapStatus(status); err != nil {
+		return nil, err
+	}
+	return Value(result), nil
+}
+
 func (env Env) IsExceptionPending() (bool, error) {
 	var result C.bool
 	status := C.napi_is_exception_pending(env.inner, &result)
@@ -99,6 +133,13 @@ func (env Env) mapStatus(status C.napi_status) error {
 	return fmt.Errorf("Node-API Error: %s (code %d)", info.errorMessage, info.errorCode)
 }
 
+// JsError is implemented by errors which should be thrown as a particular JS
+// value, rather than as an Error with the same message.
+type JsError interface {
+	error
+	ToJs(env Env) (Value, error)
+}
+
 func (env Env) maybeThrowError(err error) {
 	// Don't clobber a pending exception if there is one
 	isPending, pendErr := env.IsExceptionPending()
@@ -106,9 +147,16 @@ func (env Env) maybeThrowError(err error) {
 		return
 	}
 
-	// TODO: Add mechanism for throwing custom errors here
-	// (Perhaps with errors.As on a napiThrow interface?)
-	throwErr := env.ThrowError("", err.Error())
+	var throwErr error
+	var jsErr JsError
+	if errors.As(err, &jsErr) {
+		var errValue Value
+		if errValue, throwErr = jsErr.ToJs(env); throwErr == nil {
+			throwErr = env.Throw(errValue)
+		}
+	} else {
+		throwErr = env.ThrowError("", err.Error())
+	}
 	if throwErr != nil {
 		// TODO: Anything more useful to do here?
 		fmt.Println("Node-API error", throwErr, "throwing error", err)
@@ -161,6 +209,36 @@ func (env Env) GetReferenceValue(ref Ref) (Value, error) {
 
 // Working with JavaScript values
 
+type TypedArrayType C.napi_typedarray_type
+
+const (
+	Int8Array         TypedArrayType = C.napi_int8_array
+	Uint8Array        TypedArrayType = C.napi_uint8_array
+	Uint8ClampedArray TypedArrayType = C.napi_uint8_clamped_array
+	Int16Array        TypedArrayType = C.napi_int16_array
+	Uint16Array       TypedArrayType = C.napi_uint16_array
+	Int32Array        TypedArrayType = C.napi_int32_array
+	Uint32Array       TypedArrayType = C.napi_uint32_array
+	Float32Array      TypedArrayType = C.napi_float32_array
+	Float64Array      TypedArrayType = C.napi_float64_array
+	BigInt64Array     TypedArrayType = C.napi_bigint64_array
+	BigUint64Array    TypedArrayType = C.napi_biguint64_array
+)
+
+// ElementSize returns the size in bytes of the elements of a typed array type.
+func (t TypedArrayType) ElementSize() int {
+	switch t {
+	case Int16Array, Uint16Array:
+		return 2
+	case Int32Array, Uint32Array, Float32Array:
+		return 4
+	case Float64Array, BigInt64Array, BigUint64Array:
+		return 8
+	default:
+		return 1
+	}
+}
+
 func (env Env) CreateArrayWithLength(length int) (Value, error) {
 	var result C.napi_value
 	status := C.napi_create_array_with_length(env.inner, C.size_t(length), &result)
@@ -170,6 +248,15 @@ func (env Env) CreateArrayWithLength(length int) (Value, error) {
 	return Value(result), nil
 }
 
+func (env Env) CreateDate(time float64) (Value, error) {
+	var result C.napi_value
+	status := C.napi_create_date(env.inner, C.double(time), &result)
+	if err := env.mapStatus(status); err != nil {
+		return nil, err
+	}
+	return Value(result), nil
+}
+
 func (env Env) CreateObject() (Value, error) {
 	var result C.napi_value
 	status := C.napi_create_object(env.inner, &result)
@@ -206,6 +293,20 @@ func (env Env) CreateDouble(value float64) (Value, error) {
 	return Value(result), nil
 }
 
+func (env Env) CreateBigintWords(signBit int, words []uint64) (Value, error) {
+	var result C.napi_value
+	var zero C.uint64_t
+	wordsPtr := &zero
+	if len(words) > 0 {
+		wordsPtr = (*C.uint64_t)(&words[0])
+	}
+	status := C.napi_create_bigint_words(env.inner, C.int(signBit), C.size_t(len(words)), wordsPtr, &result)
+	if err := env.mapStatus(status); err != nil {
+		return nil, err
+	}
+	return Value(result), nil
+}
+
 func (env Env) CreateString(str string) (Value, error) {
 	var result C.napi_value
 	strPtr := (*C.char)(unsafe.Pointer(unsafe.StringData(str)))
@@ -216,6 +317,16 @@ func (env Env) CreateString(str string) (Value, error) {
 	return Value(result), nil
 }
 
+func (env Env) CreateBufferCopy(data []byte) (Value, error) {
+	var result C.napi_value
+	dataPtr := unsafe.Pointer(unsafe.SliceData(data))
+	status := C.napi_create_buffer_copy(env.inner, C.size_t(len(data)), dataPtr, nil, &result)
+	if err := env.mapStatus(status); err != nil {
+		return nil, err
+	}
+	return Value(result), nil
+}
+
 func (env Env) GetArrayLength(value Value) (uint32, error) {
 	var result C.uint32_t
 	status := C.napi_get_array_length(env.inner, value, &result)
@@ -225,6 +336,48 @@ func (env Env) GetArrayLength(value Value) (uint32, error) {
 	return uint32(result), nil
 }
 
+// GetTypedArrayInfo returns the type of a typed array, and its contents. The
+// returned slice refers to memory owned by JS, so it must be copied if it's
+// used after returning to JS.
+func (env Env) GetTypedArrayInfo(value Value) (TypedArrayType, []byte, error) {
+	var arrType C.napi_typedarray_type
+	var length C.size_t
+	var data unsafe.Pointer
+	status := C.napi_get_typedarray_info(env.inner, value, &arrType, &length, &data, nil, nil)
+	if err := env.mapStatus(status); err != nil {
+		return 0, nil, err
+	}
+	result := TypedArrayType(arrType)
+	if data == nil {
+		return result, nil, nil
+	}
+	return result, unsafe.Slice((*byte)(data), int(length)*result.ElementSize()), nil
+}
+
+// GetDataviewInfo returns the contents of a DataView. Like GetTypedArrayInfo,
+// the returned slice refers to memory owned by JS.
+func (env Env) GetDataviewInfo(value Value) ([]byte, error) {
+	var length C.size_t
+	var data unsafe.Pointer
+	status := C.napi_get_dataview_info(env.inner, value, &length, &data, nil, nil)
+	if err := env.mapStatus(status); err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+	return unsafe.Slice((*byte)(data), int(length)), nil
+}
+
+func (env Env) GetDateValue(value Value) (float64, error) {
+	var result C.double
+	status := C.napi_get_date_value(env.inner, value, &result)
+	if err := env.mapStatus(status); err != nil {
+		return 0, err
+	}
+	return float64(result), nil
+}
+
 func (env Env) GetValueBool(value Value) (bool, error) {
 	var result C.bool
 	status := C.napi_get_value_bool(env.inner, value, &result)
@@ -285,6 +438,15 @@ func (env Env) GetBoolean(value bool) (Value, error) {
 	return Value(result), nil
 }
 
+func (env Env) GetGlobal() (Value, error) {
+	var result C.napi_value
+	status := C.napi_get_global(env.inner, &result)
+	if err := env.mapStatus(status); err != nil {
+		return nil, err
+	}
+	return Value(result), nil
+}
+
 func (env Env) GetNull() (Value, error) {
 	var result C.napi_value
 	status := C.napi_get_null(env.inner, &result)
@@ -305,6 +467,15 @@ func (env Env) GetUndefined() (Value, error) {
 
 // Working with JavaScript values and abstract operations
 
+func (env Env) CoerceToBool(value Value) (bool, error) {
+	var coerced C.napi_value
+	status := C.napi_coerce_to_bool(env.inner, value, &coerced)
+	if err := env.mapStatus(status); err != nil {
+		return false, err
+	}
+	return env.GetValueBool(Value(coerced))
+}
+
 type ValueType C.napi_valuetype
 
 const (
@@ -338,6 +509,60 @@ func (env Env) IsArray(value Value) (bool, error) {
 	return bool(result), nil
 }
 
+func (env Env) IsDate(value Value) (bool, error) {
+	var result C.bool
+	status := C.napi_is_date(env.inner, value, &result)
+	if err := env.mapStatus(status); err != nil {
+		return false, err
+	}
+	return bool(result), nil
+}
+
+func (env Env) IsError(value Value) (bool, error) {
+	var result C.bool
+	status := C.napi_is_error(env.inner, value, &result)
+	if err := env.mapStatus(status); err != nil {
+		return false, err
+	}
+	return bool(result), nil
+}
+
+func (env Env) InstanceOf(object Value, constructor Value) (bool, error) {
+	var result C.bool
+	status := C.napi_instanceof(env.inner, object, constructor, &result)
+	if err := env.mapStatus(status); err != nil {
+		return false, err
+	}
+	return bool(result), nil
+}
+
+func (env Env) IsDataview(value Value) (bool, error) {
+	var result C.bool
+	status := C.napi_is_dataview(env.inner, value, &result)
+	if err := env.mapStatus(status); err != nil {
+		return false, err
+	}
+	return bool(result), nil
+}
+
+func (env Env) IsTypedArray(value Value) (bool, error) {
+	var result C.bool
+	status := C.napi_is_typedarray(env.inner, value, &result)
+	if err := env.mapStatus(status); err != nil {
+		return false, err
+	}
+	return bool(result), nil
+}
+
+func (env Env) StrictEquals(lhs Value, rhs Value) (bool, error) {
+	var result C.bool
+	status := C.napi_strict_equals(env.inner, lhs, rhs, &result)
+	if err := env.mapStatus(status); err != nil {
+		return false, err
+	}
+	return bool(result), nil
+}
+
 // Working with JavaScript properties
 
 type KeyCollectionMode C.napi_key_collection_mode
@@ -384,6 +609,15 @@ func (env Env) GetAllPropertyNames(object Value, keyMode KeyCollectionMode, keyF
 	return Value(result), nil
 }
 
+func (env Env) GetPrototype(object Value) (Value, error) {
+	var result C.napi_value
+	status := C.napi_get_prototype(env.inner, object, &result)
+	if err := env.mapStatus(status); err != nil {
+		return nil, err
+	}
+	return Value(result), nil
+}
+
 func (env Env) SetProperty(object Value, key Value, value Value) error {
 	return env.mapStatus(C.napi_set_property(env.inner, object, key, value))
 }
@@ -397,6 +631,26 @@ func (env Env) GetProperty(object Value, key Value) (Value, error) {
 	return Value(result), nil
 }
 
+func (env Env) HasOwnProperty(object Value, key Value) (bool, error) {
+	var result C.bool
+	status := C.napi_has_own_property(env.inner, object, key, &result)
+	if err := env.mapStatus(status); err != nil {
+		return false, err
+	}
+	return bool(result), nil
+}
+
+func (env Env) GetNamedProperty(object Value, name string) (Value, error) {
+	var result C.napi_value
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	status := C.napi_get_named_property(env.inner, object, cName, &result)
+	if err := env.mapStatus(status); err != nil {
+		return nil, err
+	}
+	return Value(result), nil
+}
+
 func (env Env) SetElement(object Value, index uint32, value Value) error {
 	status := C.napi_set_element(env.inner, object, C.uint32_t(index), value)
 	return env.mapStatus(status)
@@ -518,3 +772,100 @@ func (env Env) CheckObjectTypeTag(jsObject Value, typeTag *TypeTag) (bool, error
 	}
 	return bool(result), nil
 }
+
+// Simple asynchronous operations
+
+type AsyncWork C.napi_async_work
+type AsyncExecuteCallback C.napi_async_execute_callback
+type AsyncCompleteCallback C.napi_async_complete_callback
+
+func (env Env) CreateAsyncWork(asyncResource Value, asyncResourceName Value, execute AsyncExecuteCallback, complete AsyncCompleteCallback, data unsafe.Pointer) (AsyncWork, error) {
+	var result C.napi_async_work
+	status := C.napi_create_async_work(env.inner, asyncResource, asyncResourceName, execute, complete, data, &result)
+	if err := env.mapStatus(status); err != nil {
+		return nil, err
+	}
+	return AsyncWork(result), nil
+}
+
+func (env Env) DeleteAsyncWork(work AsyncWork) error {
+	return env.mapStatus(C.napi_delete_async_work(env.inner, work))
+}
+
+func (env Env) QueueAsyncWork(work AsyncWork) error {
+	return env.mapStatus(C.napi_queue_async_work(env.inner, work))
+}
+
+// Promises
+
+type Deferred C.napi_deferred
+
+func (env Env) CreatePromise() (Value, Deferred, error) {
+	var deferred C.napi_deferred
+	var promise C.napi_value
+	status := C.napi_create_promise(env.inner, &deferred, &promise)
+	if err := env.mapStatus(status); err != nil {
+		return nil, nil, err
+	}
+	return Value(promise), Deferred(deferred), nil
+}
+
+func (env Env) ResolveDeferred(deferred Deferred, resolution Value) error {
+	return env.mapStatus(C.napi_resolve_deferred(env.inner, deferred, resolution))
+}
+
+func (env Env) RejectDeferred(deferred Deferred, rejection Value) error {
+	return env.mapStatus(C.napi_reject_deferred(env.inner, deferred, rejection))
+}
+
+// Asynchronous thread-safe function calls
+
+type ThreadsafeFunction C.napi_threadsafe_function
+type ThreadsafeFunctionCallJs C.napi_threadsafe_function_call_js
+
+func (env Env) CreateThreadsafeFunction(fn Value, asyncResource Value, asyncResourceName Value, maxQueueSize int, initialThreadCount int, context unsafe.Pointer, callJs ThreadsafeFunctionCallJs) (ThreadsafeFunction, error) {
+	var result C.napi_threadsafe_function
+	status := C.napi_create_threadsafe_function(
+		env.inner,
+		fn,
+		asyncResource,
+		asyncResourceName,
+		C.size_t(maxQueueSize),
+		C.size_t(initialThreadCount),
+		nil,
+		nil,
+		context,
+		callJs,
+		&result,
+	)
+	if err := env.mapStatus(status); err != nil {
+		return nil, err
+	}
+	return ThreadsafeFunction(result), nil
+}
+
+// The thread-safe function APIs below may be called from any thread, so they
+// can't use an Env to get extended error information.
+
+func CallThreadsafeFunction(fn ThreadsafeFunction, data unsafe.Pointer, isBlocking bool) error {
+	mode := C.napi_tsfn_nonblocking
+	if isBlocking {
+		mode = C.napi_tsfn_blocking
+	}
+	return mapBareStatus(C.napi_call_threadsafe_function(fn, data, C.napi_threadsafe_function_call_mode(mode)))
+}
+
+func ReleaseThreadsafeFunction(fn ThreadsafeFunction, abort bool) error {
+	mode := C.napi_tsfn_release
+	if abort {
+		mode = C.napi_tsfn_abort
+	}
+	return mapBareStatus(C.napi_release_threadsafe_function(fn, C.napi_threadsafe_function_release_mode(mode)))
+}
+
+func mapBareStatus(status C.napi_status) error {
+	if status == C.napi_ok {
+		return nil
+	}
+	return fmt.Errorf("Node-API error code %v", status)
+}
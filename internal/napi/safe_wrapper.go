@@ -28,13 +28,26 @@ func (sfw *SafeWrapper[T]) Wrap(env Env, jsObject Value, goObject *T, finalizeFu
 }
 
 func (sfw *SafeWrapper[T]) Unwrap(env Env, jsObject Value) (*T, error) {
+	result, err := sfw.TryUnwrap(env, jsObject)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, fmt.Errorf("missing or invalid type tag")
+	}
+	return result, nil
+}
+
+// TryUnwrap is like Unwrap, but returns nil instead of an error if the object
+// doesn't have the right type tag.
+func (sfw *SafeWrapper[T]) TryUnwrap(env Env, jsObject Value) (*T, error) {
 	// Check the type tag
 	tagOk, err := env.CheckObjectTypeTag(jsObject, &sfw.tag)
 	if err != nil {
 		return nil, err
 	}
 	if !tagOk {
-		return nil, fmt.Errorf("missing or invalid type tag")
+		return nil, nil
 	}
 
 	// Unwrap the object
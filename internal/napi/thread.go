@@ -0,0 +1,14 @@
+//go:build !windows
+
+package napi
+
+// #include <pthread.h>
+// #include <stdint.h>
+// static uintptr_t currentThreadID() { return (uintptr_t)pthread_self(); }
+import "C"
+
+// CurrentThreadID returns an identifier for the calling OS thread. Goroutines
+// running in a Node-API callback stay on the calling thread until it returns.
+func CurrentThreadID() uintptr {
+	return uintptr(C.currentThreadID())
+}
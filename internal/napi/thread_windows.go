@@ -0,0 +1,10 @@
+package napi
+
+// #include <windows.h>
+import "C"
+
+// CurrentThreadID returns an identifier for the calling OS thread. Goroutines
+// running in a Node-API callback stay on the calling thread until it returns.
+func CurrentThreadID() uintptr {
+	return uintptr(C.GetCurrentThreadId())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+// jsBytesToGo returns the contents of a string, or of a Buffer or other
+// Uint8Array. The result refers to memory owned by JS, so it must not be used
+// after returning to JS.
+func jsBytesToGo(env napi.Env, value napi.Value) ([]byte, error) {
+	valueType, err := env.Typeof(value)
+	if err != nil {
+		return nil, err
+	}
+	if valueType == napi.String {
+		str, err := jsStringToGo(env, value)
+		return []byte(str), err
+	}
+	if valueType == napi.Object {
+		isTypedArray, err := env.IsTypedArray(value)
+		if err != nil {
+			return nil, err
+		}
+		if isTypedArray {
+			arrType, data, err := env.GetTypedArrayInfo(value)
+			if err != nil {
+				return nil, err
+			}
+			if arrType == napi.Uint8Array || arrType == napi.Uint8ClampedArray {
+				return data, nil
+			}
+		}
+	}
+	// TODO: Custom error mechanism
+	if err := env.ThrowTypeError("ERR_INVALID_ARG_TYPE", "Expected a string, Buffer, or Uint8Array"); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("threw exception")
+}
+
+// decodeJSONData decodes JSON text for use as template data. Numbers are
+// converted like JS numbers, except that integers outside the safe range are
+// kept exact if they fit in an int64.
+func decodeJSONData(data []byte, opts conversionOptions) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var result interface{}
+	if err := dec.Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid JSON data: %w", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid JSON data: unexpected data after top-level value")
+	}
+	return convertJSONNumbers(result, opts), nil
+}
+
+func convertJSONNumbers(value interface{}, opts conversionOptions) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = convertJSONNumbers(elem, opts)
+		}
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = convertJSONNumbers(elem, opts)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil && !opts.floatNumbers {
+			return i
+		}
+		// Float64 only fails for out-of-range values, which become infinite
+		f, _ := v.Float64()
+		return opts.numberToGo(f)
+	}
+	return value
+}
+
+// jsJSONToGo decodes JSON text passed from JS for use as template data.
+func jsJSONToGo(env napi.Env, value napi.Value, opts conversionOptions) (interface{}, error) {
+	data, err := jsBytesToGo(env, value)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSONData(data, opts)
+}
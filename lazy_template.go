@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+// lazyFillName is the name of the function, and of the variable holding its
+// result, that fills in lazy values before a template reads them.
+const lazyFillName = "_napi_lazy_fill"
+
+// lazyPlan describes what a call to the lazy fill function fills in, before
+// the template runs the pipeline with the given source.
+type lazyPlan struct {
+	source string
+	// shapes holds the uses of the function's arguments
+	shapes []*dataShape
+}
+
+// lazyFillError is returned by the lazy fill function when converting part of
+// the data fails.
+type lazyFillError struct {
+	source string
+	err    error
+}
+
+func (lfe *lazyFillError) Error() string {
+	return lfe.err.Error()
+}
+
+func (lfe *lazyFillError) Unwrap() error {
+	return lfe.err
+}
+
+// makeLazyFill returns the lazy fill function for templates prepared with
+// plans. It's called with the index of its plan and the values to fill in, and
+// returns the last of them.
+func makeLazyFill(es *envStack, plans []*lazyPlan) func(int, ...any) (any, error) {
+	return func(index int, values ...any) (any, error) {
+		if ld := es.LazyData(); ld != nil && index >= 0 && index < len(plans) {
+			plan := plans[index]
+			for i, value := range values {
+				if i >= len(plan.shapes) {
+					break
+				}
+				if err := ld.fill(value, plan.shapes[i]); err != nil {
+					return nil, &lazyFillError{plan.source, err}
+				}
+			}
+		}
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return values[len(values)-1], nil
+	}
+}
+
+// prepareLazyFills changes the parse trees of a copy of a set of templates to
+// fill in lazy values before each action reads them. An action filling in the
+// values used by each node of a list is inserted before it, and the results of
+// JS functions are filled in by a command following them:
+//
+//	{{$_napi_lazy_fill := _napi_lazy_fill 0 . $x}}{{.a.b}}{{$x.c}}
+//	{{(getUser | _napi_lazy_fill 1).name}}
+//
+// The jsFuncs are the names of functions that get their arguments as JS
+// values, and return them.
+func prepareLazyFills(set goTemplate, es *envStack, jsFuncs map[string]bool) {
+	lp := &lazyPreparer{si: &shapeInferrer{jsFuncs: jsFuncs}}
+	for _, tmpl := range set.Templates() {
+		if tree := tmpl.Tree(); tree != nil && tree.Root != nil {
+			lp.list(tree, tree.Root)
+		}
+	}
+	set.Funcs(template.FuncMap{lazyFillName: makeLazyFill(es, lp.plans)})
+}
+
+type lazyPreparer struct {
+	si    *shapeInferrer
+	plans []*lazyPlan
+}
+
+func (lp *lazyPreparer) list(tree *parse.Tree, list *parse.ListNode) {
+	nodes := make([]parse.Node, 0, len(list.Nodes))
+	for _, node := range list.Nodes {
+		var fill *parse.ActionNode
+		switch n := node.(type) {
+		case *parse.ActionNode:
+			fill = lp.fillAction(tree, n.Pipe, func(result *dataShape) {
+				if len(n.Pipe.Decl) == 0 {
+					result.printed = true
+				}
+			})
+		case *parse.IfNode:
+			fill = lp.fillAction(tree, n.Pipe, func(result *dataShape) { result.tested = true })
+			lp.branch(tree, &n.BranchNode)
+		case *parse.RangeNode:
+			fill = lp.fillAction(tree, n.Pipe, func(result *dataShape) { result.element() })
+			lp.branch(tree, &n.BranchNode)
+		case *parse.TemplateNode:
+			fill = lp.fillAction(tree, n.Pipe, func(result *dataShape) {})
+		case *parse.WithNode:
+			fill = lp.fillAction(tree, n.Pipe, func(result *dataShape) { result.tested = true })
+			lp.branch(tree, &n.BranchNode)
+		}
+		if fill != nil {
+			nodes = append(nodes, fill)
+		}
+		nodes = append(nodes, node)
+	}
+	list.Nodes = nodes
+}
+
+func (lp *lazyPreparer) branch(tree *parse.Tree, branch *parse.BranchNode) {
+	lp.list(tree, branch.List)
+	if branch.ElseList != nil {
+		lp.list(tree, branch.ElseList)
+	}
+}
+
+// fillAction returns an action filling in the values pipe reads from dot and
+// any variables, where use records how the pipe's result is used. It returns
+// nil if there's nothing to fill in.
+func (lp *lazyPreparer) fillAction(tree *parse.Tree, pipe *parse.PipeNode, use func(result *dataShape)) *parse.ActionNode {
+	if pipe == nil {
+		return nil
+	}
+	scope := &shapeScope{&dataShape{}, make(map[string]*dataShape)}
+	vars := make(map[string]bool)
+	pipeVariables(pipe, vars)
+	for name := range vars {
+		scope.vars[name] = &dataShape{}
+	}
+	lp.si.jsResults = nil
+	if result := lp.si.walkPipe(scope, pipe); result != nil {
+		use(result)
+	}
+	for _, jsr := range lp.si.jsResults {
+		if jsr.shape.empty() {
+			// Passed straight to another JS function, or not used
+			continue
+		}
+		plan := &lazyPlan{source: stripLazyFills(jsr.cmd.String()), shapes: []*dataShape{jsr.shape}}
+		fill := lp.fillCommand(tree, jsr.cmd.Position(), plan)
+		i := slices.Index(jsr.pipe.Cmds, jsr.cmd)
+		jsr.pipe.Cmds = slices.Insert(jsr.pipe.Cmds, i+1, fill)
+	}
+
+	pos, line := pipe.Position(), pipe.Line
+	plan := &lazyPlan{source: pipeSource(pipe)}
+	var args []parse.Node
+	if !scope.dot.empty() {
+		plan.shapes = append(plan.shapes, scope.dot)
+		args = append(args, &parse.DotNode{NodeType: parse.NodeDot, Pos: pos})
+	}
+	for _, name := range slices.Sorted(maps.Keys(vars)) {
+		if shape := scope.vars[name]; !shape.empty() {
+			plan.shapes = append(plan.shapes, shape)
+			args = append(args, &parse.VariableNode{NodeType: parse.NodeVariable, Pos: pos, Ident: []string{name}})
+		}
+	}
+	if len(plan.shapes) == 0 {
+		return nil
+	}
+	cmd := lp.fillCommand(tree, pos, plan, args...)
+
+	decl := &parse.VariableNode{NodeType: parse.NodeVariable, Pos: pos, Ident: []string{"$" + lazyFillName}}
+	fillPipe := &parse.PipeNode{NodeType: parse.NodePipe, Pos: pos, Line: line, Decl: []*parse.VariableNode{decl}, Cmds: []*parse.CommandNode{cmd}}
+	return &parse.ActionNode{NodeType: parse.NodeAction, Pos: pos, Line: line, Pipe: fillPipe}
+}
+
+// fillCommand adds plan to the plans, and returns a command calling the lazy
+// fill function for it with args. Nodes built here needn't refer to tree,
+// since parse.Tree.ErrorContext uses the tree it's called on for those that
+// don't.
+func (lp *lazyPreparer) fillCommand(tree *parse.Tree, pos parse.Pos, plan *lazyPlan, args ...parse.Node) *parse.CommandNode {
+	index := len(lp.plans)
+	lp.plans = append(lp.plans, plan)
+	cmd := &parse.CommandNode{NodeType: parse.NodeCommand, Pos: pos}
+	cmd.Args = slices.Concat([]parse.Node{
+		parse.NewIdentifier(lazyFillName).SetTree(tree).SetPos(pos),
+		&parse.NumberNode{NodeType: parse.NodeNumber, Pos: pos, IsInt: true, Int64: int64(index), Text: strconv.Itoa(index)},
+	}, args)
+	return cmd
+}
+
+// empty reports whether the shape describes no use of a value.
+func (ds *dataShape) empty() bool {
+	return len(ds.fields) == 0 && ds.elem == nil && !ds.printed && !ds.tested && !ds.whole
+}
+
+// pipeVariables adds the names of the variables pipe reads to vars.
+func pipeVariables(pipe *parse.PipeNode, vars map[string]bool) {
+	var visit func(node parse.Node)
+	visit = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ChainNode:
+			visit(n.Node)
+		case *parse.PipeNode:
+			pipeVariables(n, vars)
+		case *parse.VariableNode:
+			vars[n.Ident[0]] = true
+		}
+	}
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			visit(arg)
+		}
+	}
+}
+
+// isLazyFill reports whether node is an action inserted by prepareLazyFills.
+func isLazyFill(node *parse.ActionNode) bool {
+	decl := node.Pipe.Decl
+	return len(decl) == 1 && decl[0].Ident[0] == "$"+lazyFillName
+}
+
+// isLazyFillCommand reports whether cmd calls the lazy fill function.
+func isLazyFillCommand(cmd *parse.CommandNode) bool {
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	return ok && ident.Ident == lazyFillName
+}
+
+var lazyFillCmdRe = regexp.MustCompile(` \| ` + lazyFillName + ` \d+`)
+
+// stripLazyFills removes the commands filling in the results of JS functions
+// from the source of a node.
+func stripLazyFills(source string) string {
+	return lazyFillCmdRe.ReplaceAllLiteralString(source, "")
+}
+
+// hideLazyFills rewrites an error from executing tmpl, a copy prepared by
+// prepareLazyFills, so it doesn't refer to the lazy fill function. Errors from
+// the function refer to the source it's filling in for instead, as if the
+// error had occurred running it, and errors from nodes it was inserted into
+// refer to them as they were parsed. The sources of the templates' trees are
+// given by name, where known.
+func hideLazyFills(tmpl goTemplate, err error, sources map[string]*treeSource) error {
+	var execErr template.ExecError
+	if _, ok := tmpl.(lazyCopy); !ok || !errors.As(err, &execErr) {
+		return err
+	}
+	msg := execErr.Err.Error()
+	m := execErrorRe.FindStringSubmatch(msg)
+	if m == nil {
+		return err
+	}
+	prefix := strings.TrimSuffix(m[0], m[4]+">: ")
+	detail := msg[len(m[0]):]
+	cause := errors.Unwrap(execErr.Err)
+	var fillErr *lazyFillError
+	if errors.As(err, &fillErr) {
+		prefix += fillErr.source
+		detail = fillErr.Error()
+		cause = fillErr.err
+	} else {
+		named := tmpl.Lookup(execErr.Name)
+		if named == nil || named.Tree() == nil {
+			return err
+		}
+		line, _ := strconv.Atoi(m[2])
+		col, _ := strconv.Atoi(m[3])
+		context, ok := lazyFillContext(named.Tree(), sources[execErr.Name], line, col, m[4])
+		if !ok {
+			return err
+		}
+		prefix += context
+	}
+	prefix += ">: "
+	if cause == nil || !strings.HasSuffix(detail, cause.Error()) {
+		return template.ExecError{Name: execErr.Name, Err: errors.New(prefix + detail)}
+	}
+	detail = strings.TrimSuffix(detail, cause.Error())
+	return template.ExecError{Name: execErr.Name, Err: fmt.Errorf("%s%s%w", prefix, detail, cause)}
+}
+
+// lazyFillContext returns the context text/template reports for errors at the
+// node at the given location of tree, as it would for the tree without lazy
+// fill commands, if the node's context is context and differs without them.
+// Errors from the nodes of a command filling in the result of a JS function
+// refer to the command calling the function. The tree's source is given if
+// known.
+func lazyFillContext(tree *parse.Tree, source *treeSource, line int, col int, context string) (string, bool) {
+	lines := newLineIndex(tree, source)
+	matches := func(node parse.Node) bool {
+		if nodeLine, nodeCol := lines.location(node); nodeLine != line || nodeCol != col {
+			return false
+		}
+		_, nodeContext := tree.ErrorContext(node)
+		return nodeContext == context
+	}
+	var result string
+	var found bool
+	use := func(source string) {
+		result = stripLazyFills(source)
+		if len(result) > 20 {
+			// Abbreviated like parse.Tree.ErrorContext
+			result = fmt.Sprintf("%.20s...", result)
+		}
+		found = true
+	}
+	var visit func(node parse.Node)
+	visit = func(node parse.Node) {
+		if found {
+			return
+		}
+		if source := node.String(); lazyFillCmdRe.MatchString(source) && matches(node) {
+			use(source)
+			return
+		}
+		switch n := node.(type) {
+		case *parse.ActionNode:
+			visit(n.Pipe)
+		case *parse.ChainNode:
+			visit(n.Node)
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				visit(arg)
+			}
+		case *parse.IfNode:
+			visitLazyFillBranch(&n.BranchNode, visit)
+		case *parse.ListNode:
+			for _, child := range n.Nodes {
+				visit(child)
+			}
+		case *parse.PipeNode:
+			for i, cmd := range n.Cmds {
+				if i == 0 || !isLazyFillCommand(cmd) {
+					visit(cmd)
+					continue
+				}
+				for _, fillNode := range slices.Concat([]parse.Node{cmd}, cmd.Args) {
+					if !found && matches(fillNode) {
+						use(n.Cmds[i-1].String())
+					}
+				}
+			}
+		case *parse.RangeNode:
+			visitLazyFillBranch(&n.BranchNode, visit)
+		case *parse.TemplateNode:
+			if n.Pipe != nil {
+				visit(n.Pipe)
+			}
+		case *parse.WithNode:
+			visitLazyFillBranch(&n.BranchNode, visit)
+		}
+	}
+	if tree.Root != nil {
+		visit(tree.Root)
+	}
+	return result, found
+}
+
+func visitLazyFillBranch(branch *parse.BranchNode, visit func(parse.Node)) {
+	visit(branch.Pipe)
+	visit(branch.List)
+	if branch.ElseList != nil {
+		visit(branch.ElseList)
+	}
+}
+
+// lazySetFor returns a copy of the set of templates tmpl belongs to, prepared to
+// fill in lazy values. It's kept until the set changes.
+func (ta *templateAssn) lazySetFor(tmpl goTemplate, es *envStack) (goTemplate, error) {
+	if ta.lazySet != nil {
+		return ta.lazySet, nil
+	}
+	result, err := tmpl.Copy()
+	if err != nil {
+		return nil, err
+	}
+	// The call builtin passes its arguments to functions from the data,
+	// which are JS functions
+	jsFuncs := map[string]bool{"call": true}
+	for name := range ta.funcRefs {
+		jsFuncs[name] = true
+	}
+	prepareLazyFills(result, es, jsFuncs)
+	ta.lazySet = result
+	return result, nil
+}
+
+// lazyCopy is a template from a copy of a set prepared by prepareLazyFills.
+type lazyCopy struct {
+	goTemplate
+}
+
+// execTemplate returns the template to run an execution of jst with, for data
+// with the lazy values tracked by ld, along with the lazyData to track them
+// and the results of JS functions with, which is new if ld is nil. In lazy
+// mode, the templates are copied to be prepared to fill in lazy values.
+// html/template templates can't be copied once they've been executed, so
+// those are run as they are, with the lazy values filled in up front.
+// Asynchronous executions get a snapshot otherwise, which is unaffected by
+// later changes.
+func (jst *jsTemplate) execTemplate(env napi.Env, es *envStack, ld *lazyData, async bool) (goTemplate, *lazyData, error) {
+	if ld == nil {
+		ld = &lazyData{es: es, opts: jst.assn.conversion}
+	}
+	if jst.assn.conversion.lazy {
+		set, err := jst.assn.lazySetFor(jst.inner, es)
+		if err == nil {
+			if tmpl := set.Lookup(jst.inner.Name()); tmpl != nil {
+				ld.fills = true
+				return lazyCopy{tmpl}, ld, nil
+			}
+		} else if jst.inner.Class() != htmlTemplateClass {
+			return nil, ld, err
+		} else if err := ld.fillAll(env); err != nil {
+			return nil, ld, err
+		}
+	}
+	if async {
+		tmpl, err := jst.inner.Snapshot()
+		return tmpl, ld, err
+	}
+	return jst.inner, ld, nil
+}
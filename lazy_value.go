@@ -0,0 +1,554 @@
+package main
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+// Lazy values are the map[string]any and []any values holding the parts of a
+// JS object or array that have been converted so far, which are filled in from
+// the JS value as a template reads them (see lazyData.fill). They're ordinary
+// maps and slices, so they work like any other converted data, and are told
+// apart by being tracked by the lazyData of their execution.
+
+// lazyKind is the kind of JS value behind a lazy value.
+type lazyKind int
+
+const (
+	lazyObjectKind lazyKind = iota
+	lazyArrayKind
+	lazyMapKind
+	lazySetKind
+)
+
+// lazyState records the JS value behind a lazy value, and how much of it has
+// been converted.
+type lazyState struct {
+	// value is the map or slice holding the parts of the JS value
+	// converted so far, which keeps its key in lazyData.states in use
+	value any
+	ref   napi.Ref
+	kind  lazyKind
+
+	// path is the path from the root of the data to the value, for error
+	// messages
+	path []pathKey
+
+	// filled holds the names of the properties that have been looked up,
+	// whether or not they exist. Properties may also have nil placeholders
+	// that haven't been looked up, if only the object's truth was needed.
+	filled map[string]bool
+
+	// tested is set once enough properties have been filled in to give the
+	// object its truth, all once every own property or element has been,
+	// and deep once the whole value has been converted
+	tested bool
+	all    bool
+	deep   bool
+}
+
+// lazyKey returns the key of a lazy value in lazyData.states, which is the
+// address of its underlying map or array.
+func lazyKey(value any) (uintptr, bool) {
+	switch v := value.(type) {
+	case map[string]any:
+		return reflect.ValueOf(v).Pointer(), v != nil
+	case []any:
+		return reflect.ValueOf(v).Pointer(), cap(v) > 0
+	}
+	return 0, false
+}
+
+// lazyData tracks the lazy values created for the data of one execution, whose
+// references are released once it finishes. Its methods may only be called by
+// the thread executing the template, or on the JS thread while that thread
+// waits for them.
+type lazyData struct {
+	es   *envStack
+	opts conversionOptions
+
+	// states maps the keys of the lazy values (see lazyKey) to their
+	// lazyStates
+	states map[uintptr]*lazyState
+
+	// fills is set if the template being executed fills in lazy values, so
+	// the results of JS functions can be lazy values too
+	fills bool
+
+	// properties counts the properties converted so far, for the
+	// maxProperties limit
+	properties int
+}
+
+// lookup returns the state of a lazy value, or nil if it's not one tracked by
+// ld, which may be nil.
+func (ld *lazyData) lookup(value any) *lazyState {
+	if ld == nil {
+		return nil
+	}
+	key, ok := lazyKey(value)
+	if !ok {
+		return nil
+	}
+	state := ld.states[key]
+	if state == nil {
+		return nil
+	}
+	if arr, ok := value.([]any); ok && len(arr) != len(state.value.([]any)) {
+		// Slicing the array gives a different value with the same key
+		return nil
+	}
+	return state
+}
+
+// wrap wraps the object or array value found at path in a new lazy value.
+func (ld *lazyData) wrap(env napi.Env, value napi.Value, kind lazyKind, path []pathKey) (any, error) {
+	var result any
+	switch kind {
+	case lazyArrayKind, lazySetKind:
+		var length uint32
+		if kind == lazyArrayKind {
+			var err error
+			if length, err = env.GetArrayLength(value); err != nil {
+				return nil, err
+			}
+		} else {
+			size, err := env.GetNamedProperty(value, "size")
+			if err != nil {
+				return nil, err
+			}
+			sizeNum, err := env.GetValueDouble(size)
+			if err != nil {
+				return nil, err
+			}
+			length = uint32(sizeNum)
+		}
+		// Empty arrays get room for an element, so they have a key of
+		// their own
+		result = make([]any, length, max(length, 1))
+	default:
+		result = make(map[string]any)
+	}
+	if _, err := ld.register(env, value, result, kind, path); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// wrapFilled wraps the value found at path in a lazy value that's already
+// converted in full, as given by result, which is a map with the properties
+// given by names or a slice, so it can still be passed to JS functions as the
+// value itself. Other results are returned as they are.
+func (ld *lazyData) wrapFilled(env napi.Env, value napi.Value, result any, names []string, path []pathKey) (any, error) {
+	kind := lazyObjectKind
+	switch v := result.(type) {
+	case map[string]any:
+	case []any:
+		kind = lazyArrayKind
+		if cap(v) == 0 {
+			// Empty slices get room for an element, so they have a key
+			// of their own
+			result = make([]any, 0, 1)
+		}
+	default:
+		return result, nil
+	}
+	state, err := ld.register(env, value, result, kind, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		state.filled[name] = true
+	}
+	state.tested = true
+	state.all = true
+	state.deep = true
+	return state.value, nil
+}
+
+// register records the lazy value result, holding the JS value found at path.
+func (ld *lazyData) register(env napi.Env, value napi.Value, result any, kind lazyKind, path []pathKey) (*lazyState, error) {
+	ref, err := env.CreateReference(value, 1)
+	if err != nil {
+		return nil, err
+	}
+	state := &lazyState{
+		value:  result,
+		ref:    ref,
+		kind:   kind,
+		path:   slices.Clone(path),
+		filled: make(map[string]bool),
+	}
+	if ld.states == nil {
+		ld.states = make(map[uintptr]*lazyState)
+	}
+	key, _ := lazyKey(result)
+	ld.states[key] = state
+	return state, nil
+}
+
+// Release forgets the lazy values, and deletes their references. It must be
+// called on the JS thread.
+func (ld *lazyData) Release(env napi.Env) error {
+	if ld == nil {
+		return nil
+	}
+	states := ld.states
+	ld.states = nil
+	var result error
+	for _, state := range states {
+		if err := env.DeleteReference(state.ref); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// lazyNeed describes what has to be filled in of a lazy value before a
+// template reads it.
+type lazyNeed struct {
+	// deep is set if all of it is needed, recursively
+	deep bool
+	// all is set if all of its own properties or elements are needed
+	all bool
+	// truth is set if enough of it is needed to test its truth
+	truth bool
+	// names holds the properties that are needed
+	names []string
+}
+
+// need returns what has to be filled in of the lazy value for a use described
+// by shape, or false if nothing does.
+func (state *lazyState) need(shape *dataShape) (lazyNeed, bool) {
+	var need lazyNeed
+	switch {
+	case state.kind == lazyMapKind || state.kind == lazySetKind:
+		// Maps and Sets are converted in one go, since they can only
+		// be iterated over as a whole
+		need.deep = !state.deep
+		return need, need.deep
+	case (shape.whole || shape.printed) && !state.deep:
+		need.deep = true
+		return need, true
+	case state.kind == lazyArrayKind:
+		// The length of an array, and so its truth, is known up front,
+		// and it has no fields
+		need.all = shape.elem != nil && !state.all
+		return need, need.all
+	}
+	if state.all {
+		// Only properties inherited from the object's class can be
+		// missing, since converting it in full only converts its own
+		for name := range shape.fields {
+			if !state.filled[name] {
+				need.names = append(need.names, name)
+			}
+		}
+		return need, len(need.names) > 0
+	}
+	need.all = shape.elem != nil
+	need.truth = shape.tested && !state.tested
+	for name := range shape.fields {
+		if !state.filled[name] {
+			need.names = append(need.names, name)
+		}
+	}
+	return need, need.all || need.truth || len(need.names) > 0
+}
+
+// fill fills in the parts of value needed for a use described by shape,
+// looking through plain maps and slices for lazy values.
+func (ld *lazyData) fill(value any, shape *dataShape) error {
+	if shape == nil {
+		return nil
+	}
+	if (shape.whole || shape.printed) && ld.hasShallowLazy(value) {
+		err := ld.callOnJsThread(func(env napi.Env) error {
+			return ld.fillDeep(env, value)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if state := ld.lookup(value); state != nil {
+		if need, ok := state.need(shape); ok {
+			err := ld.callOnJsThread(func(env napi.Env) error {
+				return ld.fillState(env, state, need)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for name, field := range shape.fields {
+		var child any
+		var ok bool
+		if m, isMap := value.(map[string]any); isMap {
+			child, ok = m[name]
+		}
+		if ok {
+			if err := ld.fill(child, field); err != nil {
+				return err
+			}
+		}
+	}
+	if shape.elem != nil {
+		for _, elem := range elements(value) {
+			if err := ld.fill(elem, shape.elem); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// elements returns the elements of a slice, or the values of a map, that
+// ranging over value would visit.
+func elements(value any) []any {
+	switch v := value.(type) {
+	case []any:
+		return v
+	case map[string]any:
+		return slices.Collect(maps.Values(v))
+	}
+	return nil
+}
+
+// hasShallowLazy reports whether value is or contains a lazy value that
+// hasn't been filled in completely.
+func (ld *lazyData) hasShallowLazy(value any) bool {
+	if state := ld.lookup(value); state != nil && !state.deep {
+		return true
+	}
+	for _, elem := range elements(value) {
+		if ld.hasShallowLazy(elem) {
+			return true
+		}
+	}
+	return false
+}
+
+// callOnJsThread runs fn on the JS thread, capturing any exception it throws
+// to be rethrown when the execution fails.
+func (ld *lazyData) callOnJsThread(fn func(napi.Env) error) error {
+	return ld.es.CallOnJsThread(func(env napi.Env) error {
+		if err := fn(env); err != nil {
+			return captureJsException(env, err)
+		}
+		return nil
+	})
+}
+
+// converter returns a jsConverter for filling in the lazy value with the JS
+// value obj. If deep is set, the values it contains are converted in full,
+// rather than wrapped in further lazy values.
+func (ld *lazyData) converter(state *lazyState, obj napi.Value, deep bool) *jsConverter {
+	return &jsConverter{
+		opts:       ld.opts,
+		data:       ld,
+		deep:       deep,
+		ancestors:  []napi.Value{obj},
+		keys:       slices.Clone(state.path),
+		base:       len(state.path),
+		properties: ld.properties,
+	}
+}
+
+// fillState fills in the parts of a lazy value given by need. It must be
+// called on the JS thread.
+func (ld *lazyData) fillState(env napi.Env, state *lazyState, need lazyNeed) error {
+	if need.deep {
+		return ld.fillDeep(env, state.value)
+	}
+	obj, err := env.GetReferenceValue(state.ref)
+	if err != nil {
+		return err
+	}
+	jc := ld.converter(state, obj, false)
+	defer func() { ld.properties = jc.properties }()
+
+	if arr, ok := state.value.([]any); ok {
+		for i := range arr {
+			elt, err := env.GetElement(obj, uint32(i))
+			if err != nil {
+				return err
+			}
+			if arr[i], err = jc.child(env, pathKey{index: uint32(i), isIndex: true}, elt); err != nil {
+				return err
+			}
+		}
+		state.all = true
+		return nil
+	}
+
+	m := state.value.(map[string]any)
+	if need.all || need.truth {
+		names, err := ownPropertyNames(env, obj)
+		if err != nil {
+			return err
+		}
+		own := make(map[string]bool, len(names))
+		for _, name := range names {
+			own[name] = true
+			if state.filled[name] {
+				if _, ok := m[name]; ok && !need.all {
+					break
+				}
+				continue
+			}
+			if need.truth && !need.all && jc.opts.keepUndefined {
+				// No value can be omitted, so a placeholder is enough
+				m[name] = nil
+				break
+			}
+			if err := jc.objectProperty(env, obj, name, m); err != nil {
+				return err
+			}
+			state.filled[name] = true
+			if _, ok := m[name]; ok && !need.all {
+				break
+			}
+		}
+		state.tested = true
+		state.all = state.all || need.all
+	}
+	for _, name := range need.names {
+		if state.filled[name] {
+			continue
+		}
+		elt, ok, err := jc.property(env, obj, name)
+		if err != nil {
+			return err
+		}
+		state.filled[name] = true
+		if !ok {
+			continue
+		}
+		omit, err := jc.omitted(env, elt)
+		if err != nil {
+			return err
+		}
+		if omit {
+			continue
+		}
+		if m[name], err = jc.child(env, pathKey{name: name}, elt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillAll fills in all of the lazy values tracked by ld, for running a template
+// that can't fill them in itself. It must be called on the JS thread.
+func (ld *lazyData) fillAll(env napi.Env) error {
+	// Filling values in can track more of them
+	states := make([]*lazyState, 0, len(ld.states))
+	for _, state := range ld.states {
+		states = append(states, state)
+	}
+	for _, state := range states {
+		if err := ld.fillDeep(env, state.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillDeep fills in all of value, and of the lazy values it contains. It must
+// be called on the JS thread.
+func (ld *lazyData) fillDeep(env napi.Env, value any) error {
+	state := ld.lookup(value)
+	if state == nil || state.deep {
+		for _, elem := range elements(value) {
+			if err := ld.fillDeep(env, elem); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	obj, err := env.GetReferenceValue(state.ref)
+	if err != nil {
+		return err
+	}
+	jc := ld.converter(state, obj, true)
+	defer func() { ld.properties = jc.properties }()
+
+	// Anything already filled in is kept, so getters aren't called again,
+	// and only needs the lazy values it contains filled in
+	switch state.kind {
+	case lazyMapKind:
+		result, err := jc.mapToGo(env, obj)
+		if err != nil {
+			return err
+		}
+		m := state.value.(map[string]any)
+		for key, elem := range result {
+			m[key] = elem
+		}
+	case lazySetKind:
+		result, err := jc.setToGo(env, obj)
+		if err != nil {
+			return err
+		}
+		// The Set may have changed size since it was wrapped
+		copy(state.value.([]any), result)
+	case lazyArrayKind:
+		arr := state.value.([]any)
+		for i := range arr {
+			if state.all {
+				if err := ld.fillDeep(env, arr[i]); err != nil {
+					return err
+				}
+				continue
+			}
+			elt, err := env.GetElement(obj, uint32(i))
+			if err != nil {
+				return err
+			}
+			if arr[i], err = jc.child(env, pathKey{index: uint32(i), isIndex: true}, elt); err != nil {
+				return err
+			}
+		}
+	default:
+		m := state.value.(map[string]any)
+		// Instances of classes are converted with the getters and
+		// methods they inherit, as they are outside of lazy mode
+		names, err := ownPropertyNames(env, obj)
+		if err != nil {
+			return err
+		}
+		inherited, err := jc.inheritedNames(env, obj, names)
+		if err != nil {
+			return err
+		}
+		names = append(names, inherited...)
+		converted := make(map[string]bool, len(names))
+		for _, name := range names {
+			converted[name] = true
+			if state.filled[name] {
+				if err := ld.fillDeep(env, m[name]); err != nil {
+					return err
+				}
+				continue
+			}
+			state.filled[name] = true
+			if err := jc.objectProperty(env, obj, name, m); err != nil {
+				return err
+			}
+		}
+		for name := range m {
+			if !converted[name] {
+				// Drop properties it no longer has
+				delete(m, name)
+				delete(state.filled, name)
+			}
+		}
+	}
+	state.tested = true
+	state.all = true
+	state.deep = true
+	return nil
+}
@@ -7,8 +7,10 @@ import (
 )
 
 type moduleData struct {
-	templateConstructor napi.Ref
-	envStack            envStack
+	templateConstructors map[*templateClass]napi.Ref
+	errorConstructors    map[*errorClass]napi.Ref
+	envStack             envStack
+	releaser             refReleaser
 }
 
 func getInstanceData(env napi.Env) (*moduleData, error) {
@@ -21,9 +23,19 @@ func getInstanceData(env napi.Env) (*moduleData, error) {
 
 func moduleTeardown(env napi.Env, data interface{}) error {
 	modData := data.(*moduleData)
-	if err := env.DeleteReference(modData.templateConstructor); err != nil {
+	if err := modData.releaser.Release(env); err != nil {
 		return err
 	}
+	for _, clsRef := range modData.templateConstructors {
+		if err := env.DeleteReference(clsRef); err != nil {
+			return err
+		}
+	}
+	for _, clsRef := range modData.errorConstructors {
+		if err := env.DeleteReference(clsRef); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -57,15 +69,23 @@ func makeEscaperBuilder(fn func(...any) string) propBuilder {
 	}, 0)
 }
 
+var templateClasses = []*templateClass{textTemplateClass, htmlTemplateClass}
+
 func moduleInit(env napi.Env, exports napi.Value) (napi.Value, error) {
 	// Build module properties values
 	propBuilders := map[string]propBuilder{
-		"Template":         buildTemplateClass,
 		"htmlEscapeString": makeEscapeStringBuilder(template.HTMLEscapeString),
 		"htmlEscaper":      makeEscaperBuilder(template.HTMLEscaper),
 		"jsEscapeString":   makeEscapeStringBuilder(template.JSEscapeString),
 		"jsEscaper":        makeEscaperBuilder(template.JSEscaper),
 		"urlQueryEscaper":  makeEscaperBuilder(template.URLQueryEscaper),
+		"trusted":          buildTrustedObject,
+	}
+	for _, cls := range templateClasses {
+		propBuilders[cls.name] = makeTemplateClassBuilder(cls)
+	}
+	for _, cls := range errorClasses {
+		propBuilders[cls.name] = makeErrorClassBuilder(cls)
 	}
 	propValues := make(map[string]napi.Value)
 	for name, builder := range propBuilders {
@@ -94,17 +114,27 @@ func moduleInit(env napi.Env, exports napi.Value) (napi.Value, error) {
 	}
 
 	// Attach an object for "global" state to this instance of the module
-	modData := moduleData{nil, newEnvStack()}
+	modData := moduleData{make(map[*templateClass]napi.Ref), make(map[*errorClass]napi.Ref), newEnvStack(), refReleaser{}}
 	if err := napi.SetInstanceData(env, &modData, moduleTeardown); err != nil {
 		return nil, err
 	}
 
-	// Create a reference to the Template class and save it in the module data
-	clsRef, err := env.CreateReference(propValues["Template"], 1)
-	if err != nil {
-		return nil, err
+	// Create references to the template and error classes and save them in the
+	// module data
+	for _, cls := range templateClasses {
+		clsRef, err := env.CreateReference(propValues[cls.name], 1)
+		if err != nil {
+			return nil, err
+		}
+		modData.templateConstructors[cls] = clsRef
+	}
+	for _, cls := range errorClasses {
+		clsRef, err := env.CreateReference(propValues[cls.name], 1)
+		if err != nil {
+			return nil, err
+		}
+		modData.errorConstructors[cls] = clsRef
 	}
-	modData.templateConstructor = clsRef
 
 	return exports, nil
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// memFS is a read-only in-memory fs.FS mapping slash-separated paths to files.
+// Directories don't need entries of their own: any path that's a prefix of a
+// file's path is one.
+type memFS map[string]*memFile
+
+// memFile is a file in a memFS, or a directory if isDir is set.
+type memFile struct {
+	data  []byte
+	isDir bool
+}
+
+// stat describes name, which must be a valid path.
+func (mfs memFS) stat(name string) (*memFileInfo, bool) {
+	if file, ok := mfs[name]; ok {
+		return &memFileInfo{path.Base(name), int64(len(file.data)), file.isDir}, true
+	}
+	if name == "." {
+		return &memFileInfo{".", 0, true}, true
+	}
+	prefix := name + "/"
+	for filePath := range mfs {
+		if strings.HasPrefix(filePath, prefix) {
+			return &memFileInfo{path.Base(name), 0, true}, true
+		}
+	}
+	return nil, false
+}
+
+func (mfs memFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	info, ok := mfs.stat(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return info, nil
+}
+
+func (mfs memFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readFile", Path: name, Err: fs.ErrInvalid}
+	}
+	file, ok := mfs[name]
+	if !ok || file.isDir {
+		return nil, &fs.PathError{Op: "readFile", Path: name, Err: fs.ErrNotExist}
+	}
+	return bytes.Clone(file.data), nil
+}
+
+func (mfs memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readDir", Path: name, Err: fs.ErrInvalid}
+	}
+	info, ok := mfs.stat(name)
+	if !ok || !info.isDir {
+		return nil, &fs.PathError{Op: "readDir", Path: name, Err: fs.ErrNotExist}
+	}
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	children := make(map[string]*memFileInfo)
+	for filePath, file := range mfs {
+		rest, ok := strings.CutPrefix(filePath, prefix)
+		if !ok || rest == "" {
+			continue
+		}
+		if child, _, nested := strings.Cut(rest, "/"); nested {
+			children[child] = &memFileInfo{child, 0, true}
+		} else if _, ok := children[child]; !ok {
+			children[child] = &memFileInfo{child, int64(len(file.data)), file.isDir}
+		}
+	}
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, child := range children {
+		entries = append(entries, fs.FileInfoToDirEntry(child))
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+func (mfs memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	info, ok := mfs.stat(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if !info.isDir {
+		return &memOpenFile{info, bytes.NewReader(mfs[name].data)}, nil
+	}
+	entries, err := mfs.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return &memOpenDir{info, entries}, nil
+}
+
+// memFileInfo describes a file or directory in a memFS.
+type memFileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (mfi *memFileInfo) Name() string {
+	return mfi.name
+}
+
+func (mfi *memFileInfo) Size() int64 {
+	return mfi.size
+}
+
+func (mfi *memFileInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (mfi *memFileInfo) IsDir() bool {
+	return mfi.isDir
+}
+
+func (mfi *memFileInfo) Sys() any {
+	return nil
+}
+
+func (mfi *memFileInfo) Mode() fs.FileMode {
+	if mfi.isDir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// memOpenFile is an open file from a memFS.
+type memOpenFile struct {
+	info *memFileInfo
+	*bytes.Reader
+}
+
+func (mof *memOpenFile) Stat() (fs.FileInfo, error) {
+	return mof.info, nil
+}
+
+func (mof *memOpenFile) Close() error {
+	return nil
+}
+
+// memOpenDir is an open directory from a memFS. Its entries are read when it's
+// opened.
+type memOpenDir struct {
+	info    *memFileInfo
+	entries []fs.DirEntry
+}
+
+func (mod *memOpenDir) Stat() (fs.FileInfo, error) {
+	return mod.info, nil
+}
+
+func (mod *memOpenDir) Close() error {
+	return nil
+}
+
+func (mod *memOpenDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: mod.info.name, Err: errors.New("is a directory")}
+}
+
+func (mod *memOpenDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := mod.entries
+		mod.entries = nil
+		return entries, nil
+	}
+	if len(mod.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(mod.entries))
+	entries := mod.entries[:n]
+	mod.entries = mod.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+// opaqueSymbol returns the symbol objects can set to be passed opaquely. It's
+// registered globally so it's shared by all copies of the module.
+func opaqueSymbol(env napi.Env) (napi.Value, error) {
+	key, err := env.CreateString("go-text-template-napi.opaque")
+	if err != nil {
+		return nil, err
+	}
+	return callGlobalMethod(env, "Symbol", "for", key)
+}
+
+// isOpaque reports whether value should be passed back to JS functions as
+// itself when returned by a template function. That's the case for objects with a
+// truthy opaque symbol property, and for objects that aren't plain data:
+// instances of classes other than Object and Array, including Maps and Sets.
+// Dates, binary data and objects with their own conversions are still
+// converted.
+func isOpaque(env napi.Env, value napi.Value, opts conversionOptions) (bool, error) {
+	valueType, err := env.Typeof(value)
+	if err != nil || valueType != napi.Object {
+		return false, err
+	}
+	if trusted, err := trustedWrapper.TryUnwrap(env, value); err != nil || trusted != nil {
+		return false, err
+	}
+	if prepared, err := preparedWrapper.TryUnwrap(env, value); err != nil || prepared != nil {
+		return false, err
+	}
+
+	opaque, err := opaqueSymbol(env)
+	if err != nil {
+		return false, err
+	}
+	marker, err := env.GetProperty(value, opaque)
+	if err != nil {
+		return false, err
+	}
+	if isMarked, err := env.CoerceToBool(marker); err != nil || isMarked {
+		return isMarked, err
+	}
+
+	for _, check := range []func(napi.Value) (bool, error){env.IsArray, env.IsDate, env.IsTypedArray, env.IsDataview} {
+		if isData, err := check(value); err != nil || isData {
+			return false, err
+		}
+	}
+	toGoValue, err := toGoValueSymbol(env)
+	if err != nil {
+		return false, err
+	}
+	if ok, err := hasMethod(env, value, toGoValue); err != nil || ok {
+		return false, err
+	}
+	if opts.toJSON {
+		toJSON, err := env.CreateString("toJSON")
+		if err != nil {
+			return false, err
+		}
+		if ok, err := hasMethod(env, value, toJSON); err != nil || ok {
+			return false, err
+		}
+	}
+
+	proto, err := env.GetPrototype(value)
+	if err != nil {
+		return false, err
+	}
+	if protoType, err := env.Typeof(proto); err != nil || protoType != napi.Object {
+		return false, err
+	}
+	global, err := env.GetGlobal()
+	if err != nil {
+		return false, err
+	}
+	objectCons, err := env.GetNamedProperty(global, "Object")
+	if err != nil {
+		return false, err
+	}
+	objectProto, err := env.GetNamedProperty(objectCons, "prototype")
+	if err != nil {
+		return false, err
+	}
+	isPlain, err := env.StrictEquals(proto, objectProto)
+	return !isPlain, err
+}
+
+func hasMethod(env napi.Env, value napi.Value, key napi.Value) (bool, error) {
+	method, err := env.GetProperty(value, key)
+	if err != nil {
+		return false, err
+	}
+	methodType, err := env.Typeof(method)
+	return methodType == napi.Function, err
+}
+
+// resultToGo converts the result of a template function to Go. If isOpaque
+// says to, it's tracked by ld, so other JS functions receive it unchanged. If
+// the template fills in lazy values, it's wrapped in a lazy value that's only
+// converted as the template reads it, and otherwise it's converted right away.
+func (opts conversionOptions) resultToGo(env napi.Env, value napi.Value, ld *lazyData) (interface{}, error) {
+	if ld == nil {
+		return opts.jsValueToGo(env, value)
+	}
+	conv := jsConverter{opts: opts, data: ld, properties: ld.properties}
+	defer func() { ld.properties = conv.properties }()
+	opaque, err := isOpaque(env, value, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !opaque {
+		return conv.toGo(env, value)
+	}
+	if !ld.fills {
+		result, err := conv.toGo(env, value)
+		if err != nil || ld.lookup(result) != nil {
+			// Instances of classes are already tracked
+			return result, err
+		}
+		var names []string
+		if m, ok := result.(map[string]any); ok {
+			names = slices.Collect(maps.Keys(m))
+		}
+		return ld.wrapFilled(env, value, result, names, nil)
+	}
+	isMap, isSet, err := conv.collectionKind(env, value)
+	switch {
+	case err != nil:
+		return nil, err
+	case isMap:
+		return ld.wrap(env, value, lazyMapKind, nil)
+	case isSet:
+		return ld.wrap(env, value, lazySetKind, nil)
+	}
+	return ld.wrap(env, value, lazyObjectKind, nil)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+// getOption returns the value of a property of an options object, and its
+// type. Undefined options objects result in an undefined value.
+func getOption(env napi.Env, options napi.Value, name string) (napi.Value, napi.ValueType, error) {
+	optionsType, err := env.Typeof(options)
+	if err != nil {
+		return nil, 0, err
+	}
+	if optionsType == napi.Undefined {
+		return options, napi.Undefined, nil
+	}
+	if optionsType != napi.Object {
+		// TODO: Custom error mechanism
+		if err := env.ThrowTypeError("ERR_INVALID_ARG_TYPE", "Options must be an object"); err != nil {
+			return nil, 0, err
+		}
+		return nil, 0, fmt.Errorf("threw exception")
+	}
+	value, err := env.GetNamedProperty(options, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	valueType, err := env.Typeof(value)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, valueType, nil
+}
+
+func throwOptionTypeError(env napi.Env, name string, expected string) error {
+	excMsg := fmt.Sprintf("Option '%s' must be %s", name, expected)
+	if err := env.ThrowTypeError("ERR_INVALID_ARG_TYPE", excMsg); err != nil {
+		return err
+	}
+	return fmt.Errorf("threw exception")
+}
+
+// getBoolOption returns the value of a boolean property of an options object.
+// Missing properties, and undefined options objects, result in false.
+func getBoolOption(env napi.Env, options napi.Value, name string) (bool, error) {
+	value, valueType, err := getOption(env, options, name)
+	if err != nil {
+		return false, err
+	}
+	switch valueType {
+	case napi.Undefined:
+		return false, nil
+	case napi.Boolean:
+		return env.GetValueBool(value)
+	}
+	return false, throwOptionTypeError(env, name, "a boolean")
+}
+
+// getIntOption returns the value of a non-negative integer property of an
+// options object. Missing properties, and undefined options objects, result in
+// defaultValue.
+func getIntOption(env napi.Env, options napi.Value, name string, defaultValue int) (int, error) {
+	value, valueType, err := getOption(env, options, name)
+	if err != nil {
+		return 0, err
+	}
+	switch valueType {
+	case napi.Undefined:
+		return defaultValue, nil
+	case napi.Number:
+		num, err := env.GetValueDouble(value)
+		if err != nil {
+			return 0, err
+		}
+		if num >= 0 && num <= maxSafeInteger && math.Trunc(num) == num {
+			return int(num), nil
+		}
+	}
+	return 0, throwOptionTypeError(env, name, "a non-negative integer")
+}
@@ -0,0 +1,688 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template/parse"
+	"unicode"
+)
+
+// treeToGo converts a parse tree to a JSON-like representation suitable for
+// passing to goValueToJs.
+// The source is that of the tree, if known, for locating its nodes.
+func treeToGo(tree *parse.Tree, source *treeSource) map[string]any {
+	return map[string]any{
+		"name":      tree.Name,
+		"parseName": tree.ParseName,
+		"root":      nodeToGo(newLineIndex(tree, source), tree.Root),
+	}
+}
+
+func nodesToGo[T parse.Node](lines *lineIndex, nodes []T) []any {
+	result := make([]any, len(nodes))
+	for i, node := range nodes {
+		result[i] = nodeToGo(lines, node)
+	}
+	return result
+}
+
+func stringsToGo(strs []string) []any {
+	result := make([]any, len(strs))
+	for i, str := range strs {
+		result[i] = str
+	}
+	return result
+}
+
+// treeSource is the source text parse trees were parsed from, which the parse
+// package doesn't expose. It's recorded when templates are parsed (see
+// templateAssn.AddSources), so they can be serialized as source, and their
+// nodes located without scanning it for each one.
+type treeSource struct {
+	// name is the name the text was parsed as, which the trees have as their
+	// ParseName unless it's been changed since
+	name   string
+	text   string
+	delims [2]string
+}
+
+// templateTrees returns the trees of the templates associated with tmpl, by
+// name, for finding the ones a parse changes.
+func templateTrees(tmpl goTemplate) map[string]*parse.Tree {
+	result := make(map[string]*parse.Tree)
+	for _, t := range tmpl.Templates() {
+		result[t.Name()] = t.Tree()
+	}
+	return result
+}
+
+// lineIndex finds the line numbers and (zero-based, in bytes) columns of nodes
+// in a tree, as reported in Go's error messages. The tree's ErrorContext method
+// scans its source from the start for every node, so if the source is known,
+// the starts of its lines are found once instead.
+type lineIndex struct {
+	tree *parse.Tree
+
+	// starts holds the offset of the start of each line, or is nil if the
+	// tree's source isn't known
+	starts []int
+}
+
+func newLineIndex(tree *parse.Tree, source *treeSource) *lineIndex {
+	if source == nil {
+		return &lineIndex{tree: tree}
+	}
+	starts := []int{0}
+	for i := range len(source.text) {
+		if source.text[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return &lineIndex{tree, starts}
+}
+
+func (li *lineIndex) location(node parse.Node) (int, int) {
+	if li.starts == nil {
+		return nodeLocation(li.tree, node)
+	}
+	pos := int(node.Position())
+	line, found := slices.BinarySearch(li.starts, pos)
+	if !found {
+		line--
+	}
+	return line + 1, pos - li.starts[line]
+}
+
+// nodeLocation returns the line number and (zero-based, in bytes) column of a
+// node, as reported in Go's error messages. Use a lineIndex to find the
+// locations of many nodes.
+func nodeLocation(tree *parse.Tree, node parse.Node) (int, int) {
+	location, _ := tree.ErrorContext(node)
+	colIdx := strings.LastIndexByte(location, ':')
+	lineIdx := strings.LastIndexByte(location[:colIdx], ':')
+	line, _ := strconv.Atoi(location[lineIdx+1 : colIdx])
+	col, _ := strconv.Atoi(location[colIdx+1:])
+	return line, col
+}
+
+func nodeToGo(lines *lineIndex, node parse.Node) any {
+	// Typed nil pointers (e.g. an absent ElseList) become null
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+	}
+
+	line, col := lines.location(node)
+	result := map[string]any{
+		"pos":    int(node.Position()),
+		"line":   line,
+		"column": col,
+	}
+	var nodeType string
+	switch n := node.(type) {
+	case *parse.ActionNode:
+		nodeType = "Action"
+		result["pipe"] = nodeToGo(lines, n.Pipe)
+	case *parse.BoolNode:
+		nodeType = "Bool"
+		result["value"] = n.True
+	case *parse.BreakNode:
+		nodeType = "Break"
+	case *parse.ChainNode:
+		nodeType = "Chain"
+		result["node"] = nodeToGo(lines, n.Node)
+		result["field"] = stringsToGo(n.Field)
+	case *parse.CommandNode:
+		nodeType = "Command"
+		result["args"] = nodesToGo(lines, n.Args)
+	case *parse.CommentNode:
+		nodeType = "Comment"
+		result["text"] = n.Text
+	case *parse.ContinueNode:
+		nodeType = "Continue"
+	case *parse.DotNode:
+		nodeType = "Dot"
+	case *parse.FieldNode:
+		nodeType = "Field"
+		result["ident"] = stringsToGo(n.Ident)
+	case *parse.IdentifierNode:
+		nodeType = "Identifier"
+		result["ident"] = n.Ident
+	case *parse.IfNode:
+		nodeType = "If"
+		branchToGo(lines, &n.BranchNode, result)
+	case *parse.ListNode:
+		nodeType = "List"
+		result["nodes"] = nodesToGo(lines, n.Nodes)
+	case *parse.NilNode:
+		nodeType = "Nil"
+	case *parse.NumberNode:
+		nodeType = "Number"
+		result["text"] = n.Text
+	case *parse.PipeNode:
+		nodeType = "Pipe"
+		result["isAssign"] = n.IsAssign
+		result["decl"] = nodesToGo(lines, n.Decl)
+		result["cmds"] = nodesToGo(lines, n.Cmds)
+	case *parse.RangeNode:
+		nodeType = "Range"
+		branchToGo(lines, &n.BranchNode, result)
+	case *parse.StringNode:
+		nodeType = "String"
+		result["quoted"] = n.Quoted
+		result["text"] = n.Text
+	case *parse.TemplateNode:
+		nodeType = "Template"
+		result["name"] = n.Name
+		result["pipe"] = nodeToGo(lines, n.Pipe)
+	case *parse.TextNode:
+		nodeType = "Text"
+		result["text"] = string(n.Text)
+	case *parse.VariableNode:
+		nodeType = "Variable"
+		result["ident"] = stringsToGo(n.Ident)
+	case *parse.WithNode:
+		nodeType = "With"
+		branchToGo(lines, &n.BranchNode, result)
+	default:
+		// Shouldn't be reachable, since this covers all nodes that can
+		// appear in a completed parse tree.
+		nodeType = fmt.Sprintf("Unknown(%d)", node.Type())
+	}
+	result["type"] = nodeType
+	return result
+}
+
+func branchToGo(lines *lineIndex, branch *parse.BranchNode, result map[string]any) {
+	result["pipe"] = nodeToGo(lines, branch.Pipe)
+	result["list"] = nodeToGo(lines, branch.List)
+	result["elseList"] = nodeToGo(lines, branch.ElseList)
+}
+
+// visitIdentifiers calls visit for each identifier (that is, each function
+// call) within node.
+func visitIdentifiers(node parse.Node, visit func(*parse.IdentifierNode)) {
+	switch n := node.(type) {
+	case *parse.ActionNode:
+		visitIdentifiers(n.Pipe, visit)
+	case *parse.ChainNode:
+		visitIdentifiers(n.Node, visit)
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			visitIdentifiers(arg, visit)
+		}
+	case *parse.IdentifierNode:
+		visit(n)
+	case *parse.IfNode:
+		visitBranchIdentifiers(&n.BranchNode, visit)
+	case *parse.ListNode:
+		if n != nil {
+			for _, child := range n.Nodes {
+				visitIdentifiers(child, visit)
+			}
+		}
+	case *parse.PipeNode:
+		if n != nil {
+			for _, cmd := range n.Cmds {
+				visitIdentifiers(cmd, visit)
+			}
+		}
+	case *parse.RangeNode:
+		visitBranchIdentifiers(&n.BranchNode, visit)
+	case *parse.TemplateNode:
+		visitIdentifiers(n.Pipe, visit)
+	case *parse.WithNode:
+		visitBranchIdentifiers(&n.BranchNode, visit)
+	}
+}
+
+func visitBranchIdentifiers(branch *parse.BranchNode, visit func(*parse.IdentifierNode)) {
+	visitIdentifiers(branch.Pipe, visit)
+	visitIdentifiers(branch.List, visit)
+	visitIdentifiers(branch.ElseList, visit)
+}
+
+// treeFromGo builds a parse tree from the representation produced by treeToGo
+// (after a round-trip through JS), returning it along with its source. The tree
+// is rendered back to template source and parsed, so the parser validates it,
+// and it has source to locate its nodes in like any other tree. Node positions
+// are recomputed in the process.
+func treeFromGo(name string, value any) (*parse.Tree, *treeSource, error) {
+	obj, ok := value.(map[string]any)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid parse tree: expected an object")
+	}
+	if treeName, ok := obj["name"].(string); ok {
+		name = treeName
+	}
+	parseName := name
+	if treeParseName, ok := obj["parseName"].(string); ok {
+		parseName = treeParseName
+	}
+
+	text, left, err := renderTree(obj["root"])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid parse tree: %w", err)
+	}
+	tree, err := parseRenderedTree(name, text, left)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid parse tree: %w", err)
+	}
+	tree.ParseName = parseName
+	return tree, &treeSource{name, text, [2]string{left, "}}"}}, nil
+}
+
+// renderTree renders the root node of a tree, in the representation produced
+// by treeToGo, as template source. It returns the source along with the left
+// delimiter it uses. The right delimiter is always "}}".
+func renderTree(root any) (string, string, error) {
+	// Text nodes are copied verbatim, so the left delimiter has to be chosen
+	// such that no text contains its first character.
+	w := &treeWriter{left: "{{", right: "}}"}
+	if err := w.writeList("root", root); err != nil {
+		return "", "", err
+	}
+	if left, ok := pickLeftDelim(w.texts); !ok {
+		return "", "", fmt.Errorf("no usable delimiter")
+	} else if left != w.left {
+		w = &treeWriter{left: left, right: "}}"}
+		if err := w.writeList("root", root); err != nil {
+			return "", "", err
+		}
+	}
+	return w.buf.String(), w.left, nil
+}
+
+// parseRenderedTree parses source produced by renderTree. Functions aren't
+// checked, since the tree may be added to a template that doesn't have them
+// yet.
+func parseRenderedTree(name string, text string, left string) (*parse.Tree, error) {
+	tree := parse.New(name)
+	tree.Mode = parse.ParseComments | parse.SkipFuncCheck
+	if _, err := tree.Parse(text, left, "}}", map[string]*parse.Tree{}); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func pickLeftDelim(texts []string) (string, bool) {
+	if !slices.ContainsFunc(texts, func(text string) bool { return strings.Contains(text, "{") }) {
+		return "{{", true
+	}
+	// Fall back to a character from the Private Use Area
+	for r := rune(0xe000); r <= 0xf8ff; r++ {
+		delim := string(r)
+		if !slices.ContainsFunc(texts, func(text string) bool { return strings.Contains(text, delim) }) {
+			return delim, true
+		}
+	}
+	return "", false
+}
+
+// treeWriter renders nodes as template source. Every value that is copied into
+// the output is validated, so a malformed node can't change the structure of
+// the parsed tree.
+type treeWriter struct {
+	buf   strings.Builder
+	left  string
+	right string
+	texts []string
+}
+
+// templateKeywords can't be used as identifiers.
+var templateKeywords = map[string]bool{
+	"block": true, "break": true, "continue": true, "define": true,
+	"else": true, "end": true, "false": true, "if": true, "nil": true,
+	"range": true, "template": true, "true": true, "with": true,
+}
+
+func isTemplateIdent(s string) bool {
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func nodeObject(path string, value any) (map[string]any, string, error) {
+	obj, ok := value.(map[string]any)
+	if !ok {
+		return nil, "", fmt.Errorf("%s: expected a node object", path)
+	}
+	nodeType, ok := obj["type"].(string)
+	if !ok {
+		return nil, "", fmt.Errorf("%s: expected a string type property", path)
+	}
+	return obj, nodeType, nil
+}
+
+func nodeString(path string, obj map[string]any, key string) (string, error) {
+	str, ok := obj[key].(string)
+	if !ok {
+		return "", fmt.Errorf("%s: expected a string %s property", path, key)
+	}
+	return str, nil
+}
+
+func nodeArray(path string, obj map[string]any, key string) ([]any, error) {
+	arr, ok := obj[key].([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected an array %s property", path, key)
+	}
+	return arr, nil
+}
+
+func nodeIdents(path string, obj map[string]any, key string) ([]string, error) {
+	arr, err := nodeArray(path, obj, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(arr) == 0 {
+		return nil, fmt.Errorf("%s: expected a non-empty %s property", path, key)
+	}
+	idents := make([]string, len(arr))
+	for i, elt := range arr {
+		ident, ok := elt.(string)
+		if !ok || !isTemplateIdent(ident) {
+			return nil, fmt.Errorf("%s.%s[%d]: expected an identifier", path, key, i)
+		}
+		idents[i] = ident
+	}
+	return idents, nil
+}
+
+func (w *treeWriter) action(body ...string) {
+	w.buf.WriteString(w.left)
+	for _, str := range body {
+		w.buf.WriteString(str)
+	}
+	w.buf.WriteString(w.right)
+}
+
+func (w *treeWriter) writeList(path string, value any) error {
+	obj, nodeType, err := nodeObject(path, value)
+	if err != nil {
+		return err
+	}
+	if nodeType != "List" {
+		return fmt.Errorf("%s: expected a List node, got %s", path, nodeType)
+	}
+	nodes, err := nodeArray(path, obj, "nodes")
+	if err != nil {
+		return err
+	}
+	for i, node := range nodes {
+		if err := w.writeListItem(fmt.Sprintf("%s.nodes[%d]", path, i), node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *treeWriter) writeListItem(path string, value any) error {
+	obj, nodeType, err := nodeObject(path, value)
+	if err != nil {
+		return err
+	}
+	switch nodeType {
+	case "Action":
+		w.buf.WriteString(w.left)
+		if err := w.writePipe(path+".pipe", obj["pipe"]); err != nil {
+			return err
+		}
+		w.buf.WriteString(w.right)
+	case "Break":
+		w.action("break")
+	case "Comment":
+		text, err := nodeString(path, obj, "text")
+		if err != nil {
+			return err
+		}
+		inner, ok := strings.CutPrefix(text, "/*")
+		if ok {
+			inner, ok = strings.CutSuffix(inner, "*/")
+		}
+		if !ok || strings.Contains(inner, "*/") {
+			return fmt.Errorf("%s: expected text to be a single /* */ comment", path)
+		}
+		w.action(text)
+	case "Continue":
+		w.action("continue")
+	case "If", "Range", "With":
+		w.buf.WriteString(w.left)
+		w.buf.WriteString(strings.ToLower(nodeType) + " ")
+		if err := w.writePipe(path+".pipe", obj["pipe"]); err != nil {
+			return err
+		}
+		w.buf.WriteString(w.right)
+		if err := w.writeList(path+".list", obj["list"]); err != nil {
+			return err
+		}
+		if obj["elseList"] != nil {
+			w.action("else")
+			if err := w.writeList(path+".elseList", obj["elseList"]); err != nil {
+				return err
+			}
+		}
+		w.action("end")
+	case "Template":
+		name, err := nodeString(path, obj, "name")
+		if err != nil {
+			return err
+		}
+		w.buf.WriteString(w.left + "template " + strconv.Quote(name))
+		if obj["pipe"] != nil {
+			w.buf.WriteString(" ")
+			if err := w.writePipe(path+".pipe", obj["pipe"]); err != nil {
+				return err
+			}
+		}
+		w.buf.WriteString(w.right)
+	case "Text":
+		text, err := nodeString(path, obj, "text")
+		if err != nil {
+			return err
+		}
+		w.texts = append(w.texts, text)
+		w.buf.WriteString(text)
+	default:
+		return fmt.Errorf("%s: unexpected %s node in list", path, nodeType)
+	}
+	return nil
+}
+
+func (w *treeWriter) writePipe(path string, value any) error {
+	obj, nodeType, err := nodeObject(path, value)
+	if err != nil {
+		return err
+	}
+	if nodeType != "Pipe" {
+		return fmt.Errorf("%s: expected a Pipe node, got %s", path, nodeType)
+	}
+	decls, err := nodeArray(path, obj, "decl")
+	if err != nil {
+		return err
+	}
+	cmds, err := nodeArray(path, obj, "cmds")
+	if err != nil {
+		return err
+	}
+	if len(cmds) == 0 {
+		return fmt.Errorf("%s: expected a non-empty cmds property", path)
+	}
+	for i, decl := range decls {
+		declPath := fmt.Sprintf("%s.decl[%d]", path, i)
+		declObj, declType, err := nodeObject(declPath, decl)
+		if err != nil {
+			return err
+		}
+		if declType != "Variable" {
+			return fmt.Errorf("%s: expected a Variable node, got %s", declPath, declType)
+		}
+		if i > 0 {
+			w.buf.WriteString(", ")
+		}
+		if err := w.writeVariable(declPath, declObj, true); err != nil {
+			return err
+		}
+	}
+	if len(decls) > 0 {
+		if isAssign, _ := obj["isAssign"].(bool); isAssign {
+			w.buf.WriteString(" = ")
+		} else {
+			w.buf.WriteString(" := ")
+		}
+	}
+	for i, cmd := range cmds {
+		cmdPath := fmt.Sprintf("%s.cmds[%d]", path, i)
+		cmdObj, cmdType, err := nodeObject(cmdPath, cmd)
+		if err != nil {
+			return err
+		}
+		if cmdType != "Command" {
+			return fmt.Errorf("%s: expected a Command node, got %s", cmdPath, cmdType)
+		}
+		args, err := nodeArray(cmdPath, cmdObj, "args")
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("%s: expected a non-empty args property", cmdPath)
+		}
+		if i > 0 {
+			w.buf.WriteString(" | ")
+		}
+		for j, arg := range args {
+			if j > 0 {
+				w.buf.WriteString(" ")
+			}
+			if err := w.writeOperand(fmt.Sprintf("%s.args[%d]", cmdPath, j), arg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *treeWriter) writeVariable(path string, obj map[string]any, isDecl bool) error {
+	arr, err := nodeArray(path, obj, "ident")
+	if err != nil {
+		return err
+	}
+	if len(arr) == 0 {
+		return fmt.Errorf("%s: expected a non-empty ident property", path)
+	}
+	if isDecl && len(arr) != 1 {
+		return fmt.Errorf("%s: expected a declared variable to have no fields", path)
+	}
+	name, ok := arr[0].(string)
+	if rest, hasDollar := strings.CutPrefix(name, "$"); !ok || !hasDollar || (rest != "" && !isTemplateIdent(rest)) {
+		return fmt.Errorf("%s.ident[0]: expected a variable name", path)
+	}
+	w.buf.WriteString(name)
+	for i, elt := range arr[1:] {
+		field, ok := elt.(string)
+		if !ok || !isTemplateIdent(field) {
+			return fmt.Errorf("%s.ident[%d]: expected an identifier", path, i+1)
+		}
+		w.buf.WriteString("." + field)
+	}
+	return nil
+}
+
+func (w *treeWriter) writeOperand(path string, value any) error {
+	obj, nodeType, err := nodeObject(path, value)
+	if err != nil {
+		return err
+	}
+	switch nodeType {
+	case "Bool":
+		val, ok := obj["value"].(bool)
+		if !ok {
+			return fmt.Errorf("%s: expected a boolean value property", path)
+		}
+		w.buf.WriteString(strconv.FormatBool(val))
+	case "Chain":
+		fields, err := nodeIdents(path, obj, "field")
+		if err != nil {
+			return err
+		}
+		if err := w.writeOperand(path+".node", obj["node"]); err != nil {
+			return err
+		}
+		w.buf.WriteString("." + strings.Join(fields, "."))
+	case "Dot":
+		w.buf.WriteString(".")
+	case "Field":
+		idents, err := nodeIdents(path, obj, "ident")
+		if err != nil {
+			return err
+		}
+		w.buf.WriteString("." + strings.Join(idents, "."))
+	case "Identifier":
+		ident, err := nodeString(path, obj, "ident")
+		if err != nil {
+			return err
+		}
+		if !isTemplateIdent(ident) || templateKeywords[ident] {
+			return fmt.Errorf("%s: invalid function name %q", path, ident)
+		}
+		w.buf.WriteString(ident)
+	case "Nil":
+		w.buf.WriteString("nil")
+	case "Number":
+		text, err := nodeString(path, obj, "text")
+		if err != nil {
+			return err
+		}
+		if !isNumberLiteral(text) {
+			return fmt.Errorf("%s: invalid number %q", path, text)
+		}
+		w.buf.WriteString(text)
+	case "Pipe":
+		w.buf.WriteString("(")
+		if err := w.writePipe(path, obj); err != nil {
+			return err
+		}
+		w.buf.WriteString(")")
+	case "String":
+		text, err := nodeString(path, obj, "text")
+		if err != nil {
+			return err
+		}
+		w.buf.WriteString(strconv.Quote(text))
+	case "Variable":
+		return w.writeVariable(path, obj, false)
+	default:
+		return fmt.Errorf("%s: unexpected %s node in command", path, nodeType)
+	}
+	return nil
+}
+
+// isNumberLiteral reports whether text parses as a single number constant,
+// using the parser itself since its syntax for numbers is quite permissive.
+func isNumberLiteral(text string) bool {
+	tree := parse.New("number")
+	tree.Mode = parse.SkipFuncCheck
+	if _, err := tree.Parse("{{"+text+"}}", "{{", "}}", map[string]*parse.Tree{}); err != nil {
+		return false
+	}
+	if len(tree.Root.Nodes) != 1 {
+		return false
+	}
+	action, ok := tree.Root.Nodes[0].(*parse.ActionNode)
+	if !ok || len(action.Pipe.Decl) != 0 || len(action.Pipe.Cmds) != 1 || len(action.Pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	number, ok := action.Pipe.Cmds[0].Args[0].(*parse.NumberNode)
+	return ok && number.Text == text
+}
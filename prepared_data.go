@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+// preparedData is wrapped by JS objects holding template data that has already
+// been converted to Go, so it can be reused without converting it again.
+type preparedData struct {
+	value any
+}
+
+var preparedWrapper = napi.NewSafeWrapper[preparedData](0x5a0c3e9d71b24f86, 0xe2d94b7f083a61c5)
+
+func preparedFinalize(env napi.Env, data interface{}) error {
+	return nil
+}
+
+// staticPrepareData converts data to Go, returning an opaque object that can be
+// passed to any template's execute methods.
+func staticPrepareData(env napi.Env, args []napi.Value) (napi.Value, error) {
+	opts, err := getConversionOptions(env, args[1])
+	if err != nil {
+		return nil, err
+	}
+	// Prepared data outlives any one execution, so it's always converted in
+	// full
+	value, err := opts.jsValueToGo(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+	obj, err := env.CreateObject()
+	if err != nil {
+		return nil, err
+	}
+	if err := preparedWrapper.Wrap(env, obj, &preparedData{value}, preparedFinalize); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
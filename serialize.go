@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template/parse"
+
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+const serializedFormat = "go-text-template-napi"
+const serializedVersion = 2
+
+// serializedTemplates is the format of the Buffers returned by serialize,
+// encoded with gob. Unlike JSON, it keeps strings that aren't valid UTF-8,
+// which templates can contain, intact.
+type serializedTemplates struct {
+	Format   string
+	Version  int
+	Class    string
+	Name     string
+	Delims   [2]string
+	Options  []string
+	FuncSets []string
+	Funcs    []string
+	Sources  []serializedSource
+	Trees    []serializedTree
+}
+
+// serializedSource holds source text that templates were parsed from, which is
+// parsed again when they're deserialized.
+type serializedSource struct {
+	Name   string
+	Text   string
+	Delims [2]string
+}
+
+// serializedTree identifies the tree of a template among those parsed from one
+// of the sources.
+type serializedTree struct {
+	Name      string
+	TreeName  string
+	ParseName string
+	Source    int
+}
+
+// builtinFuncs are the functions predefined by text/template.
+var builtinFuncs = map[string]bool{
+	"and": true, "call": true, "eq": true, "ge": true, "gt": true,
+	"html": true, "index": true, "js": true, "le": true, "len": true,
+	"lt": true, "ne": true, "not": true, "or": true, "print": true,
+	"printf": true, "println": true, "slice": true, "urlquery": true,
+}
+
+// collectFuncs adds the names of the functions called within node to funcs.
+func collectFuncs(node parse.Node, funcs map[string]bool) {
+	visitIdentifiers(node, func(ident *parse.IdentifierNode) {
+		funcs[ident.Ident] = true
+	})
+}
+
+// treesFromSerialized parses the sources of serialized templates, and returns
+// the trees of the templates along with their sources. Functions aren't checked,
+// since they were when the templates were first parsed, and haven't been added
+// yet.
+func treesFromSerialized(serialized *serializedTemplates) ([]*parse.Tree, []*treeSource, error) {
+	sources := make([]*treeSource, len(serialized.Sources))
+	treeSets := make([]map[string]*parse.Tree, len(serialized.Sources))
+	for i, ss := range serialized.Sources {
+		treeSets[i] = make(map[string]*parse.Tree)
+		tree := parse.New(ss.Name)
+		tree.Mode = parse.SkipFuncCheck
+		if _, err := tree.Parse(ss.Text, ss.Delims[0], ss.Delims[1], treeSets[i]); err != nil {
+			return nil, nil, fmt.Errorf("invalid serialized template source: %w", err)
+		}
+		sources[i] = &treeSource{ss.Name, ss.Text, ss.Delims}
+	}
+	trees := make([]*parse.Tree, len(serialized.Trees))
+	treeSources := make([]*treeSource, len(serialized.Trees))
+	for i, st := range serialized.Trees {
+		if st.Source < 0 || st.Source >= len(sources) || treeSets[st.Source][st.TreeName] == nil {
+			return nil, nil, fmt.Errorf("invalid serialized template %q", st.Name)
+		}
+		trees[i] = treeSets[st.Source][st.TreeName]
+		trees[i].ParseName = st.ParseName
+		treeSources[i] = sources[st.Source]
+	}
+	return trees, treeSources, nil
+}
+
+// nativeFuncNames returns the names of the functions from the native function
+// sets added to an association that haven't been replaced by JS functions.
+func nativeFuncNames(cls *templateClass, assn *templateAssn) map[string]bool {
+	names := make(map[string]bool)
+	for _, set := range assn.funcSets {
+		funcs, _ := cls.funcSet(set)
+		for name := range funcs {
+			if _, ok := assn.funcRefs[name]; !ok {
+				names[name] = true
+			}
+		}
+	}
+	return names
+}
+
+// serializeTemplates encodes the sources of all of the templates associated with
+// tmpl, along with the native function sets they were given, and the names of
+// the other functions they call apart from builtins.
+func serializeTemplates(tmpl goTemplate, assn *templateAssn) ([]byte, error) {
+	cls := tmpl.Class()
+	result := serializedTemplates{
+		Format:   serializedFormat,
+		Version:  serializedVersion,
+		Class:    cls.name,
+		Name:     tmpl.Name(),
+		Delims:   assn.delims,
+		Options:  assn.options,
+		FuncSets: assn.funcSets,
+	}
+	funcs := make(map[string]bool)
+	var templates []goTemplate
+	for _, t := range tmpl.Templates() {
+		tree := t.Tree()
+		if tree == nil || tree.Root == nil {
+			continue
+		}
+		source := assn.sources[t.Name()]
+		if source == nil {
+			// html/template derives templates from others when escaping
+			// them, which it will do again after they're deserialized
+			if cls == htmlTemplateClass && strings.Contains(t.Name(), "$htmltemplate_") {
+				continue
+			}
+			return nil, fmt.Errorf("can't serialize template %q: its source is unknown", t.Name())
+		}
+		templates = append(templates, t)
+		collectFuncs(tree.Root, funcs)
+	}
+	// Sort the templates, so the output is deterministic
+	slices.SortFunc(templates, func(a, b goTemplate) int { return strings.Compare(a.Name(), b.Name()) })
+	sourceIndexes := make(map[*treeSource]int)
+	for _, t := range templates {
+		source := assn.sources[t.Name()]
+		index, ok := sourceIndexes[source]
+		if !ok {
+			index = len(result.Sources)
+			sourceIndexes[source] = index
+			result.Sources = append(result.Sources, serializedSource{source.name, source.text, source.delims})
+		}
+		result.Trees = append(result.Trees, serializedTree{
+			Name:      t.Name(),
+			TreeName:  t.Tree().Name,
+			ParseName: t.Tree().ParseName,
+			Source:    index,
+		})
+	}
+	native := nativeFuncNames(cls, assn)
+	for name := range funcs {
+		// Escaping functions are added by html/template itself
+		if builtinFuncs[name] || native[name] || (cls == htmlTemplateClass && strings.HasPrefix(name, "_html_template_")) {
+			continue
+		}
+		result.Funcs = append(result.Funcs, name)
+	}
+	slices.Sort(result.Funcs)
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&result); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// deserializeTemplates decodes the output of serializeTemplates, checking that
+// it's for templates of class cls.
+func deserializeTemplates(cls *templateClass, data []byte) (*serializedTemplates, error) {
+	var result serializedTemplates
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&result)
+	if err != nil || result.Format != serializedFormat {
+		return nil, errors.New("invalid serialized templates")
+	}
+	if result.Version != serializedVersion {
+		return nil, fmt.Errorf("unsupported serialized templates version %d", result.Version)
+	}
+	if result.Class != cls.name {
+		return nil, fmt.Errorf("serialized templates are for %s, not %s", result.Class, cls.name)
+	}
+	return &result, nil
+}
+
+// missingFuncs returns the names in names that aren't functions in the funcs
+// object, which may be undefined.
+func missingFuncs(env napi.Env, funcs napi.Value, names []string) ([]string, error) {
+	funcsType, err := env.Typeof(funcs)
+	if err != nil {
+		return nil, err
+	}
+	if funcsType == napi.Undefined {
+		return names, nil
+	}
+	var missing []string
+	for _, name := range names {
+		fn, err := env.GetNamedProperty(funcs, name)
+		if err != nil {
+			return nil, err
+		}
+		fnType, err := env.Typeof(fn)
+		if err != nil {
+			return nil, err
+		}
+		if fnType != napi.Function {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+func (jst *jsTemplate) methodSerialize(env napi.Env, args []napi.Value) (napi.Value, error) {
+	data, err := serializeTemplates(jst.inner, jst.assn)
+	if err != nil {
+		return nil, err
+	}
+	return env.CreateBufferCopy(data)
+}
+
+func (cls *templateClass) staticDeserialize(env napi.Env, args []napi.Value) (napi.Value, error) {
+	data, err := jsBytesToGo(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+	serialized, err := deserializeTemplates(cls, data)
+	if err != nil {
+		return nil, err
+	}
+	funcsType, err := env.Typeof(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if funcsType != napi.Undefined && funcsType != napi.Object {
+		// TODO: Custom error mechanism
+		if err := env.ThrowTypeError("ERR_INVALID_ARG_TYPE", "Expected an object mapping names to functions"); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("threw exception")
+	}
+	missing, err := missingFuncs(env, args[1], serialized.Funcs)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		quoted := make([]string, len(missing))
+		for i, name := range missing {
+			quoted[i] = strconv.Quote(name)
+		}
+		return nil, fmt.Errorf("serialized templates require missing functions: %s", strings.Join(quoted, ", "))
+	}
+	trees, sources, err := treesFromSerialized(serialized)
+	if err != nil {
+		return nil, err
+	}
+
+	instance, err := wrapExistingTemplate(env, cls.new(serialized.Name), newTemplateAssn())
+	if err != nil {
+		return nil, err
+	}
+	jst, err := cls.wrapper.Unwrap(env, instance)
+	if err != nil {
+		return nil, err
+	}
+	// The JS functions are added last, so they replace native ones with the
+	// same names
+	for _, set := range serialized.FuncSets {
+		if err := jst.addNativeFuncs(env, set); err != nil {
+			return nil, err
+		}
+	}
+	if funcsType != napi.Undefined {
+		if _, err := jst.methodFuncs(env, args[1:2]); err != nil {
+			return nil, err
+		}
+	}
+	if serialized.Delims != [2]string{} {
+		jst.inner.Delims(serialized.Delims[0], serialized.Delims[1])
+		jst.assn.delims = serialized.Delims
+	}
+	if len(serialized.Options) > 0 {
+		// Option panics if the string is invalid, return an error instead
+		err = func() (err error) {
+			defer panicToErr(&err)
+			jst.inner.Option(serialized.Options...)
+			return
+		}()
+		if err != nil {
+			return nil, err
+		}
+		jst.assn.options = serialized.Options
+	}
+	for i, tree := range trees {
+		result, err := jst.inner.AddParseTree(serialized.Trees[i].Name, tree)
+		if err != nil {
+			return nil, err
+		}
+		jst.assn.SetSource(serialized.Trees[i].Name, sources[i])
+		// html/template replaces an existing template of the same name
+		if result.Name() == jst.inner.Name() {
+			jst.inner = result
+		}
+	}
+	return instance, nil
+}
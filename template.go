@@ -3,10 +3,14 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
 	"text/template"
+	"text/template/parse"
 	"unsafe"
 
-	"github.com/Masterminds/sprig/v3"
 	"github.com/drakedevel/go-text-template-napi/internal/napi"
 )
 
@@ -23,10 +27,101 @@ type templateAssn struct {
 	// as soon as it hits zero in a jsTemplate finalize call, while we still
 	// have a napi.Env available.
 	refCount uint
+
+	// files maps the names of templates parsed from files to the paths of
+	// those files, for error reporting. It's replaced rather than modified,
+	// since asynchronous executions may be reading it.
+	files map[string]string
+
+	// sources maps the names of the associated templates to the source
+	// their trees were parsed from, where it's known. Like files, it's
+	// replaced rather than modified.
+	sources map[string]*treeSource
+
+	// conversion controls how data and JS function results are converted
+	// to Go values when executing the associated templates.
+	conversion conversionOptions
+
+	// options and delims record the arguments of Option and Delims calls,
+	// which the template packages don't expose, so they can be serialized.
+	options []string
+	delims  [2]string
+
+	// funcSets records the names of the sets of native functions that have
+	// been added (see templateClass.funcSet), in order, so they can be
+	// serialized.
+	funcSets []string
+
+	// templates holds the wrappers of the associated templates, so the set
+	// they belong to can be replaced for all of them when a watched template
+	// is reparsed.
+	templates map[*jsTemplate]struct{}
+
+	// lazyCopy caches the copy of the associated templates that's executed
+	// instead of them in lazy mode (see lazySet). It's cleared whenever
+	// they might change.
+	lazySet goTemplate
 }
 
 func newTemplateAssn() *templateAssn {
-	return &templateAssn{make(map[string]napi.Ref), 0}
+	return &templateAssn{
+		funcRefs:   make(map[string]napi.Ref),
+		conversion: defaultConversionOptions,
+		templates:  make(map[*jsTemplate]struct{}),
+	}
+}
+
+// AddFiles records the paths of files that templates were parsed from.
+func (ta *templateAssn) AddFiles(filenames []string) {
+	files := maps.Clone(ta.files)
+	if files == nil {
+		files = make(map[string]string, len(filenames))
+	}
+	for _, filename := range filenames {
+		files[filepath.Base(filename)] = filename
+	}
+	ta.files = files
+}
+
+// AddSources records the sources of the trees of the associated templates that
+// have changed since before (see templateTrees), after tmpl or a template
+// associated with it was parsed with delims. The source function returns the
+// text parsed under a ParseName, as for newParseError.
+func (ta *templateAssn) AddSources(tmpl goTemplate, before map[string]*parse.Tree, delims [2]string, source func(string) (string, string, bool)) {
+	sources := maps.Clone(ta.sources)
+	if sources == nil {
+		sources = make(map[string]*treeSource)
+	}
+	byParseName := make(map[string]*treeSource)
+	for _, t := range tmpl.Templates() {
+		tree := t.Tree()
+		if tree == nil || before[t.Name()] == tree {
+			continue
+		}
+		src, ok := byParseName[tree.ParseName]
+		if !ok {
+			if text, _, found := source(tree.ParseName); found {
+				src = &treeSource{tree.ParseName, text, delims}
+			}
+			byParseName[tree.ParseName] = src
+		}
+		if src != nil {
+			sources[t.Name()] = src
+		} else {
+			delete(sources, t.Name())
+		}
+	}
+	ta.sources = sources
+}
+
+// SetSource records the source of the tree of the named template.
+func (ta *templateAssn) SetSource(name string, source *treeSource) {
+	sources := maps.Clone(ta.sources)
+	if sources == nil {
+		sources = make(map[string]*treeSource)
+	}
+	sources[name] = source
+	ta.sources = sources
 }
 
 func (ta *templateAssn) AddFunctionRef(name string, ref napi.Ref) napi.Ref {
@@ -38,9 +133,21 @@ func (ta *templateAssn) AddFunctionRef(name string, ref napi.Ref) napi.Ref {
 	return result
 }
 
+// Changed discards the cached copies of the templates, after they or their
+// functions might have changed.
+func (ta *templateAssn) Changed() {
+	ta.lazySet = nil
+}
+
 func (ta *templateAssn) Clone(env napi.Env) (*templateAssn, error) {
 	// TODO: Leaks references if there's an error part-way through
 	result := newTemplateAssn()
+	result.files = ta.files
+	result.sources = ta.sources
+	result.conversion = ta.conversion
+	result.options = slices.Clone(ta.options)
+	result.delims = ta.delims
+	result.funcSets = slices.Clone(ta.funcSets)
 	for name, ref := range ta.funcRefs {
 		result.AddFunctionRef(name, ref)
 		if _, err := env.ReferenceRef(ref); err != nil {
@@ -67,6 +174,7 @@ func (ta *templateAssn) Ref(jst *jsTemplate) {
 		panic("Tried to associate template with multiple associations")
 	}
 	ta.refCount++
+	ta.templates[jst] = struct{}{}
 	jst.assn = ta
 }
 
@@ -80,6 +188,26 @@ func (ta *templateAssn) RemoveFunctionRef(name string) napi.Ref {
 	return nil
 }
 
+// ReplaceSet switches each of the associated templates to the template with
+// the same name in the set tmpl belongs to. Templates it doesn't define become
+// new, empty templates in it. The sources of the old set are forgotten.
+func (ta *templateAssn) ReplaceSet(tmpl goTemplate) {
+	replacements := make(map[string]goTemplate)
+	for jst := range ta.templates {
+		name := jst.inner.Name()
+		replacement, ok := replacements[name]
+		if !ok {
+			if replacement = tmpl.Lookup(name); replacement == nil {
+				replacement = tmpl.New(name)
+			}
+			replacements[name] = replacement
+		}
+		jst.inner = replacement
+	}
+	ta.sources = nil
+	ta.Changed()
+}
+
 func (ta *templateAssn) Unref(jst *jsTemplate) {
 	if ta.refCount == 0 {
 		panic("Tried to unreference template association with 0 references")
@@ -88,15 +216,18 @@ func (ta *templateAssn) Unref(jst *jsTemplate) {
 		panic("Tried to unreference template from wrong association")
 	}
 	jst.assn = nil
+	delete(ta.templates, jst)
 	ta.refCount--
 }
 
 type jsTemplate struct {
-	inner *template.Template
+	inner goTemplate
 	assn  *templateAssn
-}
 
-var templateWrapper = napi.NewSafeWrapper[jsTemplate](0x1b339336b7154e7d, 0xa8cd781754bef7c9)
+	// watch is set for templates created by Template.watch, until they're
+	// closed.
+	watch *templateWatch
+}
 
 func callbackEntry(env napi.Env, info napi.CallbackInfo, minArgs int) (napi.Value, []napi.Value, error) {
 	// Get argument count
@@ -125,7 +256,7 @@ func callbackEntry(env napi.Env, info napi.CallbackInfo, minArgs int) (napi.Valu
 
 type templateMethodFunc func(*jsTemplate, napi.Env, []napi.Value) (napi.Value, error)
 
-func makeTemplateMethodCallback(fn templateMethodFunc, minArgs int, chain bool) (napi.Callback, unsafe.Pointer, func()) {
+func makeTemplateMethodCallback(cls *templateClass, fn templateMethodFunc, minArgs int, chain bool) (napi.Callback, unsafe.Pointer, func()) {
 	return napi.MakeNapiCallback(func(env napi.Env, info napi.CallbackInfo) (napi.Value, error) {
 		thisArg, args, err := callbackEntry(env, info, minArgs)
 		if err != nil {
@@ -133,12 +264,16 @@ func makeTemplateMethodCallback(fn templateMethodFunc, minArgs int, chain bool)
 		}
 
 		// Retrieve wrapped native object from JS object
-		this, err := templateWrapper.Unwrap(env, thisArg)
+		this, err := cls.wrapper.Unwrap(env, thisArg)
 		if err != nil {
 			return nil, fmt.Errorf("object not correctly initialized: %w", err)
 		}
 
 		result, err := fn(this, env, args)
+		if chain && this.assn != nil {
+			// Methods that can fail part-way may still have made changes
+			this.assn.Changed()
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -161,7 +296,13 @@ func makeStaticMethodCallback(fn staticMethodFunc, minArgs int) (napi.Callback,
 	})
 }
 
-func buildTemplateClass(env napi.Env, clsName string) (napi.Value, error) {
+func makeTemplateClassBuilder(cls *templateClass) propBuilder {
+	return func(env napi.Env, clsName string) (napi.Value, error) {
+		return buildTemplateClass(env, cls)
+	}
+}
+
+func buildTemplateClass(env napi.Env, cls *templateClass) (napi.Value, error) {
 	// Build property descriptors
 	type method struct {
 		fn      templateMethodFunc
@@ -173,36 +314,57 @@ func buildTemplateClass(env napi.Env, clsName string) (napi.Value, error) {
 		minArgs int
 	}
 	methods := map[string]method{
-		// AddParseTree and ParseFS are unsupported
 		// Execute and ExecuteTemplates are supported with string returns
-		"clone":                 {(*jsTemplate).methodClone, 0, false},
-		"definedTemplates":      {(*jsTemplate).methodDefinedTemplates, 0, false},
-		"delims":                {(*jsTemplate).methodDelims, 2, true},
-		"executeString":         {(*jsTemplate).methodExecuteString, 1, false},
-		"executeTemplateString": {(*jsTemplate).methodExecuteTemplateString, 2, false},
-		"funcs":                 {(*jsTemplate).methodFuncs, 1, true},
-		"lookup":                {(*jsTemplate).methodLookup, 1, false},
-		"name":                  {(*jsTemplate).methodName, 0, false},
-		"new":                   {(*jsTemplate).methodNew, 1, false},
-		"option":                {(*jsTemplate).methodOption, 0, true},
-		"parse":                 {(*jsTemplate).methodParse, 1, true},
-		"parseFiles":            {(*jsTemplate).methodParseFiles, 0, true},
-		"parseGlob":             {(*jsTemplate).methodParseGlob, 1, true},
-		"templates":             {(*jsTemplate).methodTemplates, 0, false},
+		"addParseTree":            {(*jsTemplate).methodAddParseTree, 2, false},
+		"clone":                   {(*jsTemplate).methodClone, 0, false},
+		"definedTemplates":        {(*jsTemplate).methodDefinedTemplates, 0, false},
+		"delims":                  {(*jsTemplate).methodDelims, 2, true},
+		"executeAsync":            {(*jsTemplate).methodExecuteAsync, 1, false},
+		"executeJSON":             {(*jsTemplate).methodExecuteJSON, 1, false},
+		"executeString":           {(*jsTemplate).methodExecuteString, 1, false},
+		"executeTemplateAsync":    {(*jsTemplate).methodExecuteTemplateAsync, 2, false},
+		"executeTemplateJSON":     {(*jsTemplate).methodExecuteTemplateJSON, 2, false},
+		"executeTemplateString":   {(*jsTemplate).methodExecuteTemplateString, 2, false},
+		"executeTemplateToStream": {(*jsTemplate).methodExecuteTemplateToStream, 3, false},
+		"executeToStream":         {(*jsTemplate).methodExecuteToStream, 2, false},
+		"funcs":                   {(*jsTemplate).methodFuncs, 1, true},
+		"lookup":                  {(*jsTemplate).methodLookup, 1, false},
+		"name":                    {(*jsTemplate).methodName, 0, false},
+		"new":                     {(*jsTemplate).methodNew, 1, false},
+		"option":                  {(*jsTemplate).methodOption, 0, true},
+		"parse":                   {(*jsTemplate).methodParse, 1, true},
+		"parseFiles":              {(*jsTemplate).methodParseFiles, 0, true},
+		"parseFS":                 {(*jsTemplate).methodParseFS, 1, true},
+		"parseGlob":               {(*jsTemplate).methodParseGlob, 1, true},
+		"templates":               {(*jsTemplate).methodTemplates, 0, false},
 
 		// These functions are not part of the text/template API
 		"addSprigFuncs":         {(*jsTemplate).methodAddSprigFuncs, 0, true},
 		"addSprigHermeticFuncs": {(*jsTemplate).methodAddSprigHermeticFuncs, 0, true},
+		"close":                 {(*jsTemplate).methodClose, 0, false},
+		"conversionOptions":     {(*jsTemplate).methodConversionOptions, 1, true},
+		"inferDataShape":        {(*jsTemplate).methodInferDataShape, 1, false},
+		"on":                    {(*jsTemplate).methodOn, 2, true},
+		"parseArchive":          {(*jsTemplate).methodParseArchive, 1, true},
+		"serialize":             {(*jsTemplate).methodSerialize, 0, false},
+		"templateTree":          {(*jsTemplate).methodTemplateTree, 1, false},
+		"tree":                  {(*jsTemplate).methodTree, 0, false},
 	}
 	staticMethods := map[string]staticMethod{
-		// ParseFS is unsupported
-		"parseFiles": {staticTemplateParseFiles, 0},
-		"parseGlob":  {staticTemplateParseGlob, 1},
+		"parseFiles": {cls.staticParseFiles, 0},
+		"parseFS":    {cls.staticParseFS, 1},
+		"parseGlob":  {cls.staticParseGlob, 1},
+
+		// These functions are not part of the text/template API
+		"parseArchive": {cls.staticParseArchive, 1},
+		"deserialize":  {cls.staticDeserialize, 2},
+		"prepareData":  {staticPrepareData, 2},
+		"watch":        {cls.staticWatch, 2},
 	}
 	var propDescs []napi.PropertyDescriptor
 	for name, spec := range methods {
 		// TODO: Don't leak cbData
-		cb, cbData, _ := makeTemplateMethodCallback(spec.fn, spec.minArgs, spec.chain)
+		cb, cbData, _ := makeTemplateMethodCallback(cls, spec.fn, spec.minArgs, spec.chain)
 		nameObj, err := env.CreateString(name)
 		if err != nil {
 			return nil, err
@@ -229,15 +391,36 @@ func buildTemplateClass(env napi.Env, clsName string) (napi.Value, error) {
 		})
 	}
 
+	// Expose the symbols objects can use to control their conversion
+	symbols := map[string]func(napi.Env) (napi.Value, error){
+		"opaque":    opaqueSymbol,
+		"toGoValue": toGoValueSymbol,
+	}
+	for name, getSymbol := range symbols {
+		nameObj, err := env.CreateString(name)
+		if err != nil {
+			return nil, err
+		}
+		symbol, err := getSymbol(env)
+		if err != nil {
+			return nil, err
+		}
+		propDescs = append(propDescs, napi.PropertyDescriptor{
+			Name:       nameObj,
+			Value:      symbol,
+			Attributes: napi.Static,
+		})
+	}
+
 	// Define class
 	// TODO: Don't leak consData
-	consCb, consData, _ := napi.MakeNapiCallback(templateConstructor)
-	return env.DefineClass(clsName, consCb, consData, propDescs)
+	consCb, consData, _ := napi.MakeNapiCallback(cls.constructor)
+	return env.DefineClass(cls.name, consCb, consData, propDescs)
 }
 
-func wrapTemplateObject(env napi.Env, object napi.Value, tmpl *template.Template, assn *templateAssn) error {
-	jst := &jsTemplate{tmpl, nil}
-	if err := templateWrapper.Wrap(env, object, jst, templateFinalize); err != nil {
+func wrapTemplateObject(env napi.Env, object napi.Value, tmpl goTemplate, assn *templateAssn) error {
+	jst := &jsTemplate{inner: tmpl}
+	if err := tmpl.Class().wrapper.Wrap(env, object, jst, templateFinalize); err != nil {
 		return err
 	}
 	// Wait until after wrapping succeeds to reference the association to
@@ -246,7 +429,7 @@ func wrapTemplateObject(env napi.Env, object napi.Value, tmpl *template.Template
 	return nil
 }
 
-func templateConstructor(env napi.Env, info napi.CallbackInfo) (napi.Value, error) {
+func (cls *templateClass) constructor(env napi.Env, info napi.CallbackInfo) (napi.Value, error) {
 	// TODO: Add check for new.target
 	thisArg, argv, err := callbackEntry(env, info, 0)
 	if err != nil {
@@ -266,7 +449,7 @@ func templateConstructor(env napi.Env, info napi.CallbackInfo) (napi.Value, erro
 	}
 
 	// Create native object and attach to JS object
-	if err := wrapTemplateObject(env, thisArg, template.New(name), newTemplateAssn()); err != nil {
+	if err := wrapTemplateObject(env, thisArg, cls.new(name), newTemplateAssn()); err != nil {
 		return nil, err
 	}
 	return nil, nil
@@ -282,12 +465,12 @@ func templateFinalize(env napi.Env, data interface{}) error {
 	return assn.MaybeFinalize(env)
 }
 
-func wrapExistingTemplate(env napi.Env, tmpl *template.Template, assn *templateAssn) (napi.Value, error) {
+func wrapExistingTemplate(env napi.Env, tmpl goTemplate, assn *templateAssn) (napi.Value, error) {
 	instData, err := getInstanceData(env)
 	if err != nil {
 		return nil, err
 	}
-	constructor, err := env.GetReferenceValue(instData.templateConstructor)
+	constructor, err := env.GetReferenceValue(instData.templateConstructors[tmpl.Class()])
 	if err != nil {
 		return nil, err
 	}
@@ -301,6 +484,33 @@ func wrapExistingTemplate(env napi.Env, tmpl *template.Template, assn *templateA
 	return instance, nil
 }
 
+func (jst *jsTemplate) methodAddParseTree(env napi.Env, args []napi.Value) (napi.Value, error) {
+	name, err := jsStringToGo(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+	treeValue, err := jsValueToGo(env, args[1])
+	if err != nil {
+		return nil, err
+	}
+	tree, source, err := treeFromGo(name, treeValue)
+	if err != nil {
+		return nil, err
+	}
+	result, err := jst.inner.AddParseTree(name, tree)
+	if err != nil {
+		return nil, err
+	}
+	jst.assn.SetSource(name, source)
+	jst.assn.Changed()
+	// html/template replaces an existing template of the same name instead of
+	// updating it, so keep this object pointing at the live one.
+	if result.Name() == jst.inner.Name() {
+		jst.inner = result
+	}
+	return wrapExistingTemplate(env, result, jst.assn)
+}
+
 func (jst *jsTemplate) methodClone(env napi.Env, args []napi.Value) (napi.Value, error) {
 	clonedTmpl, err := jst.inner.Clone()
 	if err != nil {
@@ -327,77 +537,174 @@ func (jst *jsTemplate) methodDelims(env napi.Env, args []napi.Value) (napi.Value
 		return nil, err
 	}
 	jst.inner.Delims(left, right)
+	jst.assn.delims = [2]string{left, right}
+	jst.recordSetup(func(tmpl goTemplate) { tmpl.Delims(left, right) })
 	return nil, nil
 }
 
+func (jst *jsTemplate) methodExecuteAsync(env napi.Env, args []napi.Value) (napi.Value, error) {
+	data, ld, err := jst.assn.conversion.dataToGo(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return jst.executeAsync(env, ld, func(tmpl goTemplate, wr io.Writer) error {
+		return tmpl.Execute(wr, data)
+	})
+}
+
+func (jst *jsTemplate) methodExecuteJSON(env napi.Env, args []napi.Value) (napi.Value, error) {
+	data, err := jsJSONToGo(env, args[0], jst.assn.conversion)
+	if err != nil {
+		return nil, err
+	}
+	return jst.executeSync(env, nil, func(tmpl goTemplate, wr io.Writer) error {
+		return tmpl.Execute(wr, data)
+	})
+}
+
 func (jst *jsTemplate) methodExecuteString(env napi.Env, args []napi.Value) (napi.Value, error) {
-	// TODO: Allow passing in a stream?
-	data, err := jsValueToGo(env, args[0])
+	data, ld, err := jst.assn.conversion.dataToGo(env, args[0])
 	if err != nil {
 		return nil, err
 	}
-	modData, err := getInstanceData(env)
+	return jst.executeSync(env, ld, func(tmpl goTemplate, wr io.Writer) error {
+		return tmpl.Execute(wr, data)
+	})
+}
+
+func (jst *jsTemplate) methodExecuteTemplateToStream(env napi.Env, args []napi.Value) (napi.Value, error) {
+	name, err := jsStringToGo(env, args[1])
 	if err != nil {
 		return nil, err
 	}
-	modData.envStack.Enter(env)
-	defer modData.envStack.Exit(env)
-	var buf bytes.Buffer
-	if err := jst.inner.Execute(&buf, data); err != nil {
-		// TODO: Map to better JS error?
+	data, ld, err := jst.assn.conversion.dataToGo(env, args[2])
+	if err != nil {
 		return nil, err
 	}
-	return env.CreateString(buf.String())
+	return jst.executeToStream(env, ld, args[0], func(tmpl goTemplate, wr io.Writer) error {
+		return tmpl.ExecuteTemplate(wr, name, data)
+	})
+}
+
+func (jst *jsTemplate) methodExecuteToStream(env napi.Env, args []napi.Value) (napi.Value, error) {
+	data, ld, err := jst.assn.conversion.dataToGo(env, args[1])
+	if err != nil {
+		return nil, err
+	}
+	return jst.executeToStream(env, ld, args[0], func(tmpl goTemplate, wr io.Writer) error {
+		return tmpl.Execute(wr, data)
+	})
+}
+
+func (jst *jsTemplate) methodExecuteTemplateAsync(env napi.Env, args []napi.Value) (napi.Value, error) {
+	name, err := jsStringToGo(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+	data, ld, err := jst.assn.conversion.dataToGo(env, args[1])
+	if err != nil {
+		return nil, err
+	}
+	return jst.executeAsync(env, ld, func(tmpl goTemplate, wr io.Writer) error {
+		return tmpl.ExecuteTemplate(wr, name, data)
+	})
+}
+
+func (jst *jsTemplate) methodExecuteTemplateJSON(env napi.Env, args []napi.Value) (napi.Value, error) {
+	name, err := jsStringToGo(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+	data, err := jsJSONToGo(env, args[1], jst.assn.conversion)
+	if err != nil {
+		return nil, err
+	}
+	return jst.executeSync(env, nil, func(tmpl goTemplate, wr io.Writer) error {
+		return tmpl.ExecuteTemplate(wr, name, data)
+	})
 }
 
 func (jst *jsTemplate) methodExecuteTemplateString(env napi.Env, args []napi.Value) (napi.Value, error) {
-	// TODO: Allow passing in a stream?
 	name, err := jsStringToGo(env, args[0])
 	if err != nil {
 		return nil, err
 	}
-	data, err := jsValueToGo(env, args[1])
+	data, ld, err := jst.assn.conversion.dataToGo(env, args[1])
 	if err != nil {
 		return nil, err
 	}
+	return jst.executeSync(env, ld, func(tmpl goTemplate, wr io.Writer) error {
+		return tmpl.ExecuteTemplate(wr, name, data)
+	})
+}
+
+// executeSync runs exec on the JS thread and returns its output. The lazy
+// values of its data, tracked by ld, are released once it finishes.
+func (jst *jsTemplate) executeSync(env napi.Env, ld *lazyData, exec executeFunc) (napi.Value, error) {
+	// Swallow errors here since we can't do anything about them
+	defer func() { _ = ld.Release(env) }()
 	modData, err := getInstanceData(env)
 	if err != nil {
 		return nil, err
 	}
-	modData.envStack.Enter(env)
+	tmpl, ld, err := jst.execTemplate(env, &modData.envStack, ld, false)
+	if err != nil {
+		return nil, err
+	}
+	modData.envStack.Enter(env, jst.assn.conversion, ld)
 	defer modData.envStack.Exit(env)
 	var buf bytes.Buffer
-	if err := jst.inner.ExecuteTemplate(&buf, name, data); err != nil {
-		// TODO: Map to better JS error?
-		return nil, err
+	if err := exec(tmpl, &buf); err != nil {
+		return nil, newExecError(tmpl, err, jst.assn.files, jst.assn.sources)
 	}
 	return env.CreateString(buf.String())
 }
 
-func makeJsCallback(envStack *envStack, jsFnRef napi.Ref) interface{} {
+// jsCaller provides a way for template functions to run code on the JS thread.
+type jsCaller interface {
+	CallOnJsThread(fn func(napi.Env) error) error
+}
+
+func makeJsCallback(es *envStack, jsFnRef napi.Ref, thisRef napi.Ref) func(...interface{}) (interface{}, error) {
 	return func(args ...interface{}) (interface{}, error) {
-		env := envStack.Current()
-		jsFn, err := env.GetReferenceValue(jsFnRef)
-		if err != nil {
-			return nil, err
-		}
-		undefVal, err := env.GetUndefined()
-		if err != nil {
-			return nil, err
-		}
-		jsArgs := make([]napi.Value, len(args))
-		for i, arg := range args {
-			jsArg, err := goValueToJs(env, arg)
+		var result interface{}
+		conversion := es.Conversion()
+		ld := es.LazyData()
+		err := es.CallOnJsThread(func(env napi.Env) error {
+			jsFn, err := env.GetReferenceValue(jsFnRef)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			jsArgs[i] = jsArg
-		}
-		result, err := env.CallFunction(undefVal, jsFn, jsArgs)
-		if err != nil {
-			return nil, err
-		}
-		return jsValueToGo(env, result)
+			var thisVal napi.Value
+			if thisRef != nil {
+				thisVal, err = env.GetReferenceValue(thisRef)
+			} else {
+				thisVal, err = env.GetUndefined()
+			}
+			if err != nil {
+				return err
+			}
+			jsArgs := make([]napi.Value, len(args))
+			for i, arg := range args {
+				jsArg, err := goDataToJs(env, arg, ld)
+				if err != nil {
+					return err
+				}
+				jsArgs[i] = jsArg
+			}
+			jsResult, err := env.CallFunction(thisVal, jsFn, jsArgs)
+			if err == nil {
+				result, err = conversion.resultToGo(env, jsResult, ld)
+			}
+			if err != nil {
+				// Capture the exception rather than leaving it pending, so it
+				// can be rethrown once execution stops. That includes
+				// exceptions from getters of the result.
+				return captureJsException(env, err)
+			}
+			return nil
+		})
+		return result, err
 	}
 }
 
@@ -459,7 +766,7 @@ func (jst *jsTemplate) methodFuncs(env napi.Env, args []napi.Value) (napi.Value,
 			return nil, err
 		}
 		refMap[propName] = propRef
-		funcMap[propName] = makeJsCallback(&modData.envStack, propRef)
+		funcMap[propName] = makeJsCallback(&modData.envStack, propRef, nil)
 	}
 
 	// Funcs panics if the caller passes in an invalid name, so catch that
@@ -481,10 +788,28 @@ func (jst *jsTemplate) methodFuncs(env napi.Env, args []napi.Value) (napi.Value,
 			_, _ = env.ReferenceUnref(oldRef)
 		}
 	}
+	jst.recordSetup(func(tmpl goTemplate) { tmpl.Funcs(funcMap) })
 
 	return nil, nil
 }
 
+func (jst *jsTemplate) methodInferDataShape(env napi.Env, args []napi.Value) (napi.Value, error) {
+	jsonSchema, err := getBoolOption(env, args[0], "jsonSchema")
+	if err != nil {
+		return nil, err
+	}
+	if jst.inner.Tree() == nil {
+		return nil, nil
+	}
+	shape := inferDataShape(jst.inner)
+	if jsonSchema {
+		schema := shape.toJSONSchema()
+		schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+		return goValueToJs(env, schema)
+	}
+	return goValueToJs(env, shape.toGo())
+}
+
 func (jst *jsTemplate) methodLookup(env napi.Env, args []napi.Value) (napi.Value, error) {
 	name, err := jsStringToGo(env, args[0])
 	if err != nil {
@@ -506,6 +831,7 @@ func (jst *jsTemplate) methodNew(env napi.Env, args []napi.Value) (napi.Value, e
 	if err != nil {
 		return nil, err
 	}
+	jst.assn.Changed()
 	return wrapExistingTemplate(env, jst.inner.New(name), jst.assn)
 }
 
@@ -520,7 +846,12 @@ func (jst *jsTemplate) methodOption(env napi.Env, args []napi.Value) (napi.Value
 		jst.inner.Option(options...)
 		return
 	}()
-	return nil, err
+	if err != nil {
+		return nil, err
+	}
+	jst.assn.options = append(jst.assn.options, options...)
+	jst.recordSetup(func(tmpl goTemplate) { tmpl.Option(options...) })
+	return nil, nil
 }
 
 func (jst *jsTemplate) methodParse(env napi.Env, args []napi.Value) (napi.Value, error) {
@@ -529,10 +860,14 @@ func (jst *jsTemplate) methodParse(env napi.Env, args []napi.Value) (napi.Value,
 		return nil, err
 	}
 
-	if _, err := jst.inner.Parse(text); err != nil {
-		// TODO: Map to better JS error?
-		return nil, err
+	source := func(parseName string) (string, string, bool) {
+		return text, "", parseName == jst.inner.Name()
 	}
+	before := templateTrees(jst.inner)
+	if err := jst.inner.Parse(text); err != nil {
+		return nil, newParseError(err, jst.assn.delims, source)
+	}
+	jst.assn.AddSources(jst.inner, before, jst.assn.delims, source)
 	return nil, nil
 }
 
@@ -541,23 +876,72 @@ func (jst *jsTemplate) methodParseFiles(env napi.Env, args []napi.Value) (napi.V
 	if err != nil {
 		return nil, err
 	}
-	if _, err := jst.inner.ParseFiles(files...); err != nil {
-		// TODO: Map to better JS error?
+	jst.assn.AddFiles(files)
+	before := templateTrees(jst.inner)
+	if err := jst.inner.ParseFiles(files...); err != nil {
+		return nil, newParseError(err, jst.assn.delims, parseFilesSource(files))
+	}
+	jst.assn.AddSources(jst.inner, before, jst.assn.delims, parseFilesSource(files))
+	return nil, jst.recordSource(watchSource{files: files})
+}
+
+func (jst *jsTemplate) methodParseArchive(env napi.Env, args []napi.Value) (napi.Value, error) {
+	_, err := parseArchive(env, jst.inner, jst.inner.Class(), jst.assn, args)
+	return nil, err
+}
+
+func (jst *jsTemplate) methodParseFS(env napi.Env, args []napi.Value) (napi.Value, error) {
+	fsys, err := jsSourceToFS(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+	patterns, err := jsValuesToGo(env, args[1:], jsStringToGo)
+	if err != nil {
 		return nil, err
 	}
+	before := templateTrees(jst.inner)
+	if err := jst.inner.ParseFS(fsys, patterns...); err != nil {
+		return nil, newParseError(err, jst.assn.delims, parseFSSource(fsys, patterns))
+	}
+	jst.assn.AddSources(jst.inner, before, jst.assn.delims, parseFSSource(fsys, patterns))
 	return nil, nil
 }
 
 func (jst *jsTemplate) methodParseGlob(env napi.Env, args []napi.Value) (napi.Value, error) {
-	text, err := jsStringToGo(env, args[0])
+	glob, err := jsStringToGo(env, args[0])
 	if err != nil {
 		return nil, err
 	}
-	if _, err := jst.inner.ParseGlob(text); err != nil {
-		// TODO: Map to better JS error?
+	before := templateTrees(jst.inner)
+	err = jst.inner.ParseGlob(glob)
+	// This matches the files ParseGlob uses, unless they change in between
+	files, _ := filepath.Glob(glob)
+	jst.assn.AddFiles(files)
+	if err != nil {
+		return nil, newParseError(err, jst.assn.delims, parseFilesSource(files))
+	}
+	jst.assn.AddSources(jst.inner, before, jst.assn.delims, parseFilesSource(files))
+	return nil, jst.recordSource(watchSource{glob: glob})
+}
+
+func (jst *jsTemplate) methodTemplateTree(env napi.Env, args []napi.Value) (napi.Value, error) {
+	name, err := jsStringToGo(env, args[0])
+	if err != nil {
 		return nil, err
 	}
-	return nil, nil
+	tmpl := jst.inner.Lookup(name)
+	if tmpl == nil || tmpl.Tree() == nil {
+		return nil, nil
+	}
+	return goValueToJs(env, treeToGo(tmpl.Tree(), jst.assn.sources[name]))
+}
+
+func (jst *jsTemplate) methodTree(env napi.Env, args []napi.Value) (napi.Value, error) {
+	tree := jst.inner.Tree()
+	if tree == nil {
+		return nil, nil
+	}
+	return goValueToJs(env, treeToGo(tree, jst.assn.sources[jst.inner.Name()]))
 }
 
 func (jst *jsTemplate) methodTemplates(env napi.Env, args []napi.Value) (napi.Value, error) {
@@ -578,9 +962,15 @@ func (jst *jsTemplate) methodTemplates(env napi.Env, args []napi.Value) (napi.Va
 	return result, nil
 }
 
-func (jst *jsTemplate) addNativeFuncs(env napi.Env, funcs template.FuncMap) error {
+func (jst *jsTemplate) addNativeFuncs(env napi.Env, set string) error {
 	// Add the native functions
+	funcs, ok := jst.inner.Class().funcSet(set)
+	if !ok {
+		return fmt.Errorf("unknown function set %q", set)
+	}
 	jst.inner.Funcs(funcs)
+	jst.assn.funcSets = append(jst.assn.funcSets, set)
+	jst.recordSetup(func(tmpl goTemplate) { tmpl.Funcs(funcs) })
 
 	// Unreference any JS functions these replaced
 	for name := range funcs {
@@ -594,35 +984,78 @@ func (jst *jsTemplate) addNativeFuncs(env napi.Env, funcs template.FuncMap) erro
 }
 
 func (jst *jsTemplate) methodAddSprigFuncs(env napi.Env, args []napi.Value) (napi.Value, error) {
-	err := jst.addNativeFuncs(env, sprig.TxtFuncMap())
+	err := jst.addNativeFuncs(env, "sprig")
 	return nil, err
 }
 
 func (jst *jsTemplate) methodAddSprigHermeticFuncs(env napi.Env, args []napi.Value) (napi.Value, error) {
-	err := jst.addNativeFuncs(env, sprig.HermeticTxtFuncMap())
+	err := jst.addNativeFuncs(env, "sprigHermetic")
 	return nil, err
 }
 
-func staticTemplateParseFiles(env napi.Env, args []napi.Value) (napi.Value, error) {
+func (jst *jsTemplate) methodConversionOptions(env napi.Env, args []napi.Value) (napi.Value, error) {
+	conversion, err := getConversionOptions(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+	jst.assn.conversion = conversion
+	return nil, nil
+}
+
+func (cls *templateClass) staticParseFiles(env napi.Env, args []napi.Value) (napi.Value, error) {
 	files, err := jsValuesToGo(env, args, jsStringToGo)
 	if err != nil {
 		return nil, err
 	}
-	result, err := template.ParseFiles(files...)
+	result, err := cls.parseFiles(files...)
+	if err != nil {
+		return nil, newParseError(err, [2]string{}, parseFilesSource(files))
+	}
+	assn := newTemplateAssn()
+	assn.AddFiles(files)
+	assn.AddSources(result, nil, [2]string{}, parseFilesSource(files))
+	return wrapExistingTemplate(env, result, assn)
+}
+
+func (cls *templateClass) staticParseArchive(env napi.Env, args []napi.Value) (napi.Value, error) {
+	assn := newTemplateAssn()
+	result, err := parseArchive(env, nil, cls, assn, args)
 	if err != nil {
 		return nil, err
 	}
-	return wrapExistingTemplate(env, result, newTemplateAssn())
+	return wrapExistingTemplate(env, result, assn)
 }
 
-func staticTemplateParseGlob(env napi.Env, args []napi.Value) (napi.Value, error) {
-	glob, err := jsStringToGo(env, args[0])
+func (cls *templateClass) staticParseFS(env napi.Env, args []napi.Value) (napi.Value, error) {
+	fsys, err := jsSourceToFS(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+	patterns, err := jsValuesToGo(env, args[1:], jsStringToGo)
 	if err != nil {
 		return nil, err
 	}
-	result, err := template.ParseGlob(glob)
+	result, err := cls.parseFS(fsys, patterns...)
+	if err != nil {
+		return nil, newParseError(err, [2]string{}, parseFSSource(fsys, patterns))
+	}
+	assn := newTemplateAssn()
+	assn.AddSources(result, nil, [2]string{}, parseFSSource(fsys, patterns))
+	return wrapExistingTemplate(env, result, assn)
+}
+
+func (cls *templateClass) staticParseGlob(env napi.Env, args []napi.Value) (napi.Value, error) {
+	glob, err := jsStringToGo(env, args[0])
 	if err != nil {
 		return nil, err
 	}
-	return wrapExistingTemplate(env, result, newTemplateAssn())
+	result, err := cls.parseGlob(glob)
+	files, _ := filepath.Glob(glob)
+	if err != nil {
+		return nil, newParseError(err, [2]string{}, parseFilesSource(files))
+	}
+	assn := newTemplateAssn()
+	assn.AddFiles(files)
+	assn.AddSources(result, nil, [2]string{}, parseFilesSource(files))
+	return wrapExistingTemplate(env, result, assn)
 }
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"errors"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+// errorClass describes a JS subclass of Error thrown for errors from Go.
+type errorClass struct {
+	name string
+	code string
+}
+
+var templateParseErrorClass = &errorClass{"TemplateParseError", "ERR_TEMPLATE_PARSE"}
+var templateExecErrorClass = &errorClass{"TemplateExecError", "ERR_TEMPLATE_EXEC"}
+
+var errorClasses = []*errorClass{templateParseErrorClass, templateExecErrorClass}
+
+func makeErrorClassBuilder(cls *errorClass) propBuilder {
+	return func(env napi.Env, clsName string) (napi.Value, error) {
+		return buildErrorClass(env, cls)
+	}
+}
+
+// callGlobalMethod calls a method of one of the JS global objects, such as
+// Object.setPrototypeOf.
+func callGlobalMethod(env napi.Env, objName string, methodName string, args ...napi.Value) (napi.Value, error) {
+	global, err := env.GetGlobal()
+	if err != nil {
+		return nil, err
+	}
+	obj, err := env.GetNamedProperty(global, objName)
+	if err != nil {
+		return nil, err
+	}
+	method, err := env.GetNamedProperty(obj, methodName)
+	if err != nil {
+		return nil, err
+	}
+	return env.CallFunction(obj, method, args)
+}
+
+func buildErrorClass(env napi.Env, cls *errorClass) (napi.Value, error) {
+	nameValue, err := env.CreateString("name")
+	if err != nil {
+		return nil, err
+	}
+	clsNameValue, err := env.CreateString(cls.name)
+	if err != nil {
+		return nil, err
+	}
+	propDescs := []napi.PropertyDescriptor{{
+		Name:       nameValue,
+		Value:      clsNameValue,
+		Attributes: napi.Writable | napi.Configurable,
+	}}
+	// TODO: Don't leak consData
+	consCb, consData, _ := napi.MakeNapiCallback(cls.constructor)
+	classValue, err := env.DefineClass(cls.name, consCb, consData, propDescs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Node-API can't define subclasses, so fix up the prototype chains after
+	// the fact to make the class extend Error.
+	global, err := env.GetGlobal()
+	if err != nil {
+		return nil, err
+	}
+	errorCons, err := env.GetNamedProperty(global, "Error")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := callGlobalMethod(env, "Object", "setPrototypeOf", classValue, errorCons); err != nil {
+		return nil, err
+	}
+	proto, err := env.GetNamedProperty(classValue, "prototype")
+	if err != nil {
+		return nil, err
+	}
+	errorProto, err := env.GetNamedProperty(errorCons, "prototype")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := callGlobalMethod(env, "Object", "setPrototypeOf", proto, errorProto); err != nil {
+		return nil, err
+	}
+	return classValue, nil
+}
+
+func (cls *errorClass) constructor(env napi.Env, info napi.CallbackInfo) (napi.Value, error) {
+	thisArg, argv, err := callbackEntry(env, info, 1)
+	if err != nil {
+		return nil, err
+	}
+	props := map[string]napi.Value{"message": argv[0]}
+	if props["code"], err = env.CreateString(cls.code); err != nil {
+		return nil, err
+	}
+	for name, value := range props {
+		valueType, err := env.Typeof(value)
+		if err != nil {
+			return nil, err
+		}
+		if valueType == napi.Undefined {
+			continue
+		}
+		nameValue, err := env.CreateString(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := env.SetProperty(thisArg, nameValue, value); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := callGlobalMethod(env, "Error", "captureStackTrace", thisArg); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// templateError is thrown to JS as an instance of one of the error classes,
+// with additional properties describing where the error occurred.
+type templateError struct {
+	class *errorClass
+	err   error
+	props map[string]any
+}
+
+func (te *templateError) Error() string {
+	return te.err.Error()
+}
+
+func (te *templateError) Unwrap() error {
+	return te.err
+}
+
+func (te *templateError) ToJs(env napi.Env) (napi.Value, error) {
+	instData, err := getInstanceData(env)
+	if err != nil {
+		return nil, err
+	}
+	cons, err := env.GetReferenceValue(instData.errorConstructors[te.class])
+	if err != nil {
+		return nil, err
+	}
+	proto, err := env.GetNamedProperty(cons, "prototype")
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a real Error and then change its class, so it has all the
+	// internal state of an Error. The stack trace has to be captured again to
+	// pick up the new name.
+	code, err := env.CreateString(te.class.code)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := env.CreateString(te.Error())
+	if err != nil {
+		return nil, err
+	}
+	errValue, err := env.CreateError(code, msg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := callGlobalMethod(env, "Object", "setPrototypeOf", errValue, proto); err != nil {
+		return nil, err
+	}
+	if _, err := callGlobalMethod(env, "Error", "captureStackTrace", errValue); err != nil {
+		return nil, err
+	}
+
+	for name, value := range te.props {
+		nameValue, err := env.CreateString(name)
+		if err != nil {
+			return nil, err
+		}
+		jsValue, err := goValueToJs(env, value)
+		if err != nil {
+			return nil, err
+		}
+		if err := env.SetProperty(errValue, nameValue, jsValue); err != nil {
+			return nil, err
+		}
+	}
+	return errValue, nil
+}
+
+var parseErrorRe = regexp.MustCompile(`(?s)^template: (.*?):(\d+): `)
+
+var undefinedFuncRe = regexp.MustCompile(`function (".*?") not defined$`)
+
+// newParseError converts an error from parsing templates to a templateError.
+// The delims are those of the template being parsed, and the source function
+// returns the text a template was parsed from, given the name it was parsed
+// under, and the file it was read from (if any). Errors reading template files
+// are returned unchanged.
+func newParseError(err error, delims [2]string, source func(parseName string) (text string, file string, ok bool)) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) || errors.Is(err, filepath.ErrBadPattern) {
+		return err
+	}
+	props := make(map[string]any)
+	if m := parseErrorRe.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[2])
+		props["templateName"] = m[1]
+		props["line"] = line
+		if text, file, ok := source(m[1]); ok {
+			if file != "" {
+				props["file"] = file
+			}
+			if pos, ok := parseErrorPos(err.Error(), m[1], text, delims); ok {
+				props["column"] = pos - (strings.LastIndexByte(text[:pos], '\n') + 1)
+				if context, ok := actionAt(text, pos, delims); ok {
+					props["context"] = context
+				}
+			}
+		}
+	}
+	return &templateError{templateParseErrorClass, err, props}
+}
+
+// parseErrorPos returns the byte offset in text of the parse error with
+// message msg. Go only gives the line in the message, so the text is parsed
+// again to find the token the parser stopped at: it's the end of the text if
+// appending to the text changes the error, and otherwise ends where the
+// shortest prefix of the text giving the same error does. It starts at the
+// last point before that where an invalid character is reported as such,
+// rather than as part of the token.
+func parseErrorPos(msg string, name string, text string, delims [2]string) (int, bool) {
+	treeSet := make(map[string]*parse.Tree)
+	parseMsg := func(text string) string {
+		tree := parse.New(name)
+		// The template's functions aren't known here, so an undefined
+		// function is found in the tree afterwards instead
+		tree.Mode = parse.SkipFuncCheck
+		clear(treeSet)
+		if _, err := tree.Parse(text, delims[0], delims[1], treeSet); err != nil {
+			return err.Error()
+		}
+		return ""
+	}
+	switch parseMsg(text) {
+	case msg:
+	case "":
+		return undefinedFuncPos(msg, treeSet)
+	default:
+		return 0, false
+	}
+
+	left := delims[0]
+	if left == "" {
+		left = "{{"
+	}
+	if parseMsg(text+left+"\x00") != msg {
+		return len(text), true
+	}
+	end := sort.Search(len(text), func(n int) bool { return parseMsg(text[:n]) == msg })
+	for start := end - 1; start >= 0; start-- {
+		if !strings.Contains(parseMsg(text[:start]+"\x00"), "U+0000") {
+			continue
+		}
+		// A truncated identifier, field or variable is a valid token
+		for start > 0 && isIdentByte(text[start-1]) && isIdentByte(text[start]) {
+			start--
+		}
+		if start > 0 && isIdentByte(text[start]) && (text[start-1] == '.' || text[start-1] == '$') {
+			start--
+		}
+		return start, true
+	}
+	return end, true
+}
+
+// undefinedFuncPos returns the byte offset of the call to an undefined function
+// that the parse error with message msg is about, in the trees parsed from the
+// template's text without checking functions.
+func undefinedFuncPos(msg string, treeSet map[string]*parse.Tree) (int, bool) {
+	m := undefinedFuncRe.FindStringSubmatch(msg)
+	if m == nil {
+		return 0, false
+	}
+	funcName, err := strconv.Unquote(m[1])
+	if err != nil {
+		return 0, false
+	}
+	// Parsing stops at the first use of the function
+	first := -1
+	for _, t := range treeSet {
+		visitIdentifiers(t.Root, func(ident *parse.IdentifierNode) {
+			if ident.Ident == funcName && (first < 0 || int(ident.Pos) < first) {
+				first = int(ident.Pos)
+			}
+		})
+	}
+	return first, first >= 0
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= utf8.RuneSelf || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+// actionAt returns the source of the action containing the byte offset pos in
+// text. An action that isn't closed runs to the end of its line.
+func actionAt(text string, pos int, delims [2]string) (string, bool) {
+	left, right := delims[0], delims[1]
+	if left == "" {
+		left = "{{"
+	}
+	if right == "" {
+		right = "}}"
+	}
+	start := strings.LastIndex(text[:min(pos+len(left), len(text))], left)
+	if start < 0 {
+		return "", false
+	}
+	action := text[start:]
+	if end := strings.Index(action[len(left):], right); end >= 0 {
+		action = action[:len(left)+end+len(right)]
+		if pos >= start+len(action) {
+			return "", false
+		}
+		return action, true
+	}
+	if end := strings.IndexByte(action, '\n'); end >= 0 {
+		action = action[:end]
+	}
+	if pos > start+len(action) {
+		return "", false
+	}
+	return strings.TrimSuffix(action, "\r"), true
+}
+
+// parseFilesSource returns a source function for newParseError for templates
+// parsed from files, which are named by their base names.
+func parseFilesSource(filenames []string) func(string) (string, string, bool) {
+	return func(parseName string) (string, string, bool) {
+		// Later files replace earlier ones with the same name
+		for i := len(filenames) - 1; i >= 0; i-- {
+			if filepath.Base(filenames[i]) == parseName {
+				text, err := os.ReadFile(filenames[i])
+				return string(text), filenames[i], err == nil
+			}
+		}
+		return "", "", false
+	}
+}
+
+var execErrorRe = regexp.MustCompile(`(?s)^template: (.*?):(\d+):(\d+): executing ".*?" at <(.*?)>: `)
+
+// newExecError converts an error from executing tmpl to a templateError. The
+// files map gives the paths of templates parsed from files, and the sources map
+// their sources (see templateAssn), by name. Exceptions thrown by JS code run
+// during execution, such as template functions and getters, are returned to be
+// rethrown unchanged.
+func newExecError(tmpl goTemplate, err error, files map[string]string, sources map[string]*treeSource) error {
+	var jsExc *jsExceptionError
+	if errors.As(err, &jsExc) {
+		return jsExc
+	}
+	err = hideLazyFills(tmpl, err, sources)
+	props := make(map[string]any)
+	var execErr template.ExecError
+	var htmlErr *htmltemplate.Error
+	if errors.As(err, &execErr) {
+		props["templateName"] = execErr.Name
+		if m := execErrorRe.FindStringSubmatch(execErr.Error()); m != nil {
+			line, _ := strconv.Atoi(m[2])
+			col, _ := strconv.Atoi(m[3])
+			props["line"] = line
+			props["column"] = col
+			props["nodeText"] = m[4]
+			if file, ok := files[m[1]]; ok {
+				props["file"] = file
+			}
+			if named := tmpl.Lookup(execErr.Name); named != nil && named.Tree() != nil {
+				if context, ok := actionContext(named.Tree(), sources[execErr.Name], line, col); ok {
+					props["context"] = context
+				}
+			}
+		}
+	} else if errors.As(err, &htmlErr) {
+		if htmlErr.Name != "" {
+			props["templateName"] = htmlErr.Name
+			if file, ok := files[htmlErr.Name]; ok {
+				props["file"] = file
+			}
+		}
+		if htmlErr.Line > 0 {
+			props["line"] = htmlErr.Line
+		}
+		if htmlErr.Node != nil {
+			props["nodeText"] = htmlErr.Node.String()
+		}
+	}
+	return &templateError{templateExecErrorClass, err, props}
+}
+
+// actionContext returns the source of the action containing the node at the
+// given location of tree, whose source is given if known, as found by the
+// tree's ErrorContext method.
+func actionContext(tree *parse.Tree, source *treeSource, line int, col int) (string, bool) {
+	lines := newLineIndex(tree, source)
+	matches := func(node parse.Node) bool {
+		nodeLine, nodeCol := lines.location(node)
+		return nodeLine == line && nodeCol == col
+	}
+	var walk func(node parse.Node) (string, bool)
+	walk = func(node parse.Node) (string, bool) {
+		switch n := node.(type) {
+		case *parse.ActionNode:
+			if isLazyFill(n) {
+				break
+			}
+			if matches(n) || pipeContains(n.Pipe, matches) {
+				return "{{" + pipeSource(n.Pipe) + "}}", true
+			}
+		case *parse.IfNode:
+			return walkBranch(&n.BranchNode, "if", matches, walk)
+		case *parse.ListNode:
+			for _, child := range n.Nodes {
+				if result, ok := walk(child); ok {
+					return result, true
+				}
+			}
+		case *parse.RangeNode:
+			return walkBranch(&n.BranchNode, "range", matches, walk)
+		case *parse.TemplateNode:
+			if matches(n) || pipeContains(n.Pipe, matches) {
+				result := "{{template " + strconv.Quote(n.Name)
+				if n.Pipe != nil {
+					result += " " + pipeSource(n.Pipe)
+				}
+				return result + "}}", true
+			}
+		case *parse.WithNode:
+			return walkBranch(&n.BranchNode, "with", matches, walk)
+		}
+		return "", false
+	}
+	if tree.Root == nil {
+		return "", false
+	}
+	return walk(tree.Root)
+}
+
+func walkBranch(branch *parse.BranchNode, keyword string, matches func(parse.Node) bool, walk func(parse.Node) (string, bool)) (string, bool) {
+	if matches(branch) || pipeContains(branch.Pipe, matches) {
+		return "{{" + keyword + " " + pipeSource(branch.Pipe) + "}}", true
+	}
+	if result, ok := walk(branch.List); ok {
+		return result, true
+	}
+	if branch.ElseList != nil {
+		return walk(branch.ElseList)
+	}
+	return "", false
+}
+
+func pipeContains(pipe *parse.PipeNode, matches func(parse.Node) bool) bool {
+	if pipe == nil {
+		return false
+	}
+	if matches(pipe) {
+		return true
+	}
+	for _, decl := range pipe.Decl {
+		if matches(decl) {
+			return true
+		}
+	}
+	for _, cmd := range pipe.Cmds {
+		if matches(cmd) {
+			return true
+		}
+		for _, arg := range cmd.Args {
+			if matches(arg) {
+				return true
+			}
+			switch a := arg.(type) {
+			case *parse.ChainNode:
+				if matches(a.Node) {
+					return true
+				}
+				if p, ok := a.Node.(*parse.PipeNode); ok && pipeContains(p, matches) {
+					return true
+				}
+			case *parse.PipeNode:
+				if pipeContains(a, matches) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// pipeSource returns the source of a pipeline, without any escaping functions
+// added by html/template, or commands added by prepareLazyFills.
+func pipeSource(pipe *parse.PipeNode) string {
+	var sb strings.Builder
+	for i, decl := range pipe.Decl {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(decl.String())
+	}
+	if len(pipe.Decl) > 0 {
+		if pipe.IsAssign {
+			sb.WriteString(" = ")
+		} else {
+			sb.WriteString(" := ")
+		}
+	}
+	first := true
+	for _, cmd := range pipe.Cmds {
+		if ident, ok := cmd.Args[0].(*parse.IdentifierNode); ok && strings.HasPrefix(ident.Ident, "_html_template_") {
+			continue
+		}
+		if isLazyFillCommand(cmd) {
+			continue
+		}
+		if !first {
+			sb.WriteString(" | ")
+		}
+		first = false
+		sb.WriteString(stripLazyFills(cmd.String()))
+	}
+	return sb.String()
+}
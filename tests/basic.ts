@@ -1,8 +1,19 @@
-import { beforeEach, describe, expect, it, jest, test } from '@jest/globals';
+import {
+  afterEach,
+  beforeEach,
+  describe,
+  expect,
+  it,
+  jest,
+  test,
+} from '@jest/globals';
+import * as fs from 'fs';
+import * as os from 'os';
 import * as path from 'path';
+import { Writable } from 'stream';
 
 import * as binding from '..';
-import { Template } from '..';
+import { HtmlTemplate, Template } from '..';
 
 const templateDir = path.join(__dirname, 'data');
 
@@ -13,6 +24,48 @@ describe('Template', () => {
     template = new Template('test_template');
   });
 
+  describe('#addParseTree', () => {
+    it('works', () => {
+      const source = new Template('source')
+        .funcs({ f: (x: number) => x * 2 })
+        .parse(
+          '{{ range $i, $v := .l }}{{ if $i }}, {{ end }}{{ f $v }}{{ end }}',
+        );
+      const tree = source.tree();
+      if (!tree) throw new Error('missing tree');
+      const added = template
+        .funcs({ f: (x: number) => x * 3 })
+        .addParseTree('added', tree);
+      expect(added.name()).toBe('added');
+      expect(added.executeString({ l: [1, 2] })).toBe('3, 6');
+    });
+
+    it('replaces the receiver', () => {
+      template.parse('old');
+      const tree = new Template('new').parse('new {{ . }}').tree();
+      if (!tree) throw new Error('missing tree');
+      template.addParseTree('test_template', tree);
+      expect(template.executeString('data')).toBe('new data');
+    });
+
+    it('preserves text containing delimiters', () => {
+      template.addParseTree('test_template', {
+        name: 'test_template',
+        parseName: 'test_template',
+        root: {
+          type: 'List',
+          pos: 0,
+          line: 1,
+          column: 0,
+          nodes: [
+            { type: 'Text', pos: 0, line: 1, column: 0, text: '{{ x }}' },
+          ],
+        },
+      });
+      expect(template.executeString()).toBe('{{ x }}');
+    });
+  });
+
   describe('#clone', () => {
     it('works', () => {
       const cloned = template.parse('results').clone();
@@ -38,6 +91,224 @@ describe('Template', () => {
     });
   });
 
+  describe('#conversionOptions', () => {
+    it('converts integers to int64 by default', () => {
+      template.parse(
+        '{{ printf "%d" .a }} {{ eq .a 3 }} {{ printf "%T" .b }}',
+      );
+      expect(template.executeString({ a: 3, b: 1.5 })).toBe('3 true float64');
+    });
+
+    it('only converts safe integers', () => {
+      template.parse('{{ range . }}{{ printf "%T " . }}{{ end }}');
+      const data = [2 ** 53 - 1, 2 ** 53, -0, Infinity];
+      expect(template.executeString(data)).toBe(
+        'int64 float64 float64 float64 ',
+      );
+    });
+
+    it('can force floats', () => {
+      template
+        .funcs({ jsFn: () => 42 })
+        .conversionOptions({ floatNumbers: true })
+        .parse('{{ printf "%T %T" . jsFn }}');
+      expect(template.executeString(3)).toBe('float64 float64');
+      template.conversionOptions({});
+      expect(template.executeString(3)).toBe('int64 int64');
+    });
+
+    describe('toJSON and toGoValue', () => {
+      class Decimal {
+        digits = [1, 5];
+        exponent = -1;
+
+        toJSON(key: string) {
+          return `1.5@${key}`;
+        }
+      }
+
+      class Money {
+        cents: number;
+
+        constructor(cents: number) {
+          this.cents = cents;
+        }
+
+        [Template.toGoValue]() {
+          return { cents: this.cents, dollars: this.cents / 100 };
+        }
+      }
+
+      it('ignores toJSON by default', () => {
+        template.parse('{{ .d.exponent }}');
+        expect(template.executeString({ d: new Decimal() })).toBe('-1');
+      });
+
+      it('can call toJSON', () => {
+        template
+          .conversionOptions({ toJSON: true })
+          .parse('{{ .d }} {{ index .list 0 }} {{ .date.Year }}');
+        const data = {
+          d: new Decimal(),
+          list: [new Decimal()],
+          date: new Date(Date.UTC(2020, 0)),
+        };
+        expect(template.executeString(data)).toBe('1.5@d 1.5@0 2020');
+      });
+
+      it('always calls toGoValue', () => {
+        template.parse('{{ .m.cents }} {{ .m.dollars }}');
+        expect(template.executeString({ m: new Money(250) })).toBe('250 2.5');
+        expect(HtmlTemplate.toGoValue).toBe(Template.toGoValue);
+      });
+
+      it('converts objects returning themselves normally', () => {
+        template.conversionOptions({ toJSON: true }).parse('{{ .a }}');
+        const data = {
+          a: 1,
+          toJSON() {
+            return this;
+          },
+        };
+        expect(template.executeString(data)).toBe('1');
+      });
+    });
+
+    it('omits undefined properties and keeps nulls', () => {
+      template
+        .addSprigFuncs()
+        .option('missingkey=error')
+        .parse('{{ hasKey . "a" }} {{ hasKey . "b" }} {{ .b }} {{ len .m }}');
+      const data = { a: undefined, b: null, m: new Map([['x', undefined]]) };
+      expect(template.executeString(data)).toBe('false true <no value> 0');
+      template.parse('{{ .a }}');
+      expect(() => template.executeString(data)).toThrow(
+        'map has no entry for key "a"',
+      );
+    });
+
+    it('can keep undefined properties', () => {
+      template
+        .addSprigFuncs()
+        .conversionOptions({ keepUndefined: true })
+        .parse('{{ hasKey . "a" }} {{ .a }} {{ len .m }}');
+      const data = { a: undefined, m: new Map([['x', undefined]]) };
+      expect(template.executeString(data)).toBe('true <no value> 1');
+    });
+
+    it('allows shared references', () => {
+      const shared = { a: 1 };
+      template.parse('{{ .x.a }}{{ .y.a }}');
+      expect(template.executeString({ x: shared, y: shared })).toBe('11');
+    });
+
+    describe('in lazy mode', () => {
+      // Returns data with a property that throws if it's read
+      const withUnused = (data: object) =>
+        Object.defineProperty(data, 'unused', {
+          enumerable: true,
+          get() {
+            throw new Error('unused property read');
+          },
+        });
+
+      beforeEach(() => {
+        template.conversionOptions({ lazy: true });
+      });
+
+      it('only reads the fields used', () => {
+        template.parse('{{ .a.b }} {{ range .c }}{{ .d }}{{ end }}');
+        const data = withUnused({
+          a: withUnused({ b: 1 }),
+          c: [withUnused({ d: 2 }), withUnused({ d: 3 })],
+        });
+        expect(template.executeString(data)).toBe('1 23');
+        template.conversionOptions({});
+        expect(() => template.executeString(data)).toThrow(
+          'unused property read',
+        );
+      });
+
+      it('reads the keys of values that are tested', () => {
+        template.parse('{{ with .a }}{{ .b }}{{ else }}empty{{ end }}');
+        expect(template.executeString({ a: withUnused({ c: 1 }) })).toBe(
+          '<no value>',
+        );
+        expect(template.executeString({ a: {} })).toBe('empty');
+        expect(template.executeString({ a: { c: undefined } })).toBe('empty');
+        template.conversionOptions({ lazy: true, keepUndefined: true });
+        expect(template.executeString({ a: withUnused({}) })).toBe(
+          '<no value>',
+        );
+      });
+
+      it('passes values to functions unchanged', () => {
+        const jsFn = jest.fn();
+        template
+          .funcs({ jsFn })
+          .parse('{{ .a.b }}{{ jsFn .a }}{{ call .f . }}');
+        const a = withUnused({ b: 1 });
+        const data = { a, f: jest.fn() };
+        template.executeString(data);
+        expect(jsFn.mock.calls[0][0]).toBe(a);
+        expect(data.f.mock.calls[0][0]).toBe(data);
+      });
+
+      it('reads printed values in full', () => {
+        template.parse('{{ .a.b }} {{ .a }}');
+        expect(template.executeString({ a: { b: 1, c: 2 } })).toBe(
+          '1 map[b:1 c:2]',
+        );
+      });
+
+      it('reads reassigned variables in full', () => {
+        template.parse(
+          '{{ $x := .a }}{{ if true }}{{ $x = .b }}{{ end }}{{ $x.c }}',
+        );
+        expect(template.executeString({ a: {}, b: { c: 1 } })).toBe('1');
+      });
+
+      it('follows template actions', () => {
+        template.parse(
+          '{{ define "inner" }}{{ .b }}{{ end }}{{ template "inner" .a }}',
+        );
+        const data = withUnused({ a: withUnused({ b: 1 }) });
+        expect(template.executeString(data)).toBe('1');
+        expect(
+          template.executeTemplateString('inner', withUnused({ b: 2 })),
+        ).toBe('2');
+      });
+
+      it('applies to asynchronous execution', async () => {
+        template.parse('{{ .a.b }}');
+        const data = withUnused({ a: withUnused({ b: 1 }) });
+        await expect(template.executeAsync(data)).resolves.toBe('1');
+        const chunks: string[] = [];
+        await template.executeToStream((chunk) => chunks.push(chunk), data);
+        expect(chunks.join('')).toBe('1');
+      });
+
+      it('applies to HtmlTemplate', () => {
+        const html = new HtmlTemplate('html');
+        html.conversionOptions({ lazy: true }).parse('<p title="{{ .a }}">');
+        const data = withUnused({ a: '"' });
+        expect(html.executeString(data)).toBe('<p title="&#34;">');
+        expect(html.clone().executeString(data)).toBe('<p title="&#34;">');
+        // The copy that was executed was escaped, not the template itself
+        html.parse('{{ define "more" }}{{ end }}');
+        expect(html.executeString(data)).toBe('<p title="&#34;">');
+      });
+    });
+
+    it('applies to asynchronous execution', async () => {
+      template
+        .funcs({ jsFn: () => 42 })
+        .conversionOptions({ floatNumbers: true })
+        .parse('{{ printf "%T %T" . jsFn }}');
+      await expect(template.executeAsync(3)).resolves.toBe('float64 float64');
+    });
+  });
+
   test('#definedTemplates works', () => {
     expect(template.definedTemplates()).toBe('');
     template.parse('{{define "foo"}}{{ end }}');
@@ -51,6 +322,42 @@ describe('Template', () => {
     expect(template.executeString('hello')).toBe('hello');
   });
 
+  describe('#executeAsync', () => {
+    it('works', async () => {
+      template.parse('{{ .foo }}, {{ .bar }}');
+      await expect(
+        template.executeAsync({ foo: 'hello', bar: 'world' }),
+      ).resolves.toBe('hello, world');
+    });
+
+    it('works with JS functions', async () => {
+      const myFunc = jest.fn((value: string) => `pre-${value}-post`);
+      template.funcs({ myFunc }).parse('{{ myFunc .param }}');
+      await expect(template.executeAsync({ param: 'hello' })).resolves.toBe(
+        'pre-hello-post',
+      );
+      expect(myFunc).toHaveBeenCalledWith('hello');
+      expect(myFunc).toHaveBeenCalledTimes(1);
+    });
+
+    it('runs concurrently', async () => {
+      template.addSprigFuncs().parse('{{ repeat 3 . }}');
+      const results = await Promise.all(
+        ['a', 'b', 'c'].map((v) => template.executeAsync(v)),
+      );
+      expect(results).toStrictEqual(['aaa', 'bbb', 'ccc']);
+    });
+
+    it('keeps using functions replaced during execution', async () => {
+      const oldFunc = jest.fn(() => 'old');
+      template.funcs({ myFunc: oldFunc }).parse('{{ myFunc }}');
+      const result = template.executeAsync();
+      template.funcs({ myFunc: () => 'new' });
+      await expect(result).resolves.toBe('old');
+      expect(template.executeString()).toBe('new');
+    });
+  });
+
   test('#executeString works', () => {
     template.parse('{{ .foo }}, {{ .bar }}');
     expect(template.executeString({ foo: 'hello', bar: 'world' })).toBe(
@@ -65,6 +372,110 @@ describe('Template', () => {
     );
   });
 
+  test('#executeTemplateAsync works', async () => {
+    template.parse('{{ define "inner" }}inner {{ .param }}{{ end }}outer');
+    await expect(
+      template.executeTemplateAsync('inner', { param: 'param' }),
+    ).resolves.toBe('inner param');
+  });
+
+  describe('#executeJSON', () => {
+    it('works', () => {
+      template.parse('{{ range .items }}{{ .name }}{{ end }} {{ .n }}');
+      const json = '{"items": [{"name": "a"}, {"name": "b"}], "n": 1.5}';
+      expect(template.executeJSON(json)).toBe('ab 1.5');
+      expect(template.executeJSON(Buffer.from(json))).toBe('ab 1.5');
+      expect(template.executeJSON(new TextEncoder().encode(json))).toBe(
+        'ab 1.5',
+      );
+    });
+
+    it('keeps integers exact', () => {
+      template.parse('{{ printf "%T %d" .n .n }} {{ eq .m 3 }}');
+      expect(template.executeJSON('{"n": 9007199254740993, "m": 3}')).toBe(
+        'int64 9007199254740993 true',
+      );
+    });
+
+    it('respects conversion options', () => {
+      template
+        .conversionOptions({ floatNumbers: true })
+        .parse('{{ printf "%T" . }}');
+      expect(template.executeJSON('3')).toBe('float64');
+    });
+  });
+
+  test('#executeTemplateJSON works', () => {
+    template.parse('{{ define "inner" }}{{ .a }}{{ end }}');
+    expect(template.executeTemplateJSON('inner', '{"a": "b"}')).toBe('b');
+  });
+
+  describe('#executeToStream', () => {
+    it('works with a stream', async () => {
+      const chunks: Buffer[] = [];
+      const stream = new Writable({
+        write(chunk: Buffer, _encoding, callback) {
+          chunks.push(chunk);
+          setImmediate(callback);
+        },
+      });
+      template.parse('{{ range . }}{{ . }}{{ end }}');
+      const data = Array.from({ length: 10000 }, (_, i) => `line ${i}\n`);
+      await expect(template.executeToStream(stream, data)).resolves.toBe(
+        undefined,
+      );
+      expect(chunks.length).toBeGreaterThan(1);
+      for (const chunk of chunks) {
+        expect(chunk.length).toBeLessThanOrEqual(16 * 1024);
+      }
+      expect(Buffer.concat(chunks).toString()).toBe(data.join(''));
+    });
+
+    it('works with more file streams than thread pool threads', async () => {
+      const dir = fs.mkdtempSync(path.join(os.tmpdir(), 'go-text-template-'));
+      template.parse('{{ range . }}{{ . }}{{ end }}');
+      const data = Array.from({ length: 10000 }, (_, i) => `line ${i}\n`);
+      try {
+        await Promise.all(
+          Array.from({ length: 8 }, async (_, i) => {
+            const file = path.join(dir, `${i}.txt`);
+            const stream = fs.createWriteStream(file);
+            await template.executeToStream(stream, data);
+            await new Promise((resolve) => stream.end(resolve));
+            expect(fs.readFileSync(file, 'utf8')).toBe(data.join(''));
+          }),
+        );
+      } finally {
+        fs.rmSync(dir, { recursive: true });
+      }
+    });
+
+    it('works with a callback', async () => {
+      const chunks: Buffer[] = [];
+      template.parse('{{ .foo }}, {{ .bar }}');
+      await template.executeToStream(
+        async (chunk) => {
+          chunks.push(chunk);
+        },
+        { foo: 'hello', bar: 'world' },
+      );
+      expect(Buffer.concat(chunks).toString()).toBe('hello, world');
+    });
+  });
+
+  test('#executeTemplateToStream works', async () => {
+    const chunks: Buffer[] = [];
+    template.parse('{{ define "inner" }}inner {{ .param }}{{ end }}outer');
+    await template.executeTemplateToStream(
+      (chunk) => {
+        chunks.push(chunk);
+      },
+      'inner',
+      { param: 'param' },
+    );
+    expect(Buffer.concat(chunks).toString()).toBe('inner param');
+  });
+
   describe('#funcs', () => {
     it('works', () => {
       const myFunc = jest.fn((value: string) => `pre-${value}-post`);
@@ -137,6 +548,85 @@ describe('Template', () => {
     });
   });
 
+  describe('#inferDataShape', () => {
+    it('works', () => {
+      template.parse(
+        '{{ .user.name }}{{ range .items }}{{ .id }}{{ $.title }}{{ end }}',
+      );
+      expect(template.inferDataShape()).toStrictEqual({
+        type: 'object',
+        fields: {
+          items: {
+            type: 'array',
+            elem: { type: 'object', fields: { id: { type: 'scalar' } } },
+          },
+          title: { type: 'scalar' },
+          user: { type: 'object', fields: { name: { type: 'scalar' } } },
+        },
+      });
+    });
+
+    it('follows dot through with and template actions', () => {
+      template.parse(
+        '{{ with $u := .user }}{{ .name }}{{ $u.age }}{{ end }}' +
+          '{{ template "sub" .sub }}' +
+          '{{ define "sub" }}{{ if .flag }}{{ index .m "key" }}{{ end }}' +
+          '{{ end }}',
+      );
+      expect(template.inferDataShape()).toStrictEqual({
+        type: 'object',
+        fields: {
+          sub: {
+            type: 'object',
+            fields: {
+              flag: { type: 'any' },
+              m: { type: 'object', fields: { key: { type: 'scalar' } } },
+            },
+          },
+          user: {
+            type: 'object',
+            fields: { age: { type: 'scalar' }, name: { type: 'scalar' } },
+          },
+        },
+      });
+    });
+
+    it('handles recursive templates', () => {
+      template.parse(
+        '{{ define "node" }}{{ .name }}{{ range .children }}' +
+          '{{ template "node" . }}{{ end }}{{ end }}{{ template "node" . }}',
+      );
+      expect(template.inferDataShape()).toStrictEqual({
+        type: 'object',
+        fields: {
+          children: { type: 'array', elem: { type: 'any' } },
+          name: { type: 'scalar' },
+        },
+      });
+    });
+
+    it('emits JSON Schema', () => {
+      template.parse('{{ range .items }}{{ .id }}{{ end }}');
+      expect(template.inferDataShape({ jsonSchema: true })).toStrictEqual({
+        $schema: 'https://json-schema.org/draft/2020-12/schema',
+        type: 'object',
+        properties: {
+          items: {
+            type: 'array',
+            items: {
+              type: 'object',
+              properties: { id: { type: ['boolean', 'number', 'string'] } },
+            },
+          },
+        },
+      });
+    });
+
+    it('returns undefined for unparsed templates', () => {
+      expect(template.inferDataShape()).toBeUndefined();
+    });
+  });
+
   describe('#lookup', () => {
     it('works', () => {
       template.parse('{{ define "foo" }}{{ end }}');
@@ -184,17 +674,120 @@ describe('Template', () => {
     });
   });
 
+  test('#parseArchive works', () => {
+    template.parseArchive(path.join(templateDir, 'archive.zip'), 'pages/*.tpl');
+    expect(template.executeTemplateString('pages/home.tpl', 'x')).toBe(
+      'home x',
+    );
+  });
+
   test('#parseFiles works', () => {
     template.parseFiles(path.join(templateDir, 'a.tpl'));
     expect(template.executeTemplateString('a.tpl')).toBe('template a\n');
   });
 
+  describe('#parseFS', () => {
+    const files = {
+      'a.tpl': 'a{{ template "b.tpl" }}',
+      'sub/b.tpl': Buffer.from('b'),
+      'sub/c.txt': 'c',
+    };
+
+    it('works with a map of files', () => {
+      template.parseFS(files, '*.tpl', 'sub/*.tpl');
+      expect(template.executeTemplateString('a.tpl')).toBe('ab');
+      expect(template.templates().map((t) => t.name()).sort()).toEqual([
+        'a.tpl',
+        'b.tpl',
+      ]);
+    });
+
+    it('works with a loader', () => {
+      const loader = {
+        readFile: (p: string) => fs.readFileSync(path.join(templateDir, p)),
+        readDir: (p: string) =>
+          fs.readdirSync(path.join(templateDir, p), { withFileTypes: true }),
+      };
+      template.parseFS(loader, '*.tpl');
+      expect(template.executeTemplateString('b.tpl')).toBe('template b\n');
+      template.parseFS({ readFile: () => 'x' }, 'x.tpl');
+      expect(template.executeTemplateString('x.tpl')).toBe('x');
+    });
+
+    it('matches patterns like Go', () => {
+      template.parseFS(files, '*/*.tpl');
+      expect(template.executeTemplateString('b.tpl')).toBe('b');
+      const readDir = (p: string) =>
+        p === '.' ? [{ name: 'sub', isDirectory: true }, 'a.tpl'] : ['b.tpl'];
+      const readFile = (p: string) => `<${p}>`;
+      template.parseFS({ readFile, readDir }, '[a-z]*/?.tpl');
+      expect(template.executeTemplateString('b.tpl')).toBe('<sub/b.tpl>');
+    });
+  });
+
   test('#parseGlob works', () => {
     template.parseGlob(path.join(templateDir, '*.tpl'));
     expect(template.executeTemplateString('a.tpl')).toBe('template a\n');
     expect(template.executeTemplateString('b.tpl')).toBe('template b\n');
   });
 
+  test('#serialize works', () => {
+    const up = (s: string) => s.toUpperCase();
+    template.funcs({ up }).delims('<<', '>>').option('missingkey=error');
+    template.parse('<< up .a >> << template "sub" . >> {{ . }}');
+    template.new('sub').parse('<<- range .list >>[<< . >>]<< end >>');
+    const data = { a: 'a', list: [1, 2] };
+    const expected = 'A [1][2] {{ . }}';
+    expect(template.executeString(data)).toBe(expected);
+
+    const buf = template.serialize();
+    expect(buf).toBeInstanceOf(Buffer);
+    const loaded = Template.deserialize(buf, { up });
+    expect(loaded.name()).toBe('test_template');
+    expect(loaded.executeString(data)).toBe(expected);
+    expect(() => loaded.executeString({})).toThrow('map has no entry');
+    loaded.new('later').parse('<< .a >>');
+    expect(loaded.executeTemplateString('later', data)).toBe('a');
+  });
+
+  test('#serialize preserves every kind of node', () => {
+    template.parse(
+      '{{/* comment */}}{{ "\\xff" | printf "%q" }} {{ 1.5e3 }} {{ 0x10 }} ' +
+        "{{ 'a' }} {{ range $i, $v := . }}{{ if eq $i 1 }}{{ continue }}" +
+        '{{ else if eq $i 3 }}{{ break }}{{ end }}{{ $v }}{{ else }}none' +
+        '{{ end }} {{ with $x := true }}{{ $x = 2 }}{{ $x }}{{ end }}',
+    );
+    const data = [1, 2, 3, 4];
+    const expected = '"\\xff" 1500 16 97 13 2';
+    expect(template.executeString(data)).toBe(expected);
+    const loaded = Template.deserialize(template.serialize());
+    expect(loaded.executeString(data)).toBe(expected);
+  });
+
+  test('#serialize keeps the delimiters each template was parsed with', () => {
+    template.parse('{{ define "a" }}a{{ . }}{{ end }}{{ template "a" . }}');
+    template.delims('<<', '>>');
+    template.new('b').parse('b<< . >>');
+    template.addParseTree('c', template.templateTree('b')!);
+    const loaded = Template.deserialize(template.serialize());
+    expect(loaded.executeString(1)).toBe('a1');
+    expect(loaded.executeTemplateString('b', 2)).toBe('b2');
+    expect(loaded.executeTemplateString('c', 3)).toBe('b3');
+  });
+
+  test('#serialize records Sprig functions', () => {
+    template.addSprigFuncs().parse('{{ upper "a" }} {{ lower "B" }}');
+    const buf = template.serialize();
+    expect(Template.deserialize(buf).executeString()).toBe('A b');
+    const upper = (s: string) => `js ${s}`;
+    const loaded = Template.deserialize(buf, { upper });
+    expect(loaded.executeString()).toBe('js a b');
+
+    const hermetic = new Template('h').addSprigHermeticFuncs();
+    const hermeticBuf = hermetic.parse('{{ upper "a" }}').serialize();
+    expect(Template.deserialize(hermeticBuf).executeString()).toBe('A');
+  });
+
   test('#templates works', () => {
     expect(template.templates()).toStrictEqual([]);
     template.new('foo').parse('foo contents');
@@ -204,6 +797,86 @@ describe('Template', () => {
     expect(templates[0]?.executeString()).toBe('foo contents');
   });
 
+  describe('#tree', () => {
+    it('works', () => {
+      template.parse('a{{ .b }}');
+      expect(template.tree()).toStrictEqual({
+        name: 'test_template',
+        parseName: 'test_template',
+        root: {
+          type: 'List',
+          pos: 0,
+          line: 1,
+          column: 0,
+          nodes: [
+            { type: 'Text', pos: 0, line: 1, column: 0, text: 'a' },
+            {
+              type: 'Action',
+              pos: 4,
+              line: 1,
+              column: 4,
+              pipe: {
+                type: 'Pipe',
+                pos: 4,
+                line: 1,
+                column: 4,
+                isAssign: false,
+                decl: [],
+                cmds: [
+                  {
+                    type: 'Command',
+                    pos: 4,
+                    line: 1,
+                    column: 4,
+                    args: [
+                      {
+                        type: 'Field',
+                        pos: 4,
+                        line: 1,
+                        column: 4,
+                        ident: ['b'],
+                      },
+                    ],
+                  },
+                ],
+              },
+            },
+          ],
+        },
+      });
+    });
+
+    it('handles branches', () => {
+      template.parse('{{ if 1 }}{{ else }}{{ template "x" }}{{ end }}');
+      const node = template.tree()?.root.nodes[0];
+      expect(node?.type).toBe('If');
+      if (node?.type !== 'If') return;
+      expect(node.list.nodes).toStrictEqual([]);
+      expect(node.elseList?.nodes[0]).toMatchObject({
+        type: 'Template',
+        name: 'x',
+        pipe: null,
+      });
+    });
+
+    it('returns undefined for unparsed templates', () => {
+      expect(template.tree()).toBeUndefined();
+    });
+  });
+
+  test('#templateTree works', () => {
+    template.parse('{{ define "foo" }}\n{{ "bar" }}{{ end }}');
+    expect(template.templateTree('foo')?.root.nodes[1]).toMatchObject({
+      type: 'Action',
+      line: 2,
+      column: 3,
+      pipe: {
+        cmds: [{ args: [{ type: 'String', quoted: '"bar"', text: 'bar' }] }],
+      },
+    });
+    expect(template.templateTree('missing')).toBeUndefined();
+  });
+
   test('#addSprigFuncs works', () => {
     template.addSprigFuncs().parse('{{ dict "a" 42 }}');
     expect(template.executeString()).toBe('map[a:42]');
@@ -219,12 +892,29 @@ describe('Template', () => {
     );
   });
 
+  describe('static .parseArchive', () => {
+    it.each(['archive.tar.gz', 'archive.zip'])('works with %s', (name) => {
+      const archive = path.join(templateDir, name);
+      for (const source of [archive, fs.readFileSync(archive)]) {
+        const parsed = Template.parseArchive(source, 'layouts/*', 'pages/*');
+        expect(parsed.name()).toBe('layouts/base.tpl');
+        expect(parsed.executeString('x')).toBe('[home x]');
+      }
+    });
+  });
+
   test('static .parseFiles works', () => {
     const parsed = Template.parseFiles(path.join(templateDir, 'a.tpl'));
     expect(parsed.name()).toBe('a.tpl');
     expect(parsed.executeString()).toBe('template a\n');
   });
 
+  test('static .parseFS works', () => {
+    const parsed = Template.parseFS({ 'a.tpl': 'a', 'b.tpl': 'b' }, '*.tpl');
+    expect(parsed.name()).toBe('a.tpl');
+    expect(parsed.executeTemplateString('b.tpl')).toBe('b');
+  });
+
   test('static .parseGlob works', () => {
     const parsed = Template.parseGlob(path.join(templateDir, '*.tpl'));
     expect(parsed.name()).toBe('a.tpl');
@@ -232,12 +922,576 @@ describe('Template', () => {
     expect(parsed.executeTemplateString('b.tpl')).toBe('template b\n');
   });
 
+  describe('static .prepareData', () => {
+    it('converts data once', async () => {
+      const getter = jest.fn(() => 'world');
+      const prepared = Template.prepareData(
+        Object.defineProperty({}, 'name', { enumerable: true, get: getter }),
+      );
+      expect(getter).toHaveBeenCalledTimes(1);
+      template.parse(
+        '{{ define "inner" }}Hi, {{ .name }}{{ end }}Hello, {{ .name }}',
+      );
+      expect(template.executeString(prepared)).toBe('Hello, world');
+      expect(template.executeTemplateString('inner', prepared)).toBe(
+        'Hi, world',
+      );
+      await expect(template.executeAsync(prepared)).resolves.toBe(
+        'Hello, world',
+      );
+      expect(template.executeString({ nested: prepared, name: 'x' })).toBe(
+        'Hello, x',
+      );
+      expect(getter).toHaveBeenCalledTimes(1);
+    });
+
+    it('accepts conversion options', () => {
+      template.parse('{{ printf "%T" . }}');
+      const prepared = Template.prepareData(1, { floatNumbers: true });
+      expect(template.executeString(prepared)).toBe('float64');
+    });
+
+    it('works with HtmlTemplate', () => {
+      const prepared = HtmlTemplate.prepareData({ a: '<b>' });
+      const tmpl = new HtmlTemplate('t').parse('{{ .a }}');
+      expect(tmpl.executeString(prepared)).toBe('&lt;b&gt;');
+    });
+  });
+
   test('JS BigInt support works', () => {
     template.parse('{{ . }}');
     const value = (1n << 128n) + 2n; // Endianness test with 64-bit words
     expect(template.executeString(value)).toBe(value.toString());
     expect(template.executeString(-value)).toBe((-value).toString());
   });
+
+  test('JS Map and Set support works', () => {
+    template.parse('{{ .m.a }} {{ range .s }}{{ . }}{{ end }} {{ len .s }}');
+    const data = { m: new Map([['a', 1]]), s: new Set(['x', 'y', 'x']) };
+    expect(template.executeString(data)).toBe('1 xy 2');
+  });
+
+  describe('static .watch', () => {
+    let dir: string;
+    let watched: Template | undefined;
+
+    beforeEach(() => {
+      dir = fs.mkdtempSync(path.join(os.tmpdir(), 'go-text-template-napi-'));
+      fs.writeFileSync(path.join(dir, 'a.tpl'), 'a {{ up . }}');
+    });
+
+    afterEach(() => {
+      watched?.close();
+      watched = undefined;
+      fs.rmSync(dir, { recursive: true });
+    });
+
+    const nextReload = (t: Template) =>
+      new Promise<unknown>((resolve) => t.on('reload', resolve));
+    const up = (s: string) => s.toUpperCase();
+
+    it('reparses files when they change', async () => {
+      const t = Template.watch(path.join(dir, '*.tpl'), {
+        funcs: { up },
+        interval: 10,
+      });
+      watched = t;
+      expect(t.name()).toBe('a.tpl');
+      expect(t.executeString('x')).toBe('a X');
+
+      const reloaded = nextReload(t);
+      fs.writeFileSync(path.join(dir, 'a.tpl'), '{{ template "b.tpl" . }}');
+      fs.writeFileSync(path.join(dir, 'b.tpl'), 'new b {{ up . }}');
+      expect(await reloaded).toBe(t);
+      expect(t.executeString('x')).toBe('new b X');
+    });
+
+    it('updates templates from lookup and templates', async () => {
+      const t = Template.watch(path.join(dir, '*.tpl'), {
+        funcs: { up },
+        interval: 10,
+      });
+      watched = t;
+      const a = t.lookup('a.tpl')!;
+      const [first] = t.templates();
+      const missing = t.new('missing.tpl');
+
+      const reloaded = nextReload(t);
+      // Replace the file, like many editors do when saving
+      const tmp = path.join(dir, 'a.tpl.tmp');
+      fs.writeFileSync(tmp, 'new a {{ up . }}');
+      fs.renameSync(tmp, path.join(dir, 'a.tpl'));
+      await reloaded;
+      expect(a.executeString('x')).toBe('new a X');
+      expect(first!.executeString('x')).toBe('new a X');
+      expect(() => missing.executeString('x')).toThrow(
+        'is an incomplete or empty template',
+      );
+      expect(missing.lookup('a.tpl')!.executeString('x')).toBe('new a X');
+    });
+
+    it('keeps functions and options, and watches added files', async () => {
+      fs.writeFileSync(path.join(dir, 'a.tpl'), 'a');
+      const other = path.join(dir, 'other.txt');
+      fs.writeFileSync(other, '{{ .missing }}');
+      const t = Template.watch(path.join(dir, '*.tpl'), { interval: 10 });
+      watched = t;
+      t.funcs({ up }).option('missingkey=error').parseFiles(other);
+
+      const reloaded = nextReload(t);
+      fs.writeFileSync(other, 'other {{ up .name }}');
+      await reloaded;
+      expect(t.executeTemplateString('other.txt', { name: 'x' })).toBe(
+        'other X',
+      );
+      expect(() => t.executeTemplateString('other.txt', {})).toThrow(
+        'map has no entry for key "name"',
+      );
+    });
+  });
+
+  describe('JS binary data support', () => {
+    it('converts Buffers to []byte', () => {
+      template
+        .addSprigFuncs()
+        .parse('{{ printf "%T" . }} {{ toString . | b64enc }}');
+      const buf = Buffer.from([0xde, 0xad, 0xbe, 0xef]);
+      expect(template.executeString(buf)).toBe('[]uint8 3q2+7w==');
+      const arr = new Uint8Array([0x68, 0x69]);
+      expect(template.executeString(arr)).toBe('[]uint8 aGk=');
+    });
+
+    it('converts other typed arrays to numeric slices', () => {
+      template.parse('{{ range . }}{{ printf "%T%v " . . }}{{ end }}');
+      const data = [
+        new Int16Array([-1, 2]),
+        new Float32Array([0.5]),
+        new BigUint64Array([1n]),
+      ];
+      expect(template.executeString(data)).toBe(
+        '[]int16[-1 2] []float32[0.5] []uint64[1] ',
+      );
+    });
+
+    it('converts []byte back to Buffers', () => {
+      const jsFn = jest.fn();
+      template.funcs({ jsFn }).parse('{{ jsFn . }}');
+      template.executeString(new Uint8Array([1, 2]));
+      expect(jsFn).toHaveBeenCalledWith(Buffer.from([1, 2]));
+    });
+  });
+
+  describe('JS Date support', () => {
+    const date = new Date(Date.UTC(2023, 10, 14, 22, 13, 20, 123));
+
+    it('converts to time.Time', () => {
+      template.parse(
+        '{{ .UnixMilli }} {{ .UTC.Format "2006-01-02T15:04:05.000Z" }}',
+      );
+      expect(template.executeString(date)).toBe(
+        '1700000000123 2023-11-14T22:13:20.123Z',
+      );
+    });
+
+    it('works with sprig functions', () => {
+      template.addSprigFuncs().parse('{{ unixEpoch . }}');
+      expect(template.executeString(date)).toBe('1700000000');
+    });
+
+    it('converts times and durations back to JS', () => {
+      const jsFn = jest.fn();
+      template.funcs({ jsFn }).parse('{{ jsFn .a }}{{ jsFn (.b.Sub .a) }}');
+      template.executeString({ a: date, b: new Date(date.getTime() + 1500) });
+      expect(jsFn.mock.calls).toEqual([[date], [1500]]);
+    });
+  });
+
+  describe('Go value conversion', () => {
+    it('converts unsigned and big integers', () => {
+      const jsFn = jest.fn();
+      template
+        .addSprigFuncs()
+        .funcs({ jsFn })
+        .parse(
+          '{{ jsFn (semver "1.2.3").Major }}' +
+            '{{ jsFn (semver "18446744073709551615.0.0").Major }}' +
+            '{{ jsFn .big }}{{ jsFn .neg }}',
+        );
+      const data = { big: 2n ** 64n + 5n, neg: -(2n ** 70n) };
+      template.executeString(data);
+      expect(jsFn.mock.calls).toEqual([
+        [1n],
+        [18446744073709551615n],
+        [data.big],
+        [data.neg],
+      ]);
+    });
+
+    it('converts int64s to numbers', () => {
+      const jsFn = jest.fn();
+      template.funcs({ jsFn }).parse('{{ jsFn .a }}{{ jsFn .b }}');
+      template.executeJSON('{"a": 9007199254740993, "b": 5}');
+      expect(jsFn.mock.calls).toEqual([[9007199254740992], [5]]);
+    });
+
+    it('converts structs and pointers', () => {
+      const jsFn = jest.fn();
+      template
+        .addSprigFuncs()
+        .funcs({ jsFn })
+        .parse(
+          '{{ jsFn (genCAWithKey "ca" 1 (genPrivateKey "ecdsa")) }}' +
+            '{{ jsFn (semver "1.2.3-beta") }}',
+        );
+      template.executeString();
+      const [[cert], [version]] = jsFn.mock.calls as [[object], [string]];
+      expect(Object.keys(cert)).toEqual(['Cert', 'Key']);
+      expect(version).toBe('1.2.3-beta');
+    });
+
+    it('converts NaN and infinities', () => {
+      const jsFn = jest.fn();
+      template.funcs({ jsFn }).parse('{{ jsFn . }}');
+      template.executeString([NaN, Infinity, -Infinity]);
+      expect(jsFn).toHaveBeenCalledWith([NaN, Infinity, -Infinity]);
+    });
+  });
+
+  describe('opaque JS values', () => {
+    class Decimal {
+      value: string;
+
+      constructor(value: string) {
+        this.value = value;
+      }
+    }
+
+    it('passes class instances between JS functions unchanged', () => {
+      const parsed: Decimal[] = [];
+      const format = jest.fn((d: Decimal) => `${d.value}!`);
+      template
+        .funcs({
+          parse: (s: string) => {
+            parsed.push(new Decimal(s));
+            return parsed[parsed.length - 1];
+          },
+          format,
+        })
+        .parse('{{ .x | parse | format }}');
+      expect(template.executeString({ x: '1.5' })).toBe('1.5!');
+      expect(format.mock.calls[0][0]).toBe(parsed[0]);
+    });
+
+    it('passes Maps and marked objects unchanged', () => {
+      const map = new Map([['a', 1]]);
+      const marked = { [Template.opaque]: true, a: 1 };
+      const jsFn = jest.fn();
+      template
+        .funcs({ jsFn, getMap: () => map, getMarked: () => marked })
+        .parse('{{ jsFn (getMap) }}{{ jsFn (getMarked) }}');
+      template.executeString();
+      expect(jsFn.mock.calls[0][0]).toBe(map);
+      expect(jsFn.mock.calls[1][0]).toBe(marked);
+      expect(HtmlTemplate.opaque).toBe(Template.opaque);
+    });
+
+    it('converts them for the template to read', () => {
+      class Price {
+        cents: number;
+
+        constructor(cents: number) {
+          this.cents = cents;
+        }
+
+        get dollars() {
+          return this.cents / 100;
+        }
+      }
+      template
+        .funcs({
+          getPrice: () => new Price(250),
+          getMap: () => new Map([['a', 1]]),
+        })
+        .parse(
+          '{{ (getPrice).dollars }} {{ getPrice }} ' +
+            '{{ with getPrice }}{{ .cents }}{{ end }} ' +
+            '{{ index (getMap) "a" }} {{ range $k, $v := getMap }}' +
+            '{{ $k }}={{ $v }}{{ end }}',
+      );
+      expect(template.executeString()).toBe(
+        '2.5 map[cents:250 dollars:2.5] 250 1 a=1',
+      );
+      template.conversionOptions({ lazy: true });
+      expect(template.executeString()).toBe(
+        '2.5 map[cents:250 dollars:2.5] 250 1 a=1',
+      );
+    });
+
+    it('only converts them if the template reads them in lazy mode', () => {
+      const broken = Object.defineProperty(new Decimal('1'), 'broken', {
+        enumerable: true,
+        get() {
+          throw new Error('getter error');
+        },
+      });
+      const jsFn = jest.fn(() => 'ok');
+      template
+        .funcs({ jsFn, getObj: () => broken })
+        .conversionOptions({ lazy: true })
+        .parse('{{ (getObj).value }} {{ getObj | jsFn }}');
+      expect(template.executeString()).toBe('1 ok');
+      template.parse('{{ getObj }}');
+      expect(() => template.executeString()).toThrow('getter error');
+    });
+
+    it('converts plain data and Dates', () => {
+      const date = new Date(0);
+      template
+        .funcs({ getObj: () => ({ a: 1 }), getDate: () => date })
+        .parse('{{ (getObj).a }} {{ (getDate).UnixMilli }}');
+      expect(template.executeString()).toBe('1 0');
+    });
+
+    it('works during async execution', async () => {
+      const jsFn = jest.fn();
+      const obj = new Decimal('1');
+      template.funcs({ jsFn, getObj: () => obj }).parse('{{ jsFn (getObj) }}');
+      await template.executeAsync();
+      expect(jsFn.mock.calls[0][0]).toBe(obj);
+    });
+  });
+
+  describe('JS functions in data', () => {
+    class Person {
+      first: string;
+      last: string;
+
+      constructor(first: string, last: string) {
+        this.first = first;
+        this.last = last;
+      }
+
+      get fullName() {
+        return `${this.first} ${this.last}`;
+      }
+
+      greet(greeting: string) {
+        return `${greeting}, ${this.first}`;
+      }
+    }
+
+    class Employee extends Person {
+      get role() {
+        return 'engineer';
+      }
+    }
+
+    it('can be invoked with call', () => {
+      template.parse('{{ call .double .x }} {{ call .nested.answer }}');
+      const data = {
+        x: 21,
+        double: (x: number) => x * 2,
+        nested: { answer: () => 42 },
+      };
+      expect(template.executeString(data)).toBe('42 42');
+    });
+
+    it('binds this to the owning object', () => {
+      template.parse('{{ call .obj.scaled 2 }}');
+      const obj = {
+        factor: 10,
+        scaled(x: number) {
+          return x * this.factor;
+        },
+      };
+      expect(template.executeString({ obj })).toBe('20');
+    });
+
+    it('exposes getters and methods of class instances', () => {
+      template.parse(
+        '{{ .p.fullName }} {{ call .p.greet "Hi" }} ' +
+          '{{ .e.role }} {{ .e.fullName }}',
+      );
+      const data = {
+        p: new Person('Ada', 'Lovelace'),
+        e: new Employee('Alan', 'Turing'),
+      };
+      expect(template.executeString(data)).toBe(
+        'Ada Lovelace Hi, Ada engineer Alan Turing',
+      );
+    });
+
+    it('only calls getters the template reads in lazy mode', () => {
+      const getter = jest.fn(() => 1);
+      class Lazy {
+        get value() {
+          return getter();
+        }
+        get broken(): number {
+          throw new Error('getter error');
+        }
+      }
+      template.conversionOptions({ lazy: true }).parse('hi');
+      expect(template.executeString({ l: new Lazy() })).toBe('hi');
+      template.parse('{{ .l.value }}');
+      expect(template.executeString({ l: new Lazy() })).toBe('1');
+      expect(getter).toHaveBeenCalledTimes(1);
+    });
+
+    it('converts class instances to maps with their getters and methods', () => {
+      const data = { p: new Person('Ada', 'Lovelace') };
+      const samePerson = (x: typeof data) => x.p === data.p;
+      template
+        .funcs({ samePerson })
+        .addSprigFuncs()
+        .parse(
+          '{{ printf "%T" .p }} {{ toJson .p }} {{ index .p "fullName" }} ' +
+            '{{ get .p "fullName" }} {{ hasKey .p "greet" }} ' +
+            '{{ samePerson . }} {{ .p }}',
+        );
+      expect(template.executeString(data)).toBe(
+        'map[string]interface {} ' +
+          '{"first":"Ada","fullName":"Ada Lovelace","greet":null,' +
+          '"last":"Lovelace"} Ada Lovelace Ada Lovelace true true ' +
+          'map[first:Ada fullName:Ada Lovelace greet:[Function] last:Lovelace]',
+      );
+    });
+
+    it('converts DataViews to byte slices', () => {
+      const view = new DataView(new Uint8Array([1, 2, 3, 4]).buffer, 1, 2);
+      template.parse('{{ printf "%T %v" .v .v }}');
+      expect(template.executeString({ v: view })).toBe('[]uint8 [2 3]');
+    });
+
+    it('leaves functions out of data passed back to JS', () => {
+      const keys = (obj: object) => Object.keys(obj).join();
+      template.funcs({ keys }).parse('{{ keys . }}');
+      expect(template.executeString({ x: 1, fn: () => 2 })).toBe('x');
+    });
+
+    it('exposes getters and methods in lazy mode', () => {
+      template.conversionOptions({ lazy: true });
+      template
+        .addSprigFuncs()
+        .parse(
+          '{{ .p.fullName }} {{ call .p.greet "Hi" }} ' +
+            '{{ get .p "fullName" }} {{ hasKey .p "greet" }}',
+        );
+      const data = { p: new Person('Ada', 'Lovelace') };
+      expect(template.executeString(data)).toBe(
+        'Ada Lovelace Hi, Ada Ada Lovelace true',
+      );
+    });
+
+    it('can be invoked during async execution', async () => {
+      template.parse('{{ call .p.greet "Hello" }}');
+      const data = { p: new Person('Ada', 'Lovelace') };
+      await expect(template.executeAsync(data)).resolves.toBe('Hello, Ada');
+    });
+  });
+});
+
+describe('HtmlTemplate', () => {
+  let template: HtmlTemplate;
+
+  beforeEach(() => {
+    template = new HtmlTemplate('test_template');
+  });
+
+  test('#addParseTree works', () => {
+    const tree = new Template('x').parse('<p>{{ . }}</p>').tree();
+    if (!tree) throw new Error('missing tree');
+    template.addParseTree('test_template', tree);
+    expect(template.executeString('<b>')).toBe('<p>&lt;b&gt;</p>');
+  });
+
+  test('#executeString escapes by context', () => {
+    template.parse('<a href="{{ .url }}">{{ .text }}</a>');
+    expect(
+      template.executeString({ url: 'javascript:void(0)', text: '<b>' }),
+    ).toBe('<a href="#ZgotmplZ">&lt;b&gt;</a>');
+  });
+
+  test('#executeAsync works', async () => {
+    const myFunc = jest.fn(() => '<i>');
+    template.funcs({ myFunc }).parse('{{ myFunc }}');
+    expect(template.executeString()).toBe('&lt;i&gt;');
+    // Executed html/template templates can't be cloned, so make sure this
+    // still works after the synchronous execution above.
+    await expect(template.executeAsync()).resolves.toBe('&lt;i&gt;');
+    expect(myFunc).toHaveBeenCalledTimes(2);
+  });
+
+  test('#clone works', () => {
+    const cloned = template.parse('{{ . }}').clone();
+    expect(cloned).toBeInstanceOf(HtmlTemplate);
+    expect(cloned.executeString('<br>')).toBe('&lt;br&gt;');
+  });
+
+  test('#lookup works', () => {
+    template.parse('{{ define "foo" }}{{ end }}');
+    const fooTemplate = template.lookup('foo');
+    expect(fooTemplate).toBeInstanceOf(HtmlTemplate);
+    expect(fooTemplate?.name()).toBe('foo');
+  });
+
+  test('#addSprigFuncs works', () => {
+    template.addSprigFuncs().parse('{{ upper "<br>" }}');
+    expect(template.executeString()).toBe('&lt;BR&gt;');
+  });
+
+  describe('trusted content', () => {
+    it('is not escaped', () => {
+      template.parse('<a href="{{ .url }}">{{ .html }}</a>');
+      expect(
+        template.executeString({
+          url: binding.trusted.url('javascript:void(0)'),
+          html: binding.trusted.html('<b>bold</b>'),
+        }),
+      ).toBe('<a href="javascript:void%280%29"><b>bold</b></a>');
+    });
+
+    it('can be returned from JS functions', () => {
+      const myFunc = () => binding.trusted.html('<br>');
+      template.funcs({ myFunc }).parse('{{ myFunc }}');
+      expect(template.executeString()).toBe('<br>');
+    });
+  });
+
+  test('static .parseArchive works', () => {
+    const archive = path.join(templateDir, 'archive.tar.gz');
+    const parsed = HtmlTemplate.parseArchive(archive, '*/*.tpl');
+    expect(parsed).toBeInstanceOf(HtmlTemplate);
+    expect(parsed.executeString('<br>')).toBe('[home &lt;br&gt;]');
+  });
+
+  test('static .deserialize works', () => {
+    const template = new HtmlTemplate('test_template').parse('<p>{{ . }}</p>');
+    expect(template.executeString('<br>')).toBe('<p>&lt;br&gt;</p>');
+    const loaded = HtmlTemplate.deserialize(template.serialize());
+    expect(loaded).toBeInstanceOf(HtmlTemplate);
+    expect(loaded.executeString('<br>')).toBe('<p>&lt;br&gt;</p>');
+
+    // Templates called in other contexts are escaped again once loaded
+    const attr = new HtmlTemplate('attr').parse(
+      '<p title="{{ template "sub" . }}">{{ define "sub" }}{{ . }}{{ end }}',
+    );
+    expect(attr.executeString('"')).toBe('<p title="&#34;">');
+    const loadedAttr = HtmlTemplate.deserialize(attr.serialize());
+    expect(loadedAttr.executeString('"')).toBe('<p title="&#34;">');
+  });
+
+  test('static .parseFS works', () => {
+    const parsed = HtmlTemplate.parseFS({ 'a.tpl': '{{ . }}' }, '*.tpl');
+    expect(parsed).toBeInstanceOf(HtmlTemplate);
+    expect(parsed.executeString('<br>')).toBe('&lt;br&gt;');
+  });
+
+  test('static .parseGlob works', () => {
+    const parsed = HtmlTemplate.parseGlob(path.join(templateDir, '*.tpl'));
+    expect(parsed).toBeInstanceOf(HtmlTemplate);
+    expect(parsed.executeTemplateString('b.tpl')).toBe('template b\n');
+  });
 });
 
 test('htmlEscapeString works', () => {
@@ -1,15 +1,15 @@
 import { describe, expect, jest } from '@jest/globals';
 import { fc, it } from '@fast-check/jest';
 
-import { Template } from '..';
+import { HtmlTemplate, Template, trusted } from '..';
 
 describe('JS-Go value conversion', () => {
   // TODO: Undefined
   const arbScalar = fc.oneof(
-    // TODO: re-enable when Go->JS side is implemented
-    // fc.bigInt(),
+    fc.bigInt(),
     fc.boolean(),
     fc.constant(null),
+    fc.date({ noInvalidDate: true }),
     fc.double(),
     fc.string(),
   );
@@ -28,4 +28,31 @@ describe('JS-Go value conversion', () => {
     template.executeString({ val });
     expect(jsFn).toHaveBeenCalledWith(val);
   });
+
+  it.prop([fc.constantFrom(...Object.values(trusted)), fc.string()])(
+    'should roundtrip trusted content',
+    (factory, str) => {
+      const val = factory(str);
+      const jsFn = jest.fn();
+      const template = new Template('t')
+        .funcs({ jsFn })
+        .parse('{{ jsFn .val }}');
+      template.executeString({ val });
+      expect(jsFn).toHaveBeenCalledWith(val);
+      // Equality alone would accept a plain object, so check that the value
+      // is still treated as trusted content
+      const [received] = jsFn.mock.calls[0] ?? [];
+      const html = new HtmlTemplate('h').parse('{{ . }}');
+      expect(html.executeString(received)).toBe(html.executeString(val));
+    },
+  );
+
+  it.prop([fc.string()])('should roundtrip trusted HTML unescaped', (str) => {
+    const jsFn = jest.fn();
+    const template = new Template('t').funcs({ jsFn }).parse('{{ jsFn .val }}');
+    template.executeString({ val: trusted.html(str) });
+    const [received] = jsFn.mock.calls[0] ?? [];
+    const html = new HtmlTemplate('h').parse('{{ . }}');
+    expect(html.executeString(received)).toBe(str);
+  });
 });
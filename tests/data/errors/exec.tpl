@@ -0,0 +1,2 @@
+Items:
+  {{ index .items 5 }}
@@ -0,0 +1,2 @@
+Hello,
+{{ if }}
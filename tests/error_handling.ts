@@ -1,13 +1,41 @@
-import { beforeEach, describe, expect, it, jest, test } from '@jest/globals';
+import {
+  afterEach,
+  beforeEach,
+  describe,
+  expect,
+  it,
+  jest,
+  test,
+} from '@jest/globals';
+import * as fs from 'fs';
+import * as os from 'os';
+import * as path from 'path';
+import { Writable } from 'stream';
 
 import * as binding from '..';
-import { Template } from '..';
+import {
+  HtmlTemplate,
+  Template,
+  TemplateExecError,
+  TemplateParseError,
+} from '..';
 
 const NO_FILE_ERR =
   process.platform === 'win32'
     ? 'cannot find the path specified'
     : 'no such file or directory';
 
+const errorDir = path.join(__dirname, 'data', 'errors');
+
+function catchError(fn: () => unknown): unknown {
+  try {
+    fn();
+  } catch (e) {
+    return e;
+  }
+  throw new Error('expected an error');
+}
+
 describe('Template', () => {
   let template: Template;
 
@@ -28,6 +56,8 @@ describe('Template', () => {
   });
 
   const unaryMethods = [
+    'addParseTree',
+    'executeTemplateAsync',
     'executeTemplateString',
     'lookup',
     'new',
@@ -35,6 +65,7 @@ describe('Template', () => {
     'parse',
     'parseFiles',
     'parseGlob',
+    'templateTree',
   ] as const;
   test.each(unaryMethods)('#%s handles incorrect argument types', (name) => {
     // @ts-expect-error: testing bad arguments
@@ -50,7 +81,288 @@ describe('Template', () => {
     },
   );
 
+  test('static .prepareData handles invalid options', () => {
+    // @ts-expect-error: testing bad arguments
+    expect(() => Template.prepareData({}, 42)).toThrow(
+      'Options must be an object',
+    );
+    expect(() => Template.prepareData(Symbol())).toThrow(
+      'Unsupported value type',
+    );
+  });
+
+  describe('#addParseTree', () => {
+    const action = (arg: unknown) => ({
+      root: {
+        type: 'List',
+        nodes: [
+          {
+            type: 'Action',
+            pipe: {
+              type: 'Pipe',
+              isAssign: false,
+              decl: [],
+              cmds: [{ type: 'Command', args: [arg] }],
+            },
+          },
+        ],
+      },
+    });
+
+    it('handles malformed trees', () => {
+      const addTree = (tree: unknown) => () =>
+        // @ts-expect-error: testing bad arguments
+        template.addParseTree('test_template', tree);
+      expect(addTree(null)).toThrow('invalid parse tree: expected an object');
+      expect(addTree({ root: [] })).toThrow('root: expected a node object');
+      expect(addTree({ root: { type: 'Text', text: '' } })).toThrow(
+        'root: expected a List node, got Text',
+      );
+      expect(addTree({ root: { type: 'List', nodes: [{}] } })).toThrow(
+        'root.nodes[0]: expected a string type property',
+      );
+      expect(addTree(action({ type: 'Field', ident: [] }))).toThrow(
+        'root.nodes[0].pipe.cmds[0].args[0]: expected a non-empty ident property',
+      );
+    });
+
+    it('rejects nodes that would change the tree structure', () => {
+      const addTree = (arg: unknown) => () =>
+        // @ts-expect-error: testing bad arguments
+        template.addParseTree('test_template', action(arg));
+      expect(addTree({ type: 'Text', text: '}}{{' })).toThrow(
+        'unexpected Text node in command',
+      );
+      expect(addTree({ type: 'Number', text: '1 }}{{ 2' })).toThrow(
+        'invalid number',
+      );
+      expect(addTree({ type: 'Identifier', ident: 'if' })).toThrow(
+        'invalid function name',
+      );
+      expect(addTree({ type: 'Field', ident: ['a b'] })).toThrow(
+        'expected an identifier',
+      );
+      expect(addTree({ type: 'Variable', ident: ['x'] })).toThrow(
+        'expected a variable name',
+      );
+    });
+
+    it('propagates parse errors', () => {
+      const tree = { root: { type: 'List', nodes: [{ type: 'Break' }] } };
+      // @ts-expect-error: testing bad arguments
+      expect(() => template.addParseTree('test_template', tree)).toThrow(
+        '{{break}} outside {{range}}',
+      );
+    });
+  });
+
+  test('#inferDataShape handles incorrect option types', () => {
+    // @ts-expect-error: testing bad arguments
+    expect(() => template.inferDataShape(0)).toThrow(
+      'Options must be an object',
+    );
+    // @ts-expect-error: testing bad arguments
+    expect(() => template.inferDataShape({ jsonSchema: 1 })).toThrow(
+      "Option 'jsonSchema' must be a boolean",
+    );
+  });
+
+  describe('#parse', () => {
+    it('throws TemplateParseError', () => {
+      const err = catchError(() => template.parse('ok\n{{ .a }} {{ foo }}'));
+      expect(err).toBeInstanceOf(TemplateParseError);
+      expect(err).toBeInstanceOf(Error);
+      expect(err).toMatchObject({
+        name: 'TemplateParseError',
+        message: 'template: test_template:2: function "foo" not defined',
+        code: 'ERR_TEMPLATE_PARSE',
+        templateName: 'test_template',
+        line: 2,
+        column: 12,
+        context: '{{ foo }}',
+      });
+    });
+
+    it('locates errors within actions', () => {
+      const err = catchError(() =>
+        template.delims('<<', '>>').parse('a << .a >>\n  << .b.c( >> b'),
+      );
+      expect(err).toMatchObject({
+        message: 'template: test_template:2: unexpected "(" in operand',
+        line: 2,
+        column: 9,
+        context: '<< .b.c( >>',
+      });
+      const unclosed = catchError(() => template.parse('a << .a'));
+      expect(unclosed).toMatchObject({ line: 1, column: 7, context: '<< .a' });
+    });
+  });
+
+  test('#parseFiles reports the file of parse errors', () => {
+    const file = path.join(errorDir, 'parse.tpl');
+    const err = catchError(() => template.parseFiles(file));
+    expect(err).toBeInstanceOf(TemplateParseError);
+    expect(err).toMatchObject({
+      templateName: 'parse.tpl',
+      file,
+      line: 2,
+      column: 6,
+      context: '{{ if }}',
+    });
+  });
+
+  describe('#conversionOptions', () => {
+    it('handles invalid options', () => {
+      // @ts-expect-error: testing bad arguments
+      expect(() => template.conversionOptions(42)).toThrow(
+        'Options must be an object',
+      );
+      // @ts-expect-error: testing bad arguments
+      expect(() => template.conversionOptions({ floatNumbers: 1 })).toThrow(
+        "Option 'floatNumbers' must be a boolean",
+      );
+      expect(() => template.conversionOptions({ maxDepth: -1 })).toThrow(
+        "Option 'maxDepth' must be a non-negative integer",
+      );
+      // @ts-expect-error: testing bad arguments
+      expect(() => template.conversionOptions({ maxProperties: '1' })).toThrow(
+        "Option 'maxProperties' must be a non-negative integer",
+      );
+      // @ts-expect-error: testing bad arguments
+      expect(() => template.conversionOptions({ toJSON: 'yes' })).toThrow(
+        "Option 'toJSON' must be a boolean",
+      );
+      // @ts-expect-error: testing bad arguments
+      expect(() => template.conversionOptions({ keepUndefined: 1 })).toThrow(
+        "Option 'keepUndefined' must be a boolean",
+      );
+    });
+  });
+
   describe('#executeString', () => {
+    it('throws TemplateExecError', () => {
+      template.parse('{{ with .a }}\n  {{ .b.c }}{{ end }}');
+      const err = catchError(() => template.executeString({ a: { b: 1 } }));
+      expect(err).toBeInstanceOf(TemplateExecError);
+      expect(err).toMatchObject({
+        name: 'TemplateExecError',
+        code: 'ERR_TEMPLATE_EXEC',
+        templateName: 'test_template',
+        line: 2,
+        column: 7,
+        context: '{{.b.c}}',
+        nodeText: '.b.c',
+      });
+    });
+
+    it('reports the file of execution errors', () => {
+      const file = path.join(errorDir, 'exec.tpl');
+      const err = catchError(() =>
+        Template.parseFiles(file).executeString({ items: [] }),
+      );
+      expect(err).toMatchObject({
+        templateName: 'exec.tpl',
+        file,
+        line: 2,
+        column: 5,
+        context: '{{index .items 5}}',
+        nodeText: 'index .items 5',
+      });
+    });
+
+    it('rethrows exceptions from JS functions unchanged', () => {
+      class CustomError extends Error {
+        extra = 42;
+      }
+      const err = new CustomError('test error');
+      template.funcs({
+        throwErr() {
+          throw err;
+        },
+      });
+      template.parse('Hello,\n{{ if true }}{{ throwErr 1 }}{{ end }}');
+      const thrown = catchError(() => template.executeString());
+      expect(thrown).toBe(err);
+      expect(thrown).toBeInstanceOf(CustomError);
+      expect(thrown).toMatchObject({ message: 'test error', extra: 42 });
+    });
+
+    it('rethrows non-Error exceptions from JS functions unchanged', () => {
+      const err = { code: 'CUSTOM' };
+      template.funcs({
+        throwErr() {
+          throw err;
+        },
+        throwStr() {
+          throw 'test error';
+        },
+      });
+      template.parse('{{ throwErr }}');
+      expect(catchError(() => template.executeString())).toBe(err);
+      template.parse('{{ throwStr }}');
+      expect(catchError(() => template.executeString())).toBe('test error');
+    });
+
+    it('locates errors reading the results of JS functions', () => {
+      const err = new Error('getter error');
+      class Box {
+        size = 1;
+
+        get value(): number {
+          throw err;
+        }
+      }
+      template.funcs({ getBox: () => new Box() });
+      template.conversionOptions({ lazy: true });
+      template.parse('{{ (getBox).value }}');
+      expect(catchError(() => template.executeString())).toBe(err);
+      template.parse('{{ (getBox).size.field }}');
+      expect(catchError(() => template.executeString())).toMatchObject({
+        message:
+          'template: test_template:1:4: executing "test_template" at ' +
+          "<getBox>: can't evaluate field field in type interface {}",
+        context: '{{(getBox).size.field}}',
+      });
+    });
+
+    it('rethrows exceptions from functions in data', () => {
+      const err = new Error('test error');
+      const data = {
+        obj: {
+          throwErr() {
+            throw err;
+          },
+        },
+      };
+      template.parse('{{ call .obj.throwErr }}');
+      expect(catchError(() => template.executeString(data))).toBe(err);
+    });
+
+    it('propagates exceptions from getters', () => {
+      class Throwing {
+        get value() {
+          throw new Error('getter error');
+        }
+      }
+      template.parse('{{ .value }}');
+      expect(() => template.executeString(new Throwing())).toThrow(
+        'getter error',
+      );
+    });
+
+    it('propagates exceptions from getters read lazily', () => {
+      const err = new Error('getter error');
+      const data = {
+        a: {
+          get b() {
+            throw err;
+          },
+        },
+      };
+      template.conversionOptions({ lazy: true }).parse('ok {{ .a.b }}');
+      expect(catchError(() => template.executeString(data))).toBe(err);
+    });
+
     it('handles unsupported value types', () => {
       expect(() => template.executeString(Symbol())).toThrow(
         'Unsupported value type',
@@ -63,6 +375,95 @@ describe('Template', () => {
       );
     });
 
+    it('rejects cyclic data', () => {
+      const parent: Record<string, unknown> = { children: [] };
+      (parent['children'] as unknown[]).push({ 'the parent': parent });
+      expect(() => template.executeString({ parent })).toThrow(
+        'Cannot convert cyclic structure: ' +
+          'value.parent.children[0]["the parent"] refers to value.parent',
+      );
+      const arr: unknown[] = [];
+      arr.push(arr);
+      expect(() => template.executeString(arr)).toThrow(TypeError);
+    });
+
+    it('enforces conversion limits', () => {
+      template.conversionOptions({ maxDepth: 2, maxProperties: 3 }).parse('ok');
+      expect(template.executeString({ a: { b: 1 } })).toBe('ok');
+      expect(() => template.executeString({ a: { b: { c: 1 } } })).toThrow(
+        'Cannot convert value.a.b: nested more than 2 levels deep',
+      );
+      expect(() => template.executeString([1, 2, 3, 4])).toThrow(
+        'Cannot convert value[3]: more than 3 properties in total',
+      );
+      expect(() => template.executeString([1, 2, 3, 4])).toThrow(RangeError);
+    });
+
+    it('abbreviates long paths in errors', () => {
+      template.parse('ok');
+      let deep: unknown = 'leaf';
+      for (let i = 0; i < 1001; i++) {
+        deep = { o: deep };
+      }
+      expect(() => template.executeString(deep)).toThrow(
+        'Cannot convert value.o.o.o.o….o.o.o.o: nested more than 1000 levels',
+      );
+      let arr: unknown[] = [];
+      const root = arr;
+      for (let i = 0; i < 20; i++) {
+        const next: unknown[] = [];
+        arr.push(next);
+        arr = next;
+      }
+      arr.push(root);
+      expect(() => template.executeString(root)).toThrow(
+        'Cannot convert cyclic structure: ' +
+          'value[0][0][0][0]…[0][0][0][0] refers to value',
+      );
+    });
+
+    it('propagates exceptions from toJSON and toGoValue', () => {
+      template.conversionOptions({ toJSON: true }).parse('ok');
+      const badJSON = {
+        toJSON() {
+          throw new Error('toJSON error');
+        },
+      };
+      expect(() => template.executeString({ badJSON })).toThrow('toJSON error');
+      const badGoValue = {
+        [Template.toGoValue]() {
+          throw new Error('toGoValue error');
+        },
+      };
+      expect(() => template.executeString({ badGoValue })).toThrow(
+        'toGoValue error',
+      );
+    });
+
+    it('limits replacement chains', () => {
+      class Endless {
+        toJSON() {
+          return new Endless();
+        }
+      }
+      template.conversionOptions({ toJSON: true, maxDepth: 10 }).parse('ok');
+      expect(() => template.executeString(new Endless())).toThrow(
+        'Cannot convert value: nested more than 10 levels deep',
+      );
+    });
+
+    it('rejects Maps with non-string keys', () => {
+      expect(() => template.executeString({ m: new Map([[1, 2]]) })).toThrow(
+        'Cannot convert value.m: Map keys must be strings',
+      );
+    });
+
+    it('rejects invalid dates', () => {
+      expect(() => template.executeString(new Date(NaN))).toThrow(
+        'Invalid Date',
+      );
+    });
+
     it('propagates errors from property accesses', () => {
       const err = new Error();
       const badObj = {};
@@ -93,6 +494,125 @@ describe('Template', () => {
     });
   });
 
+  describe('#executeJSON', () => {
+    it('handles invalid argument types', () => {
+      // @ts-expect-error: testing bad arguments
+      expect(() => template.executeJSON(42)).toThrow(
+        'Expected a string, Buffer, or Uint8Array',
+      );
+      // @ts-expect-error: testing bad arguments
+      expect(() => template.executeJSON(new Uint16Array(1))).toThrow(
+        'Expected a string, Buffer, or Uint8Array',
+      );
+    });
+
+    it('handles invalid JSON', () => {
+      template.parse('{{ . }}');
+      expect(() => template.executeJSON('{')).toThrow('invalid JSON data');
+      expect(() => template.executeJSON('{} []')).toThrow(
+        'unexpected data after top-level value',
+      );
+    });
+  });
+
+  describe('#executeAsync', () => {
+    it('handles unsupported value types', () => {
+      expect(() => template.executeAsync(Symbol())).toThrow(
+        'Unsupported value type',
+      );
+    });
+
+    it('rejects with execution errors', async () => {
+      template.parse('{{ .param }}').option('missingkey=error');
+      await expect(template.executeAsync({})).rejects.toThrow(
+        'map has no entry for key "param"',
+      );
+      await expect(template.executeAsync({})).rejects.toBeInstanceOf(
+        TemplateExecError,
+      );
+    });
+
+    it('rejects with exceptions from JS functions', async () => {
+      const err = new Error('test error');
+      template.funcs({
+        throwErr() {
+          throw err;
+        },
+      });
+      template.parse('{{ throwErr }}');
+      await expect(template.executeAsync()).rejects.toBe(err);
+    });
+
+    it('rejects with errors converting JS function results', async () => {
+      template.funcs({ badFunc: () => Symbol() }).parse('{{ badFunc }}');
+      await expect(template.executeAsync()).rejects.toThrow(
+        'Unsupported value type',
+      );
+    });
+  });
+
+  describe('#executeTemplateAsync', () => {
+    it('handles invalid template names', async () => {
+      await expect(template.executeTemplateAsync('invalid')).rejects.toThrow(
+        'no template "invalid"',
+      );
+    });
+  });
+
+  describe('#executeToStream', () => {
+    it('handles invalid destinations', () => {
+      // @ts-expect-error: testing bad arguments
+      expect(() => template.executeToStream(42)).toThrow(
+        'Expected a writable stream or a function',
+      );
+      // @ts-expect-error: testing bad arguments
+      expect(() => template.executeToStream({})).toThrow(
+        'Expected a writable stream or a function',
+      );
+    });
+
+    it('rejects with stream errors', async () => {
+      const err = new Error('write failed');
+      const stream = new Writable({
+        write(_chunk, _encoding, callback) {
+          callback(err);
+        },
+      });
+      stream.on('error', () => {});
+      template.parse('output');
+      await expect(template.executeToStream(stream)).rejects.toBe(err);
+    });
+
+    it('rejects with errors from writes it did not wait for', async () => {
+      const err = new Error('write failed');
+      let writes = 0;
+      const stream = new Writable({
+        highWaterMark: 1024 * 1024 * 1024,
+        write(_chunk, _encoding, callback) {
+          const result = ++writes === 1 ? err : null;
+          setTimeout(() => callback(result), 5);
+        },
+      });
+      stream.on('error', () => {});
+      template.parse('{{ range . }}{{ . }}{{ end }}');
+      const data = Array.from({ length: 10000 }, (_, i) => `line ${i}\n`);
+      await expect(template.executeToStream(stream, data)).rejects.toBe(err);
+    });
+
+    it('rejects with callback errors', async () => {
+      const err = new Error('callback failed');
+      template.parse('output');
+      await expect(
+        template.executeToStream(() => {
+          throw err;
+        }),
+      ).rejects.toBe(err);
+      await expect(
+        template.executeToStream(() => Promise.reject(err)),
+      ).rejects.toBe(err);
+    });
+  });
+
   describe('#executeTemplateString', () => {
     it('handles unsupported value types', () => {
       expect(() => template.executeTemplateString('', Symbol())).toThrow(
@@ -186,6 +706,101 @@ describe('Template', () => {
     );
   });
 
+  describe('#parseArchive', () => {
+    it('propagates errors', () => {
+      expect(() => template.parseArchive(Buffer.from('junk'), '*')).toThrow(
+        "can't read archive",
+      );
+      const archive = path.join(errorDir, 'archive.zip');
+      expect(() => template.parseArchive(archive, 'x/*')).toThrow(
+        'pattern matches no files',
+      );
+      expect(() => template.parseArchive('/invalid/archive.zip', '*')).toThrow(
+        NO_FILE_ERR,
+      );
+    });
+
+    it('limits the size of files', () => {
+      // A tar header for a file claiming to be larger than the limit
+      const header = Buffer.alloc(512);
+      header.write('big.tpl', 0);
+      header.write('0000644\0', 100);
+      header.write((64 << 20).toString(8).padStart(11, '0') + '\0', 124);
+      header.write('00000000000\0', 136);
+      header.write('0', 156);
+      header.write('ustar\x0000', 257);
+      header.write('        ', 148);
+      const sum = header.reduce((acc, b) => acc + b, 0);
+      header.write(sum.toString(8).padStart(6, '0') + '\0 ', 148);
+      expect(() => template.parseArchive(header, '*')).toThrow(
+        'file in archive is too large: "big.tpl"',
+      );
+    });
+
+    it('reports the path of parse errors', () => {
+      const archive = path.join(errorDir, 'archive.zip');
+      const err = catchError(() => template.parseArchive(archive, 'bad/*'));
+      expect(err).toBeInstanceOf(TemplateParseError);
+      expect(err).toMatchObject({
+        templateName: 'bad/parse.tpl',
+        file: 'bad/parse.tpl',
+        line: 2,
+        column: 6,
+        context: '{{ if }}',
+      });
+    });
+  });
+
+  describe('#parseFS', () => {
+    it('handles invalid sources', () => {
+      // @ts-expect-error: testing bad arguments
+      expect(() => template.parseFS(0, '*')).toThrow(
+        'Expected an object mapping paths to contents, or a loader',
+      );
+      expect(() => template.parseFS({ '../a.tpl': '' }, '*')).toThrow(
+        "Invalid template path '../a.tpl'",
+      );
+      // @ts-expect-error: testing bad arguments
+      expect(() => template.parseFS({ 'a.tpl': 0 }, '*')).toThrow(
+        'Expected a string, Buffer, or Uint8Array',
+      );
+    });
+
+    it('propagates errors', () => {
+      expect(() => template.parseFS({}, '*.tpl')).toThrow(
+        'pattern matches no files',
+      );
+      const err = new Error('loader error');
+      const readFile = () => {
+        throw err;
+      };
+      expect(() => template.parseFS({ readFile }, 'a.tpl')).toThrow(err);
+      const enoent = Object.assign(new Error('ENOENT'), { code: 'ENOENT' });
+      const readMissing = () => {
+        throw enoent;
+      };
+      expect(() =>
+        template.parseFS({ readFile: readMissing }, 'a.tpl'),
+      ).toThrow('pattern matches no files');
+      expect(() => template.parseFS({ readFile }, '*.tpl')).toThrow(
+        'readDir .: unsupported operation',
+      );
+    });
+
+    it('reports the path of parse errors', () => {
+      const source = { 'dir/parse.tpl': 'ok\n{{ if }}' };
+      const err = catchError(() => template.parseFS(source, 'dir/*.tpl'));
+      expect(err).toBeInstanceOf(TemplateParseError);
+      expect(err).toMatchObject({
+        templateName: 'parse.tpl',
+        file: 'dir/parse.tpl',
+        line: 2,
+        column: 6,
+        context: '{{ if }}',
+      });
+    });
+  });
+
   test('static .parseFiles propagates errors', () => {
     expect(() => Template.parseFiles('/invalid/path/to/template/file')).toThrow(
       NO_FILE_ERR,
@@ -198,6 +813,114 @@ describe('Template', () => {
     );
   });
 
+  describe('static .deserialize', () => {
+    it('requires the functions the templates use', () => {
+      template.funcs({ a: () => 'a', b: () => 'b', c: () => 'c' });
+      template.parse('{{ a }}{{ template "sub" }}{{ len "" }}');
+      template.new('sub').parse('{{ b | c }}');
+      const buf = template.serialize();
+      const err = 'serialized templates require missing functions: "a", "c"';
+      expect(() => Template.deserialize(buf, { b: () => 'b' })).toThrow(err);
+      // @ts-expect-error: testing bad arguments
+      expect(() => Template.deserialize(buf, { a: 0, b: () => 'b' })).toThrow(
+        'missing functions: "a", "c"',
+      );
+      expect(() => Template.deserialize(buf)).toThrow(
+        'missing functions: "a", "b", "c"',
+      );
+    });
+
+    it('reports execution errors at the original positions', () => {
+      template.parse('a\n  {{ .x.y }}');
+      const loaded = Template.deserialize(template.serialize());
+      const err = catchError(() => loaded.executeString({ x: 1 }));
+      expect(err).toBeInstanceOf(TemplateExecError);
+      expect(err).toMatchObject({ line: 2, column: 7, context: '{{.x.y}}' });
+    });
+
+    it('handles invalid arguments', () => {
+      expect(() => Template.deserialize(Buffer.from('{}'))).toThrow(
+        'invalid serialized templates',
+      );
+      const valid = template.parse('x').serialize();
+      const truncated = valid.subarray(0, valid.length - 4);
+      expect(() => Template.deserialize(truncated)).toThrow(
+        'invalid serialized templates',
+      );
+      expect(() => HtmlTemplate.deserialize(valid)).toThrow(
+        'serialized templates are for Template, not HtmlTemplate',
+      );
+      // @ts-expect-error: testing bad arguments
+      expect(() => Template.deserialize(valid, 0)).toThrow(
+        'Expected an object mapping names to functions',
+      );
+    });
+  });
+
+  describe('static .watch', () => {
+    let dir: string;
+    let watched: Template | undefined;
+
+    beforeEach(() => {
+      dir = fs.mkdtempSync(path.join(os.tmpdir(), 'go-text-template-napi-'));
+      fs.writeFileSync(path.join(dir, 'a.tpl'), 'a');
+    });
+
+    afterEach(() => {
+      watched?.close();
+      watched = undefined;
+      fs.rmSync(dir, { recursive: true });
+    });
+
+    it('handles invalid arguments', () => {
+      const noMatch = catchError(() => Template.watch(path.join(dir, '*.txt')));
+      expect(noMatch).toBeInstanceOf(TemplateParseError);
+      expect((noMatch as Error).message).toContain('pattern matches no files');
+      const glob = path.join(dir, '*.tpl');
+      expect(() => Template.watch(glob, { interval: 0 })).toThrow(
+        "Option 'interval' must be a positive integer",
+      );
+      // @ts-expect-error: testing bad arguments
+      expect(() => Template.watch(glob, { funcs: 0 })).toThrow(
+        "Option 'funcs' must be an object",
+      );
+      expect(() => template.on('reload', () => {})).toThrow(
+        'Template is not being watched',
+      );
+      watched = Template.watch(glob);
+      // @ts-expect-error: testing bad arguments
+      expect(() => watched?.on('change', () => {})).toThrow(
+        "Unknown event 'change'",
+      );
+      // @ts-expect-error: testing bad arguments
+      expect(() => watched?.on('error', 0)).toThrow('Expected a function');
+    });
+
+    it('emits parse errors and keeps the previous templates', async () => {
+      const t = Template.watch(path.join(dir, '*.tpl'), { interval: 10 });
+      watched = t;
+      const failed = new Promise((resolve) => t.on('error', resolve));
+      fs.writeFileSync(path.join(dir, 'a.tpl'), 'new a\n{{ if }}');
+      const err = await failed;
+      expect(err).toBeInstanceOf(TemplateParseError);
+      expect(err).toMatchObject({
+        templateName: 'a.tpl',
+        file: path.join(dir, 'a.tpl'),
+        line: 2,
+      });
+      expect(t.executeString()).toBe('a');
+    });
+
+    it('emits parse errors as warnings without error listeners', async () => {
+      const t = Template.watch(path.join(dir, '*.tpl'), { interval: 10 });
+      watched = t;
+      const warned = new Promise((resolve) => process.once('warning', resolve));
+      fs.writeFileSync(path.join(dir, 'a.tpl'), '{{ if }}');
+      expect(await warned).toBeInstanceOf(TemplateParseError);
+      expect(t.executeString()).toBe('a');
+    });
+  });
+
   test('methods handle missing arguments', () => {
     // @ts-expect-error: testing missing argument
     expect(() => template.parse()).toThrow('A string was expected');
@@ -209,12 +932,48 @@ describe('Template', () => {
     expect(() => unwrapped.parse('')).toThrow('missing or invalid type tag');
   });
 
+  test('methods reject the wrong template class', () => {
+    const htmlTemplate = new HtmlTemplate('test_template');
+    // V8 checks the receiver against the class before the type tag is read
+    expect(() => Template.prototype.parse.call(htmlTemplate, '')).toThrow(
+      'Illegal invocation',
+    );
+  });
+
   test('static methods handle missing arguments', () => {
     // @ts-expect-error: testing missing argument
     expect(() => Template.parseGlob()).toThrow('A string was expected');
   });
 });
 
+describe('HtmlTemplate', () => {
+  test('#executeString throws TemplateExecError for escaping errors', () => {
+    const template = new HtmlTemplate('test_template').parse(
+      '<a href="{{ . }}',
+    );
+    const err = catchError(() => template.executeString(''));
+    expect(err).toBeInstanceOf(TemplateExecError);
+    expect(err).toMatchObject({ templateName: 'test_template' });
+  });
+
+  test('#parse fails after execution', () => {
+    const template = new HtmlTemplate('test_template').parse('');
+    template.executeString();
+    expect(() => template.parse('')).toThrow('cannot Parse after Execute');
+  });
+});
+
+test('error classes can be constructed', () => {
+  const err = new TemplateParseError('message');
+  expect(err).toBeInstanceOf(Error);
+  expect(err).toMatchObject({
+    name: 'TemplateParseError',
+    message: 'message',
+    code: 'ERR_TEMPLATE_PARSE',
+  });
+  expect(String(err)).toBe('TemplateParseError: message');
+});
+
 test('helpers handle unsupported value types', () => {
   expect(() => binding.htmlEscaper(Symbol())).toThrow('Unsupported value type');
 });
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+// trustedContent is wrapped by JS objects that mark a string as safe to include
+// in the output of an html/template without escaping.
+type trustedContent struct {
+	value any
+}
+
+var trustedWrapper = napi.NewSafeWrapper[trustedContent](0x3d9b7f1c0a6e4852, 0x91c4e07b5f2a3d68)
+
+// trustedKinds maps the names of the factory functions in the JS trusted
+// object to constructors for the corresponding html/template types.
+var trustedKinds = map[string]func(string) any{
+	"css":      func(s string) any { return htmltemplate.CSS(s) },
+	"html":     func(s string) any { return htmltemplate.HTML(s) },
+	"htmlAttr": func(s string) any { return htmltemplate.HTMLAttr(s) },
+	"js":       func(s string) any { return htmltemplate.JS(s) },
+	"jsStr":    func(s string) any { return htmltemplate.JSStr(s) },
+	"srcset":   func(s string) any { return htmltemplate.Srcset(s) },
+	"url":      func(s string) any { return htmltemplate.URL(s) },
+}
+
+// goTrustedKind returns the trustedKinds name for value, if it has one of the
+// html/template trusted content types.
+func goTrustedKind(value any) (string, string, bool) {
+	switch v := value.(type) {
+	case htmltemplate.CSS:
+		return "css", string(v), true
+	case htmltemplate.HTML:
+		return "html", string(v), true
+	case htmltemplate.HTMLAttr:
+		return "htmlAttr", string(v), true
+	case htmltemplate.JS:
+		return "js", string(v), true
+	case htmltemplate.JSStr:
+		return "jsStr", string(v), true
+	case htmltemplate.Srcset:
+		return "srcset", string(v), true
+	case htmltemplate.URL:
+		return "url", string(v), true
+	default:
+		return "", "", false
+	}
+}
+
+func trustedFinalize(env napi.Env, data interface{}) error {
+	return nil
+}
+
+func createTrustedObject(env napi.Env, kind string, str string) (napi.Value, error) {
+	ctor, ok := trustedKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown trusted content type %s", kind)
+	}
+	obj, err := env.CreateObject()
+	if err != nil {
+		return nil, err
+	}
+	props := map[string]string{"type": kind, "value": str}
+	propDescs := make([]napi.PropertyDescriptor, 0, len(props))
+	for name, value := range props {
+		nameValue, err := env.CreateString(name)
+		if err != nil {
+			return nil, err
+		}
+		propValue, err := env.CreateString(value)
+		if err != nil {
+			return nil, err
+		}
+		propDescs = append(propDescs, napi.PropertyDescriptor{
+			Name:       nameValue,
+			Value:      propValue,
+			Attributes: napi.Enumerable,
+		})
+	}
+	if err := env.DefineProperties(obj, propDescs); err != nil {
+		return nil, err
+	}
+	if err := trustedWrapper.Wrap(env, obj, &trustedContent{ctor(str)}, trustedFinalize); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func buildTrustedObject(env napi.Env, name string) (napi.Value, error) {
+	obj, err := env.CreateObject()
+	if err != nil {
+		return nil, err
+	}
+	var propDescs []napi.PropertyDescriptor
+	for kind := range trustedKinds {
+		fn, err := makeHelperBuilder(func(env napi.Env, args []napi.Value) (napi.Value, error) {
+			str, err := jsStringToGo(env, args[0])
+			if err != nil {
+				return nil, err
+			}
+			return createTrustedObject(env, kind, str)
+		}, 1)(env, kind)
+		if err != nil {
+			return nil, err
+		}
+		nameValue, err := env.CreateString(kind)
+		if err != nil {
+			return nil, err
+		}
+		propDescs = append(propDescs, napi.PropertyDescriptor{
+			Name:       nameValue,
+			Value:      fn,
+			Attributes: napi.Enumerable,
+		})
+	}
+	if err := env.DefineProperties(obj, propDescs); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
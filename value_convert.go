@@ -2,11 +2,19 @@ package main
 
 import (
 	"bytes"
+	"encoding"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"math"
 	"math/big"
 	"reflect"
+	"regexp"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
 
 	"github.com/drakedevel/go-text-template-napi/internal/napi"
 )
@@ -45,6 +53,65 @@ func jsBigintToGo(env napi.Env, value napi.Value) (*big.Int, error) {
 	return result, nil
 }
 
+func jsDateToGo(env napi.Env, value napi.Value) (time.Time, error) {
+	msec, err := env.GetDateValue(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if math.IsNaN(msec) {
+		err = env.ThrowTypeError("ERR_INVALID_ARG_VALUE", "Invalid Date")
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Time{}, fmt.Errorf("threw exception")
+	}
+	return time.UnixMilli(int64(msec)), nil
+}
+
+// jsTypedArrayToGo copies a typed array to a Go slice with the same element
+// type. Uint8Arrays, including Buffers, become []byte.
+func jsTypedArrayToGo(env napi.Env, value napi.Value) (interface{}, error) {
+	arrType, data, err := env.GetTypedArrayInfo(value)
+	if err != nil {
+		return nil, err
+	}
+	switch arrType {
+	case napi.Int8Array:
+		return copyTypedArray[int8](data), nil
+	case napi.Uint8Array, napi.Uint8ClampedArray:
+		return copyTypedArray[byte](data), nil
+	case napi.Int16Array:
+		return copyTypedArray[int16](data), nil
+	case napi.Uint16Array:
+		return copyTypedArray[uint16](data), nil
+	case napi.Int32Array:
+		return copyTypedArray[int32](data), nil
+	case napi.Uint32Array:
+		return copyTypedArray[uint32](data), nil
+	case napi.Float32Array:
+		return copyTypedArray[float32](data), nil
+	case napi.Float64Array:
+		return copyTypedArray[float64](data), nil
+	case napi.BigInt64Array:
+		return copyTypedArray[int64](data), nil
+	case napi.BigUint64Array:
+		return copyTypedArray[uint64](data), nil
+	default:
+		return nil, fmt.Errorf("unknown typed array type %d", arrType)
+	}
+}
+
+// copyTypedArray copies the contents of a typed array, which are aligned for
+// their element type, to a new slice.
+func copyTypedArray[T any](data []byte) []T {
+	if len(data) == 0 {
+		return []T{}
+	}
+	var zero T
+	elems := unsafe.Slice((*T)(unsafe.Pointer(unsafe.SliceData(data))), len(data)/int(unsafe.Sizeof(zero)))
+	return slices.Clone(elems)
+}
+
 func jsStringToGo(env napi.Env, value napi.Value) (string, error) {
 	// Get string length
 	strLen, err := env.GetValueString(value, nil)
@@ -61,27 +128,672 @@ func jsStringToGo(env napi.Env, value napi.Value) (string, error) {
 	return string(buf[0:strLen]), nil
 }
 
+// maxSafeInteger is the largest integer that JS numbers can represent exactly,
+// Number.MAX_SAFE_INTEGER.
+const maxSafeInteger = 1<<53 - 1
+
+// conversionOptions controls how JS values are converted to Go values.
+type conversionOptions struct {
+	// floatNumbers converts all numbers to float64, instead of converting
+	// integers in the safe range to int64.
+	floatNumbers bool
+
+	// maxDepth limits how deeply objects and arrays may be nested.
+	maxDepth int
+
+	// maxProperties limits the total number of object properties and array
+	// elements converted.
+	maxProperties int
+
+	// lazy converts only the parts of a template's data that it reads,
+	// according to inferDataShape. It doesn't affect other conversions.
+	lazy bool
+
+	// toJSON converts objects with a toJSON method using its result, like
+	// JSON.stringify.
+	toJSON bool
+
+	// keepUndefined converts object properties and Map entries with undefined
+	// values to nil map entries, instead of omitting them.
+	keepUndefined bool
+}
+
+var defaultConversionOptions = conversionOptions{
+	maxDepth:      1000,
+	maxProperties: 10_000_000,
+}
+
+// getConversionOptions parses a JS options object for conversionOptions.
+// Options that aren't given have their default values.
+func getConversionOptions(env napi.Env, options napi.Value) (conversionOptions, error) {
+	result := defaultConversionOptions
+	var err error
+	if result.floatNumbers, err = getBoolOption(env, options, "floatNumbers"); err != nil {
+		return conversionOptions{}, err
+	}
+	if result.lazy, err = getBoolOption(env, options, "lazy"); err != nil {
+		return conversionOptions{}, err
+	}
+	if result.toJSON, err = getBoolOption(env, options, "toJSON"); err != nil {
+		return conversionOptions{}, err
+	}
+	if result.keepUndefined, err = getBoolOption(env, options, "keepUndefined"); err != nil {
+		return conversionOptions{}, err
+	}
+	if result.maxDepth, err = getIntOption(env, options, "maxDepth", result.maxDepth); err != nil {
+		return conversionOptions{}, err
+	}
+	if result.maxProperties, err = getIntOption(env, options, "maxProperties", result.maxProperties); err != nil {
+		return conversionOptions{}, err
+	}
+	return result, nil
+}
+
+func jsNumberToGo(env napi.Env, value napi.Value, opts conversionOptions) (interface{}, error) {
+	num, err := env.GetValueDouble(value)
+	if err != nil {
+		return nil, err
+	}
+	return opts.numberToGo(num), nil
+}
+
+// numberToGo converts a JS number to int64 if it's an integer in the safe
+// range, or float64 otherwise.
+func (opts conversionOptions) numberToGo(num float64) interface{} {
+	// Negative zero is kept as a float so it round-trips
+	isInt := math.Trunc(num) == num && math.Abs(num) <= maxSafeInteger && !(num == 0 && math.Signbit(num))
+	if isInt && !opts.floatNumbers {
+		return int64(num)
+	}
+	return num
+}
+
+// jsValueToGo converts a JS value to Go with the default conversion options.
 func jsValueToGo(env napi.Env, value napi.Value) (interface{}, error) {
+	return defaultConversionOptions.jsValueToGo(env, value)
+}
+
+func (opts conversionOptions) jsValueToGo(env napi.Env, value napi.Value) (interface{}, error) {
+	conv := jsConverter{opts: opts}
+	return conv.toGo(env, value)
+}
+
+// dataToGo converts the data for executing a template. Instances of classes,
+// and in lazy mode all objects and arrays, are instead wrapped in lazy values,
+// which are filled in as the template reads them. The returned lazyData tracks
+// them, and must be released once the execution finishes.
+func (opts conversionOptions) dataToGo(env napi.Env, value napi.Value) (interface{}, *lazyData, error) {
+	modData, err := getInstanceData(env)
+	if err != nil {
+		return nil, nil, err
+	}
+	conv := jsConverter{opts: opts, data: &lazyData{es: &modData.envStack, opts: opts}}
+	result, err := conv.toGo(env, value)
+	if err != nil {
+		// Swallow errors here since we can't do anything about them
+		_ = conv.data.Release(env)
+		return nil, nil, err
+	}
+	conv.data.properties = conv.properties
+	return result, conv.data, nil
+}
+
+// pathKey is the property name or array index of a value within its parent.
+type pathKey struct {
+	name    string
+	index   uint32
+	isIndex bool
+}
+
+var identRe = regexp.MustCompile(`^[A-Za-z_$][0-9A-Za-z_$]*$`)
+
+// formatPathKeys is the number of keys formatPath shows at each end of a long
+// path.
+const formatPathKeys = 4
+
+// formatPath formats a path through a value in JS syntax. The middle of a long
+// path is elided, so deeply nested values still give readable messages.
+func formatPath(keys []pathKey) string {
+	var sb strings.Builder
+	sb.WriteString("value")
+	for i, key := range keys {
+		if len(keys) > 2*formatPathKeys+1 && i >= formatPathKeys && i < len(keys)-formatPathKeys {
+			if i == formatPathKeys {
+				sb.WriteString("…")
+			}
+			continue
+		}
+		switch {
+		case key.isIndex:
+			fmt.Fprintf(&sb, "[%d]", key.index)
+		case identRe.MatchString(key.name):
+			sb.WriteString("." + key.name)
+		default:
+			sb.WriteString("[" + strconv.Quote(key.name) + "]")
+		}
+	}
+	return sb.String()
+}
+
+// jsConverter holds the state of a single conversion of a JS value to Go.
+type jsConverter struct {
+	opts conversionOptions
+
+	// data is set if objects and arrays should be wrapped in lazy values
+	// instead of being converted. Outside of lazy mode, only instances of
+	// classes are. If deep is set, values are converted in full, and
+	// instances of classes get lazy values that are already filled in.
+	data *lazyData
+	deep bool
+
+	// ancestors holds the objects containing the value being converted,
+	// outermost first, and keys holds the path from the root to it. When
+	// filling in a lazy value, the ancestors start from it rather than the
+	// root, and base is the length of its path.
+	ancestors []napi.Value
+	keys      []pathKey
+	base      int
+
+	properties int
+
+	// collectionCons caches the Map and Set constructors, once needed.
+	collectionCons []napi.Value
+	// objectProto caches Object.prototype, once needed.
+	objectProto napi.Value
+	// toGoValue caches the toGoValue symbol, once needed.
+	toGoValue napi.Value
+}
+
+func throwConversionError(env napi.Env, isRange bool, msg string) error {
+	var err error
+	if isRange {
+		err = env.ThrowRangeError("ERR_INVALID_ARG_VALUE", msg)
+	} else {
+		err = env.ThrowTypeError("ERR_INVALID_ARG_VALUE", msg)
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("threw exception")
+}
+
+// checkDepth checks that the object at the current path can be converted
+// without exceeding the depth limit.
+func (jc *jsConverter) checkDepth(env napi.Env, depth int) error {
+	if depth >= jc.opts.maxDepth {
+		msg := fmt.Sprintf("Cannot convert %s: nested more than %d levels deep", formatPath(jc.keys), jc.opts.maxDepth)
+		return throwConversionError(env, true, msg)
+	}
+	return nil
+}
+
+// enter checks that the object value can be converted without exceeding the
+// depth limit or recursing infinitely, and adds it to the ancestors.
+func (jc *jsConverter) enter(env napi.Env, value napi.Value) error {
+	for i, ancestor := range jc.ancestors {
+		same, err := env.StrictEquals(ancestor, value)
+		if err != nil {
+			return err
+		}
+		if same {
+			msg := fmt.Sprintf("Cannot convert cyclic structure: %s refers to %s", formatPath(jc.keys), formatPath(jc.keys[:jc.base+i]))
+			return throwConversionError(env, false, msg)
+		}
+	}
+	if err := jc.checkDepth(env, jc.base+len(jc.ancestors)); err != nil {
+		return err
+	}
+	jc.ancestors = append(jc.ancestors, value)
+	return nil
+}
+
+func (jc *jsConverter) exit() {
+	jc.ancestors = jc.ancestors[:len(jc.ancestors)-1]
+}
+
+// child converts the property or element of the current object with the
+// given key.
+func (jc *jsConverter) child(env napi.Env, key pathKey, value napi.Value) (interface{}, error) {
+	jc.keys = append(jc.keys, key)
+	defer func() { jc.keys = jc.keys[:len(jc.keys)-1] }()
+	jc.properties++
+	if jc.properties > jc.opts.maxProperties {
+		msg := fmt.Sprintf("Cannot convert %s: more than %d properties in total", formatPath(jc.keys), jc.opts.maxProperties)
+		return nil, throwConversionError(env, true, msg)
+	}
+	return jc.toGo(env, value)
+}
+
+// collectionKind reports whether value is a Map or a Set.
+func (jc *jsConverter) collectionKind(env napi.Env, value napi.Value) (bool, bool, error) {
+	if jc.collectionCons == nil {
+		global, err := env.GetGlobal()
+		if err != nil {
+			return false, false, err
+		}
+		for _, name := range []string{"Map", "Set"} {
+			cons, err := env.GetNamedProperty(global, name)
+			if err != nil {
+				return false, false, err
+			}
+			jc.collectionCons = append(jc.collectionCons, cons)
+		}
+	}
+	isMap, err := env.InstanceOf(value, jc.collectionCons[0])
+	if err != nil || isMap {
+		return isMap, false, err
+	}
+	isSet, err := env.InstanceOf(value, jc.collectionCons[1])
+	return false, isSet, err
+}
+
+// toGoValueSymbol returns the symbol classes can implement to control how
+// their instances are converted. It's registered globally so it's shared by all
+// copies of the module.
+func toGoValueSymbol(env napi.Env) (napi.Value, error) {
+	key, err := env.CreateString("go-text-template-napi.toGoValue")
+	if err != nil {
+		return nil, err
+	}
+	return callGlobalMethod(env, "Symbol", "for", key)
+}
+
+// replacementMethod returns the method that gives the value to convert in
+// place of the object value, if it implements the toGoValue symbol, or toJSON
+// if that's enabled. Otherwise, it returns nil.
+func (jc *jsConverter) replacementMethod(env napi.Env, value napi.Value) (napi.Value, error) {
+	if jc.toGoValue == nil {
+		toGoValue, err := toGoValueSymbol(env)
+		if err != nil {
+			return nil, err
+		}
+		jc.toGoValue = toGoValue
+	}
+	method, err := env.GetProperty(value, jc.toGoValue)
+	if err != nil {
+		return nil, err
+	}
+	methodType, err := env.Typeof(method)
+	if err != nil {
+		return nil, err
+	}
+	if methodType != napi.Function && jc.opts.toJSON {
+		if method, err = env.GetNamedProperty(value, "toJSON"); err != nil {
+			return nil, err
+		}
+		if methodType, err = env.Typeof(method); err != nil {
+			return nil, err
+		}
+	}
+	if methodType != napi.Function {
+		return nil, nil
+	}
+	return method, nil
+}
+
+// replacement calls a method returned by replacementMethod. Like
+// JSON.stringify, the method is passed the key of the value as a string.
+func (jc *jsConverter) replacement(env napi.Env, value napi.Value, method napi.Value) (napi.Value, error) {
+	var keyStr string
+	if len(jc.keys) > 0 {
+		key := jc.keys[len(jc.keys)-1]
+		if key.isIndex {
+			keyStr = strconv.FormatUint(uint64(key.index), 10)
+		} else {
+			keyStr = key.name
+		}
+	}
+	key, err := env.CreateString(keyStr)
+	if err != nil {
+		return nil, err
+	}
+	return env.CallFunction(value, method, []napi.Value{key})
+}
+
+// omitted reports whether the property or Map entry with the given value should
+// be left out of the converted map.
+func (jc *jsConverter) omitted(env napi.Env, value napi.Value) (bool, error) {
+	if jc.opts.keepUndefined {
+		return false, nil
+	}
+	valueType, err := env.Typeof(value)
+	return valueType == napi.Undefined, err
+}
+
+// ownPropertyNames returns the names of the properties of value that are
+// converted, which are its own properties other than symbols.
+func ownPropertyNames(env napi.Env, value napi.Value) ([]string, error) {
+	propNames, err := env.GetAllPropertyNames(value, napi.KeyOwnOnly, napi.KeySkipSymbols, napi.KeyNumbersToStrings)
+	if err != nil {
+		return nil, err
+	}
+	length, err := env.GetArrayLength(propNames)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, length)
+	for i := range length {
+		// TODO: Scope?
+		key, err := env.GetElement(propNames, i)
+		if err != nil {
+			return nil, err
+		}
+		if names[i], err = jsStringToGo(env, key); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// objectPrototype returns Object.prototype.
+func (jc *jsConverter) objectPrototype(env napi.Env) (napi.Value, error) {
+	if jc.objectProto == nil {
+		global, err := env.GetGlobal()
+		if err != nil {
+			return nil, err
+		}
+		objectCons, err := env.GetNamedProperty(global, "Object")
+		if err != nil {
+			return nil, err
+		}
+		if jc.objectProto, err = env.GetNamedProperty(objectCons, "prototype"); err != nil {
+			return nil, err
+		}
+	}
+	return jc.objectProto, nil
+}
+
+// classPrototypes returns the prototypes of the class of value, and of the
+// classes it extends, which hold its getters and methods. Plain objects have
+// none, since properties inherited from Object.prototype are skipped.
+func (jc *jsConverter) classPrototypes(env napi.Env, value napi.Value) ([]napi.Value, error) {
+	objectProto, err := jc.objectPrototype(env)
+	if err != nil {
+		return nil, err
+	}
+	var protos []napi.Value
+	proto := value
+	for {
+		if proto, err = env.GetPrototype(proto); err != nil {
+			return nil, err
+		}
+		if protoType, err := env.Typeof(proto); err != nil || protoType != napi.Object {
+			return protos, err
+		}
+		if isObjectProto, err := env.StrictEquals(proto, objectProto); err != nil || isObjectProto {
+			return protos, err
+		}
+		protos = append(protos, proto)
+	}
+}
+
+// inheritedNames returns the names of the properties the object value inherits
+// from the prototypes of its class, other than constructor, that aren't
+// shadowed by its own properties, given by own.
+func (jc *jsConverter) inheritedNames(env napi.Env, value napi.Value, own []string) ([]string, error) {
+	protos, err := jc.classPrototypes(env, value)
+	if err != nil || len(protos) == 0 {
+		return nil, err
+	}
+	seen := map[string]bool{"constructor": true}
+	for _, name := range own {
+		seen[name] = true
+	}
+	var result []string
+	for _, proto := range protos {
+		names, err := ownPropertyNames(env, proto)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				result = append(result, name)
+			}
+		}
+	}
+	return result, nil
+}
+
+// property returns the property of the object value with the given name, if
+// it's either its own property or inherited from the prototypes of its class.
+func (jc *jsConverter) property(env napi.Env, value napi.Value, name string) (napi.Value, bool, error) {
+	key, err := env.CreateString(name)
+	if err != nil {
+		return nil, false, err
+	}
+	hasKey, err := env.HasOwnProperty(value, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if !hasKey && name != "constructor" {
+		protos, err := jc.classPrototypes(env, value)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, proto := range protos {
+			if hasKey, err = env.HasOwnProperty(proto, key); err != nil || hasKey {
+				break
+			}
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if !hasKey {
+		return nil, false, nil
+	}
+	result, err := env.GetProperty(value, key)
+	return result, err == nil, err
+}
+
+// mapToGo converts a Map with string keys to a Go map.
+func (jc *jsConverter) mapToGo(env napi.Env, value napi.Value) (map[string]interface{}, error) {
+	entries, err := callGlobalMethod(env, "Array", "from", value)
+	if err != nil {
+		return nil, err
+	}
+	length, err := env.GetArrayLength(entries)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{}, length)
+	for i := range length {
+		entry, err := env.GetElement(entries, i)
+		if err != nil {
+			return nil, err
+		}
+		key, err := env.GetElement(entry, 0)
+		if err != nil {
+			return nil, err
+		}
+		keyType, err := env.Typeof(key)
+		if err != nil {
+			return nil, err
+		}
+		if keyType != napi.String {
+			msg := fmt.Sprintf("Cannot convert %s: Map keys must be strings", formatPath(jc.keys))
+			return nil, throwConversionError(env, false, msg)
+		}
+		keyStr, err := jsStringToGo(env, key)
+		if err != nil {
+			return nil, err
+		}
+		elt, err := env.GetElement(entry, 1)
+		if err != nil {
+			return nil, err
+		}
+		omit, err := jc.omitted(env, elt)
+		if err != nil {
+			return nil, err
+		}
+		if omit {
+			continue
+		}
+		eltConv, err := jc.child(env, pathKey{name: keyStr}, elt)
+		if err != nil {
+			return nil, err
+		}
+		result[keyStr] = eltConv
+	}
+	return result, nil
+}
+
+// setToGo converts a Set to a Go slice, in iteration order.
+func (jc *jsConverter) setToGo(env napi.Env, value napi.Value) ([]interface{}, error) {
+	elems, err := callGlobalMethod(env, "Array", "from", value)
+	if err != nil {
+		return nil, err
+	}
+	length, err := env.GetArrayLength(elems)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, length)
+	for i := range length {
+		elt, err := env.GetElement(elems, i)
+		if err != nil {
+			return nil, err
+		}
+		eltConv, err := jc.child(env, pathKey{index: i, isIndex: true}, elt)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = eltConv
+	}
+	return result, nil
+}
+
+// lazyKind returns the kind of lazy value to wrap the object value in, or
+// false if it should be converted right away.
+func (jc *jsConverter) lazyKind(env napi.Env, value napi.Value) (lazyKind, bool, error) {
+	if jc.data == nil || jc.deep || !jc.opts.lazy {
+		return 0, false, nil
+	}
+	isArray, err := env.IsArray(value)
+	if err != nil || isArray {
+		return lazyArrayKind, isArray, err
+	}
+	isMap, isSet, err := jc.collectionKind(env, value)
+	switch {
+	case err != nil:
+		return 0, false, err
+	case isMap:
+		return lazyMapKind, true, nil
+	case isSet:
+		return lazySetKind, true, nil
+	}
+	return lazyObjectKind, true, nil
+}
+
+// toGo converts value to Go.
+func (jc *jsConverter) toGo(env napi.Env, value napi.Value) (interface{}, error) {
 	valueType, err := env.Typeof(value)
 	if err != nil {
 		return nil, err
 	}
 	switch valueType {
 	case napi.Undefined, napi.Null:
-		// TODO: Filter out Undefined from parent object, keep Null
+		// Undefined object properties are omitted by the caller
 		return nil, nil
 	case napi.Boolean:
 		return env.GetValueBool(value)
 	case napi.Number:
-		return env.GetValueDouble(value)
+		return jsNumberToGo(env, value, jc.opts)
 	case napi.String:
 		return jsStringToGo(env, value)
 	case napi.Object:
+		trusted, err := trustedWrapper.TryUnwrap(env, value)
+		if err != nil {
+			return nil, err
+		}
+		if trusted != nil {
+			return trusted.value, nil
+		}
+		prepared, err := preparedWrapper.TryUnwrap(env, value)
+		if err != nil {
+			return nil, err
+		}
+		if prepared != nil {
+			return prepared.value, nil
+		}
+		isDate, err := env.IsDate(value)
+		if err != nil {
+			return nil, err
+		}
+		if isDate {
+			return jsDateToGo(env, value)
+		}
+		isTypedArray, err := env.IsTypedArray(value)
+		if err != nil {
+			return nil, err
+		}
+		if isTypedArray {
+			return jsTypedArrayToGo(env, value)
+		}
+		isDataview, err := env.IsDataview(value)
+		if err != nil {
+			return nil, err
+		}
+		if isDataview {
+			// DataViews are untyped, so they're treated like Uint8Arrays
+			data, err := env.GetDataviewInfo(value)
+			if err != nil {
+				return nil, err
+			}
+			return copyTypedArray[byte](data), nil
+		}
+		method, err := jc.replacementMethod(env, value)
+		if err != nil {
+			return nil, err
+		}
+		if method == nil {
+			kind, ok, err := jc.lazyKind(env, value)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				// Lazy values are checked for cycles as they're filled in
+				if err := jc.checkDepth(env, len(jc.keys)); err != nil {
+					return nil, err
+				}
+				return jc.data.wrap(env, value, kind, jc.keys)
+			}
+		}
+		if err := jc.enter(env, value); err != nil {
+			return nil, err
+		}
+		defer jc.exit()
+		if method != nil {
+			replaced, err := jc.replacement(env, value, method)
+			if err != nil {
+				return nil, err
+			}
+			same, err := env.StrictEquals(replaced, value)
+			if err != nil {
+				return nil, err
+			}
+			if !same {
+				// Replacements that return further objects to replace are
+				// bounded by the depth limit, since this one is entered
+				return jc.toGo(env, replaced)
+			}
+		}
 		isArray, err := env.IsArray(value)
 		if err != nil {
 			return nil, err
 		}
-		if isArray {
+		var isMap, isSet bool
+		if !isArray {
+			if isMap, isSet, err = jc.collectionKind(env, value); err != nil {
+				return nil, err
+			}
+		}
+		if isMap {
+			return jc.mapToGo(env, value)
+		} else if isSet {
+			return jc.setToGo(env, value)
+		} else if isArray {
 			length, err := env.GetArrayLength(value)
 			if err != nil {
 				return nil, err
@@ -93,7 +805,7 @@ func jsValueToGo(env napi.Env, value napi.Value) (interface{}, error) {
 				if err != nil {
 					return nil, err
 				}
-				eltConv, err := jsValueToGo(env, elt)
+				eltConv, err := jc.child(env, pathKey{index: i, isIndex: true}, elt)
 				if err != nil {
 					return nil, err
 				}
@@ -102,39 +814,42 @@ func jsValueToGo(env napi.Env, value napi.Value) (interface{}, error) {
 			return result, nil
 		} else {
 			// TODO: Should any other object types get special handling?
-			propNames, err := env.GetAllPropertyNames(value, napi.KeyOwnOnly, napi.KeySkipSymbols, napi.KeyNumbersToStrings)
-			if err != nil {
-				return nil, err
-			}
-			length, err := env.GetArrayLength(propNames)
+			names, err := ownPropertyNames(env, value)
 			if err != nil {
 				return nil, err
 			}
 			result := map[string]interface{}{}
-			for i := range length {
-				// TODO: Scope?
-				key, err := env.GetElement(propNames, i)
-				if err != nil {
+			for _, name := range names {
+				if err := jc.objectProperty(env, value, name, result); err != nil {
 					return nil, err
 				}
-				keyStr, err := jsStringToGo(env, key)
-				if err != nil {
-					return nil, err
-				}
-				elt, err := env.GetProperty(value, key)
-				if err != nil {
-					return nil, err
-				}
-				eltConv, err := jsValueToGo(env, elt)
-				if err != nil {
+			}
+			// Instances of classes also get the getters and methods
+			// they inherit
+			inherited, err := jc.inheritedNames(env, value, names)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range inherited {
+				if err := jc.objectProperty(env, value, name, result); err != nil {
 					return nil, err
 				}
-				result[keyStr] = eltConv
+			}
+			if len(inherited) > 0 && jc.data != nil {
+				// Tracked so JS functions are passed the instance itself
+				return jc.data.wrapFilled(env, value, result, slices.Concat(names, inherited), jc.keys)
 			}
 			return result, nil
 		}
 	case napi.Bigint:
 		return jsBigintToGo(env, value)
+	case napi.Function:
+		// Bind the function to the object it was found on, if any
+		var owner napi.Value
+		if len(jc.ancestors) > 0 {
+			owner = jc.ancestors[len(jc.ancestors)-1]
+		}
+		return makeDataFunc(env, value, owner)
 	default:
 		// No useful way to map these to Go types
 		// TODO: More useful error message?
@@ -146,6 +861,29 @@ func jsValueToGo(env napi.Env, value napi.Value) (interface{}, error) {
 	}
 }
 
+// objectProperty converts the property of the object value with the given
+// name into result, unless it's omitted. The object must be the current one.
+func (jc *jsConverter) objectProperty(env napi.Env, value napi.Value, name string, result map[string]interface{}) error {
+	key, err := env.CreateString(name)
+	if err != nil {
+		return err
+	}
+	elt, err := env.GetProperty(value, key)
+	if err != nil {
+		return err
+	}
+	omit, err := jc.omitted(env, elt)
+	if err != nil || omit {
+		return err
+	}
+	eltConv, err := jc.child(env, pathKey{name: name}, elt)
+	if err != nil {
+		return err
+	}
+	result[name] = eltConv
+	return nil
+}
+
 func jsValuesToGo[T any](env napi.Env, value []napi.Value, conv func(napi.Env, napi.Value) (T, error)) ([]T, error) {
 	result := make([]T, len(value))
 	for i, element := range value {
@@ -158,9 +896,170 @@ func jsValuesToGo[T any](env napi.Env, value []napi.Value, conv func(napi.Env, n
 	return result, nil
 }
 
+// goBigIntToJs converts a big.Int to a JS BigInt.
+func goBigIntToJs(env napi.Env, value *big.Int) (napi.Value, error) {
+	// Convert from big-endian bytes to little-endian words
+	absBytes := value.Bytes()
+	words := make([]uint64, (len(absBytes)+7)/8)
+	for i, b := range slices.Backward(absBytes) {
+		shift := 8 * ((len(absBytes) - 1 - i) % 8)
+		words[(len(absBytes)-1-i)/8] |= uint64(b) << shift
+	}
+	signBit := 0
+	if value.Sign() < 0 {
+		signBit = 1
+	}
+	return env.CreateBigintWords(signBit, words)
+}
+
+// goMapKeyToJs returns the JS property name for a Go map key, following the
+// rules of encoding/json, plus support for fmt.Stringer keys.
+func goMapKeyToJs(key reflect.Value) (string, error) {
+	if key.Kind() == reflect.String {
+		return key.String(), nil
+	}
+	switch k := key.Interface().(type) {
+	case encoding.TextMarshaler:
+		text, err := k.MarshalText()
+		return string(text), err
+	}
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	}
+	if k, ok := key.Interface().(fmt.Stringer); ok {
+		return k.String(), nil
+	}
+	return "", fmt.Errorf("can't convert Go map key with type %s", key.Type())
+}
+
+// goFieldName returns the JS property name for a struct field, and whether
+// it's omitted when empty, according to its json tag. Fields that shouldn't
+// be converted have empty names.
+func goFieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	tag, hasTag := field.Tag.Lookup("json")
+	if tag == "-" {
+		return "", false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	if field.Anonymous && name == "" {
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() == reflect.Struct {
+			// Embedded structs' fields are promoted
+			return "", false
+		}
+	}
+	if !hasTag || name == "" {
+		name = field.Name
+	}
+	omitEmpty := slices.Contains(strings.Split(opts, ","), "omitempty")
+	return name, omitEmpty
+}
+
+// isEmptyGoValue reports whether value is empty for the purposes of the
+// omitempty json tag option.
+func isEmptyGoValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return value.Len() == 0
+	case reflect.Interface, reflect.Pointer:
+		return value.IsNil()
+	case reflect.Struct:
+		return false
+	}
+	return value.IsZero()
+}
+
+// isGoFunc reports whether value holds a Go function.
+func isGoFunc(value reflect.Value) bool {
+	if value.Kind() == reflect.Interface {
+		value = value.Elem()
+	}
+	return value.Kind() == reflect.Func
+}
+
+// maxGoValueDepth limits how deeply goValueToJs recurses, in case of cyclic
+// pointers.
+const maxGoValueDepth = 1000
+
 func goValueToJs(env napi.Env, value interface{}) (napi.Value, error) {
+	return goValueToJsDepth(env, value, nil, 0)
+}
+
+// goDataToJs converts a Go value passed to a JS function by a template, whose
+// data has the lazy values tracked by ld.
+func goDataToJs(env napi.Env, value interface{}, ld *lazyData) (napi.Value, error) {
+	return goValueToJsDepth(env, value, ld, 0)
+}
+
+func goValueToJsDepth(env napi.Env, value interface{}, ld *lazyData, depth int) (napi.Value, error) {
+	if depth > maxGoValueDepth {
+		return nil, fmt.Errorf("can't convert Go value nested more than %d levels deep", maxGoValueDepth)
+	}
+	if kind, str, ok := goTrustedKind(value); ok {
+		return createTrustedObject(env, kind, str)
+	}
+	switch v := value.(type) {
+	case []byte:
+		return env.CreateBufferCopy(v)
+	case time.Time:
+		return env.CreateDate(float64(v.UnixMilli()))
+	case time.Duration:
+		// JS represents durations as milliseconds
+		return env.CreateDouble(float64(v) / float64(time.Millisecond))
+	case map[string]any, []any:
+		// Lazy values, from the data or returned by JS functions, are
+		// passed as the JS values they came from
+		if state := ld.lookup(v); state != nil {
+			return env.GetReferenceValue(state.ref)
+		}
+	case *big.Int:
+		if v == nil {
+			return env.GetNull()
+		}
+		return goBigIntToJs(env, v)
+	case big.Int:
+		return goBigIntToJs(env, &v)
+	}
 	reflectValue := reflect.ValueOf(value)
 	switch reflectValue.Kind() {
+	case reflect.Pointer, reflect.Struct:
+		// Types with custom JSON representations, like semver versions, are
+		// converted using them
+		switch v := value.(type) {
+		case json.Marshaler:
+			if reflectValue.Kind() == reflect.Pointer && reflectValue.IsNil() {
+				return env.GetNull()
+			}
+			data, err := v.MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+			decoded, err := decodeJSONData(data, defaultConversionOptions)
+			if err != nil {
+				return nil, err
+			}
+			return goValueToJsDepth(env, decoded, ld, depth+1)
+		case encoding.TextMarshaler:
+			if reflectValue.Kind() == reflect.Pointer && reflectValue.IsNil() {
+				return env.GetNull()
+			}
+			text, err := v.MarshalText()
+			if err != nil {
+				return nil, err
+			}
+			return env.CreateString(string(text))
+		}
+	}
+	switch reflectValue.Kind() {
 	case reflect.Invalid:
 		return env.GetNull()
 	case reflect.Bool:
@@ -169,9 +1068,10 @@ func goValueToJs(env napi.Env, value interface{}) (napi.Value, error) {
 		return env.CreateInt64(reflectValue.Int())
 	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
 		return env.CreateUint32(uint32(reflectValue.Uint()))
-	// TODO: case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		// Always BigInts, so the type doesn't depend on the value
+		return goBigIntToJs(env, new(big.Int).SetUint64(reflectValue.Uint()))
 	case reflect.Float32, reflect.Float64:
-		// FIXME: Why does NaN wind up getting mapped to null here?
 		return env.CreateDouble(reflectValue.Float())
 	case reflect.Array, reflect.Slice:
 		arrayLen := reflectValue.Len()
@@ -180,7 +1080,7 @@ func goValueToJs(env napi.Env, value interface{}) (napi.Value, error) {
 			return nil, err
 		}
 		for i := range arrayLen {
-			jsVal, err := goValueToJs(env, reflectValue.Index(i).Interface())
+			jsVal, err := goValueToJsDepth(env, reflectValue.Index(i).Interface(), ld, depth+1)
 			if err != nil {
 				return nil, err
 			}
@@ -196,14 +1096,19 @@ func goValueToJs(env napi.Env, value interface{}) (napi.Value, error) {
 		}
 		propArray := make([]napi.PropertyDescriptor, 0, reflectValue.Len())
 		for mapKey, mapValue := range reflectValue.Seq2() {
-			if mapKey.Kind() != reflect.String {
-				return nil, fmt.Errorf("can't convert Go map key with type %s", mapKey.Type())
+			if isGoFunc(mapValue) {
+				// Omitted, like functions in JSON.stringify
+				continue
+			}
+			keyStr, err := goMapKeyToJs(mapKey)
+			if err != nil {
+				return nil, err
 			}
-			jsKey, err := env.CreateString(mapKey.String())
+			jsKey, err := env.CreateString(keyStr)
 			if err != nil {
 				return nil, err
 			}
-			jsValue, err := goValueToJs(env, mapValue.Interface())
+			jsValue, err := goValueToJsDepth(env, mapValue.Interface(), ld, depth+1)
 			if err != nil {
 				return nil, err
 			}
@@ -217,10 +1122,58 @@ func goValueToJs(env napi.Env, value interface{}) (napi.Value, error) {
 			return nil, err
 		}
 		return jsObj, nil
+	case reflect.Pointer:
+		if reflectValue.IsNil() {
+			return env.GetNull()
+		}
+		return goValueToJsDepth(env, reflectValue.Elem().Interface(), ld, depth+1)
 	case reflect.String:
 		return env.CreateString(reflectValue.String())
-	case reflect.Complex64, reflect.Complex128, reflect.Chan, reflect.Func,
-		reflect.Interface, reflect.Pointer, reflect.Struct, reflect.UnsafePointer:
+	case reflect.Struct:
+		// Convert exported fields like encoding/json, including those
+		// promoted from embedded structs
+		jsObj, err := env.CreateObject()
+		if err != nil {
+			return nil, err
+		}
+		var propArray []napi.PropertyDescriptor
+		for _, field := range reflect.VisibleFields(reflectValue.Type()) {
+			name, omitEmpty := goFieldName(field)
+			if name == "" {
+				continue
+			}
+			fieldValue, err := reflectValue.FieldByIndexErr(field.Index)
+			if err != nil || !fieldValue.CanInterface() {
+				// Promoted through a nil pointer, or an unexported type
+				continue
+			}
+			if (omitEmpty && isEmptyGoValue(fieldValue)) || isGoFunc(fieldValue) {
+				continue
+			}
+			jsKey, err := env.CreateString(name)
+			if err != nil {
+				return nil, err
+			}
+			jsValue, err := goValueToJsDepth(env, fieldValue.Interface(), ld, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			propArray = append(propArray, napi.PropertyDescriptor{
+				Name:       jsKey,
+				Value:      jsValue,
+				Attributes: napi.DefaultJsProperty,
+			})
+		}
+		if err := env.DefineProperties(jsObj, propArray); err != nil {
+			return nil, err
+		}
+		return jsObj, nil
+	case reflect.Func:
+		// Functions from the data can't be called from JS, so they're
+		// dropped like they are by JSON.stringify
+		return env.GetUndefined()
+	case reflect.Complex64, reflect.Complex128, reflect.Chan,
+		reflect.Interface, reflect.UnsafePointer:
 		fallthrough
 	default:
 		return nil, fmt.Errorf("can't convert Go value of type %s", reflectValue.Type())
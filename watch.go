@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/drakedevel/go-text-template-napi/internal/napi"
+)
+
+// watchSource records the arguments of a parseFiles or parseGlob call on a
+// watched template. Exactly one of files and glob is set.
+type watchSource struct {
+	files []string
+	glob  string
+}
+
+// parse parses the source's files into tmpl, returning their paths.
+func (ws watchSource) parse(tmpl goTemplate) ([]string, error) {
+	if ws.files != nil {
+		return ws.files, tmpl.ParseFiles(ws.files...)
+	}
+	err := tmpl.ParseGlob(ws.glob)
+	// This matches the files ParseGlob uses, unless they change in between
+	files, _ := filepath.Glob(ws.glob)
+	return files, err
+}
+
+// templateWatch holds the state of a template created by Template.watch, which
+// is reparsed whenever the files it was parsed from change. A fileWatcher
+// detects the changes, and a goroutine queues reloads on the JS thread.
+type templateWatch struct {
+	// setup holds the changes made to the template's functions and options,
+	// which are reapplied to each new set of templates before it's parsed
+	setup   []func(goTemplate)
+	sources []watchSource
+
+	// files is set once the watch is started
+	files *fileWatcher
+
+	// self keeps the template object, and the JS functions associated with
+	// it, alive until the watch is closed
+	self      napi.Ref
+	listeners map[string][]napi.Ref
+	stop      chan struct{}
+}
+
+func newTemplateWatch() *templateWatch {
+	return &templateWatch{
+		listeners: make(map[string][]napi.Ref),
+		stop:      make(chan struct{}),
+	}
+}
+
+func (tw *templateWatch) AddSource(source watchSource) error {
+	tw.sources = append(tw.sources, source)
+	if tw.files == nil {
+		return nil
+	}
+	return tw.files.SetSources(slices.Clone(tw.sources))
+}
+
+// Start begins watching the files for changes, calling reload on the JS thread
+// when they do. The interval is how often to check them, on platforms where
+// they're polled. The self object is kept alive until Close is called.
+func (tw *templateWatch) Start(env napi.Env, self napi.Value, interval time.Duration, reload func(napi.Env) error) error {
+	files, err := newFileWatcher(slices.Clone(tw.sources), interval)
+	if err != nil {
+		return err
+	}
+	selfRef, err := env.CreateReference(self, 1)
+	if err != nil {
+		files.Close()
+		return err
+	}
+	tsc, err := napi.NewThreadsafeCaller(env, "go-text-template-napi:watch")
+	if err != nil {
+		files.Close()
+		_ = env.DeleteReference(selfRef)
+		return err
+	}
+	tw.self = selfRef
+	tw.files = files
+	go tw.run(tsc, reload)
+	return nil
+}
+
+func (tw *templateWatch) run(tsc *napi.ThreadsafeCaller, reload func(napi.Env) error) {
+	// Swallow errors here since we can't do anything about them
+	defer func() { _ = tsc.Release() }()
+	defer tw.files.Close()
+	for {
+		select {
+		case <-tw.stop:
+			return
+		case <-tw.files.Changes():
+		}
+		_ = tsc.Call(func(env napi.Env, err error) {
+			if err != nil {
+				return
+			}
+			if err := reload(env); err != nil {
+				reportUncaught(env, err)
+			}
+		})
+	}
+}
+
+// reportUncaught reports an error that has no JS caller to receive it as an
+// uncaught exception.
+func reportUncaught(env napi.Env, err error) {
+	exc, err := errorToJs(env, captureJsException(env, err))
+	if err == nil {
+		_ = env.FatalException(exc)
+	}
+}
+
+// On adds a listener for a reload or error event.
+func (tw *templateWatch) On(env napi.Env, event string, listener napi.Value) error {
+	ref, err := env.CreateReference(listener, 1)
+	if err != nil {
+		return err
+	}
+	tw.listeners[event] = append(tw.listeners[event], ref)
+	return nil
+}
+
+// Emit calls the listeners for event with arg, and the template object as
+// this. An error event with no listeners is emitted as a process warning
+// instead: unlike with an EventEmitter, it isn't worth crashing the process
+// over, since the previous templates are still in place.
+func (tw *templateWatch) Emit(env napi.Env, event string, arg napi.Value) error {
+	listeners := tw.listeners[event]
+	if len(listeners) == 0 && event == "error" {
+		_, err := callGlobalMethod(env, "process", "emitWarning", arg)
+		return err
+	}
+	self, err := env.GetReferenceValue(tw.self)
+	if err != nil {
+		return err
+	}
+	for _, ref := range listeners {
+		listener, err := env.GetReferenceValue(ref)
+		if err != nil {
+			return err
+		}
+		if _, err := env.CallFunction(self, listener, []napi.Value{arg}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops checking for changes, and releases the template object and the
+// event listeners.
+func (tw *templateWatch) Close(env napi.Env) error {
+	close(tw.stop)
+	for _, listeners := range tw.listeners {
+		for _, ref := range listeners {
+			if err := env.DeleteReference(ref); err != nil {
+				return err
+			}
+		}
+	}
+	tw.listeners = nil
+	return env.DeleteReference(tw.self)
+}
+
+// recordSetup saves a change to the functions or options of a watched template,
+// so it's reapplied when the template is reparsed.
+func (jst *jsTemplate) recordSetup(fn func(goTemplate)) {
+	if jst.watch != nil {
+		jst.watch.setup = append(jst.watch.setup, fn)
+	}
+}
+
+// recordSource saves the arguments of a parseFiles or parseGlob call on a
+// watched template, so its files are watched and reparsed.
+func (jst *jsTemplate) recordSource(source watchSource) error {
+	if jst.watch == nil {
+		return nil
+	}
+	return jst.watch.AddSource(source)
+}
+
+// reloadWatched reparses a watched template into a new set of templates, and
+// switches every template in its association to the new set if that succeeds.
+// Either way, it emits an event with the result.
+func (jst *jsTemplate) reloadWatched(env napi.Env) error {
+	tw := jst.watch
+	if tw == nil {
+		// The watch was closed after this reload was queued
+		return nil
+	}
+	tmpl := jst.inner.Class().new(jst.inner.Name())
+	for _, setup := range tw.setup {
+		setup(tmpl)
+	}
+	var files []string
+	var parseErr error
+	for _, source := range tw.sources {
+		var sourceFiles []string
+		sourceFiles, parseErr = source.parse(tmpl)
+		files = append(files, sourceFiles...)
+		if parseErr != nil {
+			break
+		}
+	}
+	if parseErr != nil {
+		errValue, err := errorToJs(env, newParseError(parseErr, jst.assn.delims, parseFilesSource(files)))
+		if err != nil {
+			return err
+		}
+		return tw.Emit(env, "error", errValue)
+	}
+	jst.assn.ReplaceSet(tmpl)
+	jst.assn.AddFiles(files)
+	jst.assn.AddSources(tmpl, nil, jst.assn.delims, parseFilesSource(files))
+	self, err := env.GetReferenceValue(tw.self)
+	if err != nil {
+		return err
+	}
+	return tw.Emit(env, "reload", self)
+}
+
+func (jst *jsTemplate) methodOn(env napi.Env, args []napi.Value) (napi.Value, error) {
+	if jst.watch == nil {
+		// TODO: Custom error mechanism
+		if err := env.ThrowError("ERR_INVALID_STATE", "Template is not being watched"); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("threw exception")
+	}
+	event, err := jsStringToGo(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if event != "reload" && event != "error" {
+		excMsg := fmt.Sprintf("Unknown event '%s'", event)
+		if err := env.ThrowTypeError("ERR_INVALID_ARG_VALUE", excMsg); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("threw exception")
+	}
+	listenerType, err := env.Typeof(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if listenerType != napi.Function {
+		if err := env.ThrowTypeError("ERR_INVALID_ARG_TYPE", "Expected a function"); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("threw exception")
+	}
+	return nil, jst.watch.On(env, event, args[1])
+}
+
+func (jst *jsTemplate) methodClose(env napi.Env, args []napi.Value) (napi.Value, error) {
+	tw := jst.watch
+	if tw == nil {
+		return nil, nil
+	}
+	jst.watch = nil
+	return nil, tw.Close(env)
+}
+
+func (cls *templateClass) staticWatch(env napi.Env, args []napi.Value) (napi.Value, error) {
+	glob, err := jsStringToGo(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+	interval, err := getIntOption(env, args[1], "interval", 1000)
+	if err != nil {
+		return nil, err
+	}
+	if interval == 0 {
+		return nil, throwOptionTypeError(env, "interval", "a positive integer")
+	}
+	funcs, funcsType, err := getOption(env, args[1], "funcs")
+	if err != nil {
+		return nil, err
+	}
+	if funcsType != napi.Undefined && funcsType != napi.Object {
+		return nil, throwOptionTypeError(env, "funcs", "an object")
+	}
+
+	// Name the template after the first file, like ParseGlob
+	matches, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, err
+	}
+	assn := newTemplateAssn()
+	if len(matches) == 0 {
+		err := fmt.Errorf("template: pattern matches no files: %#q", glob)
+		return nil, newParseError(err, assn.delims, parseFilesSource(nil))
+	}
+	instance, err := wrapExistingTemplate(env, cls.new(filepath.Base(matches[0])), assn)
+	if err != nil {
+		return nil, err
+	}
+	jst, err := cls.wrapper.Unwrap(env, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	// The functions have to be added before parsing, so templates can use them
+	jst.watch = newTemplateWatch()
+	if funcsType != napi.Undefined {
+		if _, err := jst.methodFuncs(env, []napi.Value{funcs}); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := jst.methodParseGlob(env, args[:1]); err != nil {
+		return nil, err
+	}
+	if err := jst.watch.Start(env, instance, time.Duration(interval)*time.Millisecond, jst.reloadWatched); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
@@ -0,0 +1,212 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// watchEvents are the inotify events that can change the files of a watch.
+// Writes are picked up when the file is closed, so it isn't reparsed while
+// it's only partly written.
+const watchEvents = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// watchSettleTime is how long a fileWatcher waits for further events before
+// reporting a change, since files are often saved in several steps, or
+// several at once.
+const watchSettleTime = 50 * time.Millisecond
+
+// fileWatcher detects changes to the files of a watch with inotify. It watches
+// the directories containing them rather than the files themselves, to pick up
+// files that are replaced (as many editors do when saving) or that newly match
+// a glob.
+type fileWatcher struct {
+	fd      int
+	inotify *os.File
+	changes chan struct{}
+
+	// mu guards the fields below, which are read by the goroutine reading
+	// events
+	mu      sync.Mutex
+	sources []watchSource
+	dirs    map[int32]string
+	closed  bool
+}
+
+// newFileWatcher starts watching the files of sources. The interval is only
+// used on platforms where files are polled.
+func newFileWatcher(sources []watchSource, interval time.Duration) (*fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	fw := &fileWatcher{
+		fd: fd,
+		// The descriptor is non-blocking, so reads wait in the runtime's
+		// poller, and are interrupted by closing the file
+		inotify: os.NewFile(uintptr(fd), "inotify"),
+		changes: make(chan struct{}, 1),
+		dirs:    make(map[int32]string),
+	}
+	if err := fw.SetSources(sources); err != nil {
+		fw.Close()
+		return nil, err
+	}
+	go fw.read()
+	return fw, nil
+}
+
+// dirs returns the absolute paths of the directories that may contain the
+// source's files.
+func (ws watchSource) dirs() []string {
+	var dirs []string
+	if ws.files != nil {
+		for _, filename := range ws.files {
+			dirs = append(dirs, filepath.Dir(filename))
+		}
+	} else {
+		// The directory part of the pattern may have wildcards too
+		dirs, _ = filepath.Glob(filepath.Dir(ws.glob))
+	}
+	for i, dir := range dirs {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dirs[i] = abs
+		}
+	}
+	return dirs
+}
+
+// matches reports whether path, which is absolute, is one of the source's
+// files.
+func (ws watchSource) matches(path string) bool {
+	if ws.files != nil {
+		return slices.ContainsFunc(ws.files, func(filename string) bool {
+			abs, err := filepath.Abs(filename)
+			return err == nil && abs == path
+		})
+	}
+	pattern, err := filepath.Abs(ws.glob)
+	if err != nil {
+		return false
+	}
+	ok, _ := filepath.Match(pattern, path)
+	return ok
+}
+
+// SetSources replaces the sources whose files are watched.
+func (fw *fileWatcher) SetSources(sources []watchSource) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.closed {
+		return nil
+	}
+	fw.sources = sources
+	for _, source := range sources {
+		for _, dir := range source.dirs() {
+			// Watching a directory again returns the same descriptor
+			wd, err := syscall.InotifyAddWatch(fw.fd, dir, watchEvents|syscall.IN_ONLYDIR)
+			if err == syscall.ENOENT || err == syscall.ENOTDIR {
+				// Missing files are reported when the templates are parsed
+				continue
+			}
+			if err != nil {
+				return &os.PathError{Op: "watch", Path: dir, Err: err}
+			}
+			fw.dirs[int32(wd)] = dir
+		}
+	}
+	return nil
+}
+
+// Changes returns a channel that receives a value after the files change.
+// Changes made before the value is received are reported together.
+func (fw *fileWatcher) Changes() <-chan struct{} {
+	return fw.changes
+}
+
+func (fw *fileWatcher) notify() {
+	select {
+	case fw.changes <- struct{}{}:
+	default:
+	}
+}
+
+func (fw *fileWatcher) read() {
+	var settle *time.Timer
+	defer func() {
+		if settle != nil {
+			settle.Stop()
+		}
+	}()
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := fw.inotify.Read(buf)
+		if err != nil {
+			// Reads only fail once the watcher is closed
+			return
+		}
+		if !fw.relevant(buf[:n]) {
+			continue
+		}
+		if settle == nil {
+			settle = time.AfterFunc(watchSettleTime, fw.notify)
+		} else {
+			settle.Reset(watchSettleTime)
+		}
+	}
+}
+
+// relevant reports whether any of the inotify events in buf affect the files
+// of the watch.
+func (fw *fileWatcher) relevant(buf []byte) bool {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	result := false
+	for len(buf) >= syscall.SizeofInotifyEvent {
+		wd := int32(binary.NativeEndian.Uint32(buf[0:]))
+		mask := binary.NativeEndian.Uint32(buf[4:])
+		end := syscall.SizeofInotifyEvent + int(binary.NativeEndian.Uint32(buf[12:]))
+		if end > len(buf) {
+			break
+		}
+		name := strings.TrimRight(string(buf[syscall.SizeofInotifyEvent:end]), "\x00")
+		buf = buf[end:]
+
+		switch {
+		case mask&syscall.IN_Q_OVERFLOW != 0:
+			// Events were dropped, so assume the worst
+			result = true
+		case mask&syscall.IN_IGNORED != 0:
+			// The directory was removed
+			delete(fw.dirs, wd)
+		case name != "":
+			dir, ok := fw.dirs[wd]
+			if !ok {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			for _, source := range fw.sources {
+				result = result || source.matches(path)
+			}
+		}
+	}
+	return result
+}
+
+// Close stops watching the files.
+func (fw *fileWatcher) Close() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if !fw.closed {
+		fw.closed = true
+		_ = fw.inotify.Close()
+	}
+}
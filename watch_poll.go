@@ -0,0 +1,120 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileWatcher detects changes to the files of a watch by checking their
+// modification times and sizes every interval. Node's fs.watch isn't reachable
+// from the addon, and only inotify is implemented natively (see
+// watch_linux.go), so other platforms poll.
+type fileWatcher struct {
+	changes chan struct{}
+	stop    chan struct{}
+
+	// mu guards sources and baseline, which are read by the polling goroutine
+	mu      sync.Mutex
+	sources []watchSource
+
+	// baseline is the fingerprint of the files as of the last time the sources
+	// were replaced, which the polling goroutine compares against next instead
+	// of reporting a change for the new sources, or "" if it has already done
+	// so
+	baseline string
+}
+
+// newFileWatcher starts checking the files of sources for changes every
+// interval.
+func newFileWatcher(sources []watchSource, interval time.Duration) (*fileWatcher, error) {
+	fw := &fileWatcher{
+		changes: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	if err := fw.SetSources(sources); err != nil {
+		return nil, err
+	}
+	go fw.poll(interval)
+	return fw, nil
+}
+
+// paths returns the files the source currently refers to.
+func (ws watchSource) paths() []string {
+	if ws.files != nil {
+		return ws.files
+	}
+	matches, _ := filepath.Glob(ws.glob)
+	return matches
+}
+
+// fingerprint describes the files of sources, changing whenever one is added,
+// removed or modified.
+func fingerprint(sources []watchSource) string {
+	var sb strings.Builder
+	for _, source := range sources {
+		for _, filename := range source.paths() {
+			info, err := os.Stat(filename)
+			if err != nil {
+				fmt.Fprintf(&sb, "%s\x00missing\n", filename)
+				continue
+			}
+			fmt.Fprintf(&sb, "%s\x00%d\x00%d\n", filename, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return sb.String()
+}
+
+// SetSources replaces the sources whose files are watched.
+func (fw *fileWatcher) SetSources(sources []watchSource) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.sources = sources
+	fw.baseline = fingerprint(sources)
+	return nil
+}
+
+// Changes returns a channel that receives a value after the files change.
+// Changes made before the value is received are reported together.
+func (fw *fileWatcher) Changes() <-chan struct{} {
+	return fw.changes
+}
+
+func (fw *fileWatcher) poll(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last string
+	for {
+		select {
+		case <-fw.stop:
+			return
+		case <-ticker.C:
+		}
+		fw.mu.Lock()
+		sources := fw.sources
+		if fw.baseline != "" {
+			last = fw.baseline
+			fw.baseline = ""
+		}
+		fw.mu.Unlock()
+		current := fingerprint(sources)
+		if current == last {
+			continue
+		}
+		last = current
+		select {
+		case fw.changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close stops checking the files.
+func (fw *fileWatcher) Close() {
+	close(fw.stop)
+}